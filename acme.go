@@ -0,0 +1,207 @@
+package jwks
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ACME (RFC 8555) account key utilities.
+// ACME requests are JWSs in the flattened JSON serialization. The protected header names the account either by its kid (the account URL), or, before there is an account, by carrying its public key as a jwk.
+
+// ACMEJWS is a JWS in the flattened JSON serialization (RFC 7515 §7.2.2), as ACME request bodies are
+type ACMEJWS struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// ACMEHeader is the protected header of an ACME request (RFC 8555 §6.2). Exactly one of JWK and KeyID is set.
+type ACMEHeader struct {
+	Algorithm string `json:"alg"`
+	Nonce     string `json:"nonce,omitempty"` // Absent only in keyChange's inner JWS
+	URL       string `json:"url"`
+	JWK       *JWK   `json:"jwk,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// ACMEKeyChange is the payload of keyChange's inner JWS (RFC 8555 §7.3.5)
+type ACMEKeyChange struct {
+	Account string `json:"account"`
+	OldKey  *JWK   `json:"oldKey"`
+}
+
+// ACMEAccount signs ACME requests with an account key
+type ACMEAccount struct {
+	// KeyID is the account URL, from the Location of the newAccount response.
+	// Until it's set, requests carry the account's public key as a jwk, as newAccount requests must.
+	KeyID string
+
+	signer *jwsSigner
+}
+
+// NewACMEAccount makes an account for key, which is a private key, eg from PEM2Keys, or a *JWK holding one.
+// The alg is chosen as for NewDPoPSigner.
+func NewACMEAccount(key any) (*ACMEAccount, error) {
+	js, err := newJWSSigner(key, "ACME")
+	if err != nil {
+		return nil, err
+	}
+	return &ACMEAccount{signer: js}, nil
+}
+
+// JWK is the account's public key
+func (a *ACMEAccount) JWK() *JWK {
+	return a.signer.jwk
+}
+
+// Thumbprint is the base64url SHA-256 thumbprint of the account key (RFC 8555 §8.1)
+func (a *ACMEAccount) Thumbprint() (string, error) {
+	return a.signer.thumbprint()
+}
+
+// KeyAuthorization is the response to a challenge with the given token: token || '.' || thumbprint (RFC 8555 §8.1)
+func (a *ACMEAccount) KeyAuthorization(token string) (string, error) {
+	tp, err := a.Thumbprint()
+	if err != nil {
+		return "", err
+	}
+	return token + "." + tp, nil
+}
+
+// DNS01Value is the contents of the _acme-challenge TXT record for a dns-01 challenge with the given token (RFC 8555 §8.4)
+func (a *ACMEAccount) DNS01Value(token string) (string, error) {
+	ka, err := a.KeyAuthorization(token)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(ka))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// Sign makes a request body for url. A nil payload makes a POST-as-GET (RFC 8555 §6.3).
+func (a *ACMEAccount) Sign(url, nonce string, payload []byte) ([]byte, error) {
+	h := ACMEHeader{Algorithm: a.signer.method.Alg(), Nonce: nonce, URL: url}
+	if a.KeyID != "" {
+		h.KeyID = a.KeyID
+	} else {
+		h.JWK = a.signer.jwk
+	}
+	return signACMEJWS(a.signer, h, payload)
+}
+
+// KeyChange makes the body of a key rollover request to url, the server's keyChange endpoint (RFC 8555 §7.3.5).
+// newKey is as for NewACMEAccount. Once the server's accepted it, carry on with the returned account.
+func (a *ACMEAccount) KeyChange(url, nonce string, newKey any) ([]byte, *ACMEAccount, error) {
+	if a.KeyID == "" {
+		return nil, nil, fmt.Errorf("key change needs an account; KeyID isn't set")
+	}
+	next, err := NewACMEAccount(newKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	next.KeyID = a.KeyID
+
+	payload, err := json.Marshal(ACMEKeyChange{Account: a.KeyID, OldKey: a.signer.jwk})
+	if err != nil {
+		return nil, nil, err
+	}
+	// The inner JWS is signed by the new key, and has no nonce
+	inner, err := signACMEJWS(next.signer, ACMEHeader{Algorithm: next.signer.method.Alg(), URL: url, JWK: next.signer.jwk}, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	outer, err := a.Sign(url, nonce, inner)
+	if err != nil {
+		return nil, nil, err
+	}
+	return outer, next, nil
+}
+
+func signACMEJWS(s *jwsSigner, h ACMEHeader, payload []byte) ([]byte, error) {
+	header, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	j := ACMEJWS{
+		Protected: base64.RawURLEncoding.EncodeToString(header),
+		Payload:   base64.RawURLEncoding.EncodeToString(payload),
+	}
+	sig, err := s.method.Sign(j.Protected+"."+j.Payload, s.key)
+	if err != nil {
+		return nil, err
+	}
+	j.Signature = base64.RawURLEncoding.EncodeToString(sig)
+	return json.Marshal(j)
+}
+
+// ParseACMEJWS parses and verifies an ACME request body, for servers.
+// The signature is checked with the jwk header's key, or if there's a kid instead, with the key that lookup returns for it.
+// lookup may be nil if only jwk requests are expected.
+func ParseACMEJWS(body []byte, lookup func(kid string) (any, error)) (*ACMEHeader, []byte, error) {
+	j := ACMEJWS{}
+	if err := json.Unmarshal(body, &j); err != nil {
+		return nil, nil, err
+	}
+
+	bs, err := base64.RawURLEncoding.DecodeString(j.Protected)
+	if err != nil {
+		return nil, nil, &InvalidParameterError{Member: "protected", Err: err}
+	}
+	h := &ACMEHeader{}
+	if err := json.Unmarshal(bs, h); err != nil {
+		return nil, nil, &InvalidParameterError{Member: "protected", Err: err}
+	}
+	if h.URL == "" {
+		return nil, nil, missingParameter("url")
+	}
+	// RFC 8555 §6.2: no MACs, and no "none"
+	if h.Algorithm == "" || h.Algorithm == "none" || strings.HasPrefix(h.Algorithm, "HS") {
+		return nil, nil, &InvalidParameterError{Member: "alg", Err: fmt.Errorf("%q isn't allowed", h.Algorithm)}
+	}
+	method := jwt.GetSigningMethod(h.Algorithm)
+	if method == nil {
+		return nil, nil, &InvalidParameterError{Member: "alg", Err: fmt.Errorf("unknown algorithm %s", h.Algorithm)}
+	}
+
+	var key any
+	switch {
+	case h.JWK != nil && h.KeyID != "":
+		return nil, nil, &InvalidParameterError{Member: "protected", Err: fmt.Errorf("jwk and kid are mutually exclusive")}
+	case h.JWK != nil:
+		if priv, err := KeyIsPrivateErr(h.JWK.Key); err != nil {
+			return nil, nil, err
+		} else if priv {
+			return nil, nil, &InvalidParameterError{Member: "jwk", Err: fmt.Errorf("must be a public key")}
+		}
+		key = h.JWK.Key
+	case h.KeyID != "":
+		if lookup == nil {
+			return nil, nil, &InvalidParameterError{Member: "kid", Err: fmt.Errorf("not expected here")}
+		}
+		key, err = lookup(h.KeyID)
+		if err != nil {
+			return nil, nil, &InvalidParameterError{Member: "kid", Err: err}
+		}
+	default:
+		return nil, nil, missingParameter("jwk or kid")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(j.Signature)
+	if err != nil {
+		return nil, nil, &InvalidParameterError{Member: "signature", Err: err}
+	}
+	if err := method.Verify(j.Protected+"."+j.Payload, sig, key); err != nil {
+		return nil, nil, &InvalidParameterError{Member: "signature", Err: err}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(j.Payload)
+	if err != nil {
+		return nil, nil, &InvalidParameterError{Member: "payload", Err: err}
+	}
+	return h, payload, nil
+}
@@ -0,0 +1,236 @@
+package jwks
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// acmeStandIn is just enough of an ACME server to exercise account keys: nonces, newAccount, POST-as-GET of the account, and keyChange
+type acmeStandIn struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	nonce    int
+	nonces   map[string]bool
+	accounts map[string]any // kid -> public key
+}
+
+func newACMEStandIn() *acmeStandIn {
+	s := &acmeStandIn{nonces: map[string]bool{}, accounts: map[string]any{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/new-nonce", func(w http.ResponseWriter, r *http.Request) {
+		s.newNonce(w)
+	})
+	mux.HandleFunc("/new-account", func(w http.ResponseWriter, r *http.Request) {
+		h, _, ok := s.verify(w, r, false)
+		if !ok {
+			return
+		}
+		s.mu.Lock()
+		kid := s.URL + "/acct/" + strconv.Itoa(len(s.accounts)+1)
+		s.accounts[kid] = h.JWK.Key
+		s.mu.Unlock()
+		w.Header().Set("Location", kid)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"status":"valid"}`)
+	})
+	mux.HandleFunc("/acct/", func(w http.ResponseWriter, r *http.Request) {
+		if _, payload, ok := s.verify(w, r, true); ok {
+			if len(payload) != 0 {
+				http.Error(w, "expected POST-as-GET", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"status":"valid"}`)
+		}
+	})
+	mux.HandleFunc("/key-change", func(w http.ResponseWriter, r *http.Request) {
+		outer, payload, ok := s.verify(w, r, true)
+		if !ok {
+			return
+		}
+		inner, innerPayload, err := ParseACMEJWS(payload, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		kc := ACMEKeyChange{}
+		if err := json.Unmarshal(innerPayload, &kc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if inner.URL != outer.URL || inner.Nonce != "" || kc.Account != outer.KeyID || !s.accounts[outer.KeyID].(actualPublic).Equal(kc.OldKey.Key) {
+			http.Error(w, "malformed keyChange", http.StatusBadRequest)
+			return
+		}
+		s.accounts[outer.KeyID] = inner.JWK.Key
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *acmeStandIn) newNonce(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nonce++
+	n := base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(s.nonce)))
+	s.nonces[n] = true
+	w.Header().Set("Replay-Nonce", n)
+}
+
+func (s *acmeStandIn) verify(w http.ResponseWriter, r *http.Request, wantKID bool) (*ACMEHeader, []byte, bool) {
+	s.newNonce(w) // before anything writes the header
+	body, _ := io.ReadAll(r.Body)
+	h, payload, err := ParseACMEJWS(body, func(kid string) (any, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if k, ok := s.accounts[kid]; ok {
+			return k, nil
+		}
+		return nil, fmt.Errorf("no such account")
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.nonces[h.Nonce] {
+		http.Error(w, "badNonce", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	delete(s.nonces, h.Nonce)
+	if h.URL != s.URL+r.URL.Path {
+		http.Error(w, "wrong url", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	if wantKID != (h.KeyID != "") {
+		http.Error(w, "wrong kind of key identification", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	return h, payload, true
+}
+
+func TestACME(t *testing.T) {
+	srv := newACMEStandIn()
+	defer srv.Close()
+
+	nonce := ""
+	post := func(path string, body []byte) *http.Response {
+		resp, err := http.Post(srv.URL+path, "application/jose+json", bytes.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		nonce = resp.Header.Get("Replay-Nonce")
+		return resp
+	}
+	resp, err := http.Head(srv.URL + "/new-nonce")
+	require.NoError(t, err)
+	nonce = resp.Header.Get("Replay-Nonce")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	acct, err := NewACMEAccount(key)
+	require.NoError(t, err)
+
+	// Register, with the jwk
+	body, err := acct.Sign(srv.URL+"/new-account", nonce, []byte(`{"termsOfServiceAgreed":true}`))
+	require.NoError(t, err)
+	resp = post("/new-account", body)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	acct.KeyID = resp.Header.Get("Location")
+
+	// The jwk must only be used for newAccount
+	body, err = (&ACMEAccount{signer: acct.signer}).Sign(acct.KeyID, nonce, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, post("/acct/1", body).StatusCode)
+
+	// POST-as-GET, with the kid
+	body, err = acct.Sign(acct.KeyID, nonce, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, post("/acct/1", body).StatusCode)
+
+	// Nonces can't be reused
+	require.Equal(t, http.StatusBadRequest, post("/acct/1", body).StatusCode)
+
+	// Roll over to an RSA key; the old one then stops working
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	body, next, err := acct.KeyChange(srv.URL+"/key-change", nonce, newKey)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, post("/key-change", body).StatusCode)
+
+	body, err = acct.Sign(acct.KeyID, nonce, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, post("/acct/1", body).StatusCode)
+	body, err = next.Sign(next.KeyID, nonce, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, post("/acct/1", body).StatusCode)
+}
+
+func TestACMEKeyAuthorization(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	acct, err := NewACMEAccount(key)
+	require.NoError(t, err)
+
+	tp, err := (&JWK{Key: &key.PublicKey}).Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	const token = "evaGxfADs6pSRb2LAv9IZf17Dt3juxGJ-PCt92wr-oA"
+	ka, err := acct.KeyAuthorization(token)
+	require.NoError(t, err)
+	require.Equal(t, token+"."+base64.RawURLEncoding.EncodeToString(tp), ka)
+
+	txt, err := acct.DNS01Value(token)
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(ka))
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:]), txt)
+}
+
+func TestParseACMEJWS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	acct, err := NewACMEAccount(key)
+	require.NoError(t, err)
+	body, err := acct.Sign("https://example.com/acme/new-account", "n", []byte(`{}`))
+	require.NoError(t, err)
+
+	h, payload, err := ParseACMEJWS(body, nil)
+	require.NoError(t, err)
+	require.Equal(t, "ES256", h.Algorithm)
+	require.Equal(t, "{}", string(payload))
+
+	// Tampered
+	j := ACMEJWS{}
+	require.NoError(t, json.Unmarshal(body, &j))
+	j.Payload = base64.RawURLEncoding.EncodeToString([]byte(`{"onlyReturnExisting":true}`))
+	tampered, err := json.Marshal(j)
+	require.NoError(t, err)
+	_, _, err = ParseACMEJWS(tampered, nil)
+	require.ErrorIs(t, err, ErrInvalidParameter)
+	require.ErrorContains(t, err, "signature")
+
+	// kid with nowhere to look it up
+	acct.KeyID = "https://example.com/acme/acct/1"
+	body, err = acct.Sign("https://example.com/acme/acct/1", "n", nil)
+	require.NoError(t, err)
+	_, _, err = ParseACMEJWS(body, nil)
+	require.ErrorContains(t, err, "kid")
+}
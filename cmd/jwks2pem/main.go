@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -33,15 +34,21 @@ func main() {
 		os.Exit(0)
 	}
 
-	bytes, err := io.ReadAll(os.Stdin)
-	if err != nil {
-		panic(err)
-	}
+	// Stream, so that huge sets don't have to be held in memory
+	dec := jwks.NewDecoder(os.Stdin)
+	for {
+		key, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			panic(err)
+		}
 
-	pem, err := jwks.PEM2JWKS(bytes)
-	if err != nil {
-		panic(err)
+		pem, err := jwks.Keys2PEM([]any{key.Key})
+		if err != nil {
+			panic(err)
+		}
+		os.Stdout.Write(pem) // pem already has a trailing newline
 	}
-
-	fmt.Print(string(pem)) // pem already has a trailing newline
 }
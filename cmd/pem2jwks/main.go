@@ -5,9 +5,14 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/jessevdk/go-flags"
 
@@ -18,9 +23,27 @@ import (
 func main() {
 
 	var opts struct {
-		Singleton bool `short:"1" long:"singleton" description:"Output only a single JWK rather than an array of them (a JWKS)"`
-		Private   bool `short:"p" long:"private" description:"Include private key parameters in output. If not specified then supplying a private key will extract just the public fields from it"`
-		Version   bool `short:"v" long:"version" description:"Print version information and exit"`
+		Singleton bool   `short:"1" long:"singleton" description:"Output only a single JWK rather than an array of them (a JWKS)"`
+		Private   bool   `short:"p" long:"private" description:"Include private key parameters in output. If not specified then supplying a private key will extract just the public fields from it"`
+		Minimal   bool   `short:"m" long:"minimal" description:"With --private, render RSA keys with just n, e, and d, omitting the primes and CRT parameters. Multi-prime keys are always rendered in full"`
+		Format    string `short:"f" long:"format" choice:"jwk" choice:"cose" choice:"istio" choice:"envoy" default:"jwk" description:"Output format. cose emits binary CBOR COSE_Key[Set] (RFC 9052) rather than JSON JWK[S]. istio emits a RequestAuthentication manifest, and envoy a jwt_authn HTTP filter, verifying JWTs signed by the keys"`
+		Indent    int    `short:"i" long:"indent" description:"Pretty-print JSON output, indenting by this many spaces"`
+		Canonical bool   `short:"c" long:"canonical" description:"Output RFC 8785 canonical JSON, suitable for hashing and signing"`
+		Sort      string `short:"s" long:"sort" choice:"none" choice:"kid" choice:"thumbprint" default:"none" description:"Order of keys in the output set"`
+		KeyID     string `short:"k" long:"kid" choice:"none" choice:"thumbprint" choice:"kubernetes" default:"none" description:"Give keys kids: their RFC 7638 SHA-256 thumbprints, or as kube-apiserver does for service account keys (which also sets use and alg to match)"`
+		Version   bool   `short:"v" long:"version" description:"Print version information and exit"`
+
+		Mesh struct {
+			Issuer        string            `long:"issuer" description:"iss of the JWTs to verify"`
+			Audiences     []string          `long:"audience" description:"An aud the JWTs may have. May be repeated"`
+			JWKSURI       string            `long:"jwks-uri" description:"Have the proxy fetch the keys from this URL, rather than putting them inline. Nothing is read from stdin"`
+			ForwardToken  bool              `long:"forward-token" description:"Keep the JWT in requests sent upstream"`
+			PayloadHeader string            `long:"payload-header" description:"Send the JWT's payload upstream in this header"`
+			Name          string            `long:"name" default:"jwt" description:"Name of the RequestAuthentication, or of the Envoy provider"`
+			Namespace     string            `long:"namespace" description:"Namespace of the RequestAuthentication"`
+			Selector      map[string]string `long:"selector" description:"Label (key:value) of the workloads the RequestAuthentication applies to. May be repeated. Default is the whole namespace"`
+			EnvoyCluster  string            `long:"envoy-cluster" description:"Envoy cluster to fetch --jwks-uri through"`
+		} `group:"Istio and Envoy Options"`
 	}
 	flagParser := flags.NewParser(&opts, flags.Default)
 	rest, err := flagParser.Parse()
@@ -39,40 +62,137 @@ func main() {
 		os.Exit(0)
 	}
 
-	bytes, err := io.ReadAll(os.Stdin)
-	if err != nil {
-		panic(err)
+	mesh := opts.Format == "istio" || opts.Format == "envoy"
+	if mesh && opts.Mesh.JWKSURI != "" {
+		printMesh(opts.Format, &jwks.JWTProvider{
+			Name:                  opts.Mesh.Name,
+			Issuer:                opts.Mesh.Issuer,
+			Audiences:             opts.Mesh.Audiences,
+			KeysURI:               opts.Mesh.JWKSURI,
+			ForwardOriginalToken:  opts.Mesh.ForwardToken,
+			OutputPayloadToHeader: opts.Mesh.PayloadHeader,
+			EnvoyCluster:          opts.Mesh.EnvoyCluster,
+		}, opts.Mesh.Namespace, opts.Mesh.Selector)
+		os.Exit(0)
 	}
 
-	keys, err := jwks.PEM2Keys(bytes)
+	input, err := io.ReadAll(os.Stdin)
 	if err != nil {
 		panic(err)
 	}
 
+	var set *jwks.JWKS
+	if bytes.HasPrefix(bytes.TrimSpace(input), []byte("{")) {
+		// already a JWKS, eg to re-render it, or turn it into mesh config
+		set = &jwks.JWKS{}
+		if err := json.Unmarshal(input, set); err != nil {
+			panic(err)
+		}
+	} else {
+		keys, err := jwks.PEM2Keys(input)
+		if err != nil {
+			panic(err)
+		}
+		set, err = jwks.Keys2JWKSMarshaler(keys)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if opts.Singleton && len(set.Keys) != 1 {
+		panic("--singleton requires input containing precisely one key")
+	}
+
+	switch opts.KeyID {
+	case "thumbprint":
+		for _, k := range set.Keys {
+			tp, err := k.Thumbprint(crypto.SHA256)
+			if err != nil {
+				panic(err)
+			}
+			k.KeyID = base64.RawURLEncoding.EncodeToString(tp)
+		}
+	case "kubernetes":
+		for _, k := range set.Keys {
+			kube, err := jwks.Keys2KubernetesJWKS([]any{k.Key})
+			if err != nil {
+				panic(err)
+			}
+			k.KeyID, k.Use, k.Algorithm = kube.Keys[0].KeyID, kube.Keys[0].Use, kube.Keys[0].Algorithm
+		}
+	}
 	if !opts.Private {
-		pubKeys := make([]any, 0, len(keys))
-		for _, key := range keys {
-			pubKey := jwks.KeyPublicPart(key)
-			pubKeys = append(pubKeys, pubKey)
+		set, err = set.PublicOnly()
+		if err != nil {
+			panic(err)
 		}
-		keys = pubKeys
 	}
 
-	if opts.Singleton {
-		if len(keys) != 1 {
-			panic("--singleton requires input PEM containing precisely one key")
+	if mesh {
+		printMesh(opts.Format, &jwks.JWTProvider{
+			Name:                  opts.Mesh.Name,
+			Issuer:                opts.Mesh.Issuer,
+			Audiences:             opts.Mesh.Audiences,
+			Keys:                  set,
+			ForwardOriginalToken:  opts.Mesh.ForwardToken,
+			OutputPayloadToHeader: opts.Mesh.PayloadHeader,
+		}, opts.Mesh.Namespace, opts.Mesh.Selector)
+		os.Exit(0)
+	}
+
+	if opts.Format == "cose" {
+		coseSet, err := jwks.MarshalOptions{AllowPrivate: opts.Private}.JWKS2COSEKeySet(set)
+		if err != nil {
+			panic(err)
+		}
+		var bs []byte
+		if opts.Singleton {
+			bs, err = coseSet.Keys[0].MarshalCBOR()
+		} else {
+			bs, err = coseSet.MarshalCBOR()
 		}
-		str, err := jwks.Key2JWK(keys[0])
 		if err != nil {
 			panic(err)
 		}
-		fmt.Println(str)
+		os.Stdout.Write(bs)
 		os.Exit(0)
 	}
 
-	str, err := jwks.Keys2JWKS(keys)
+	marshalOpts := jwks.MarshalOptions{
+		Indent:       strings.Repeat(" ", opts.Indent),
+		Canonical:    opts.Canonical,
+		AllowPrivate: opts.Private,
+		MinimalRSA:   opts.Minimal,
+	}
+	switch opts.Sort {
+	case "kid":
+		marshalOpts.SortBy = jwks.KeyOrderKeyID
+	case "thumbprint":
+		marshalOpts.SortBy = jwks.KeyOrderThumbprint
+	}
+
+	var out any = set
+	if opts.Singleton {
+		out = set.Keys[0]
+	}
+	bs, err := marshalOpts.Marshal(out)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(bs))
+}
+
+func printMesh(format string, p *jwks.JWTProvider, namespace string, selector map[string]string) {
+	var bs []byte
+	var err error
+	switch format {
+	case "istio":
+		bs, err = jwks.IstioRequestAuthentication(p.Name, namespace, selector, p)
+	case "envoy":
+		bs, err = jwks.EnvoyJWTAuthn(p)
+	}
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println(str)
+	os.Stdout.Write(bs)
 }
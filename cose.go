@@ -0,0 +1,690 @@
+package jwks
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+
+	"github.com/mt-inside/go-jwks/internal/cbor"
+
+	"github.com/mt-inside/go-jwks/internal/secp256k1"
+)
+
+// COSE_Key (RFC 9052 §7) is CBOR's answer to JWK, used by CWT and WebAuthn.
+// The types here mirror JWK/JWKS: they wrap a stdlib crypto key, and know how to [un]marshal it.
+// Members are identified by integer labels rather than names; see the IANA "COSE Key Common Parameters" and "COSE Key Type Parameters" registries.
+
+type COSEKey struct {
+	KeyID []byte // kid is a bstr in COSE, rather than a string
+	Key   any
+	// Algorithm is a COSE algorithm identifier, eg -7 for ES256. 0 means absent.
+	Algorithm int64
+}
+
+type COSEKeySet struct {
+	Keys []*COSEKey
+}
+
+// Common parameters
+const (
+	coseLabelKty = int64(1)
+	coseLabelKid = int64(2)
+	coseLabelAlg = int64(3)
+)
+
+// kty values
+const (
+	coseKtyOKP = int64(1)
+	coseKtyEC2 = int64(2)
+	coseKtyRSA = int64(3)
+)
+
+// Type-specific parameters. These overlap, as they're namespaced by kty
+const (
+	coseLabelEC2Crv = int64(-1)
+	coseLabelEC2X   = int64(-2)
+	coseLabelEC2Y   = int64(-3)
+	coseLabelEC2D   = int64(-4)
+
+	coseLabelOKPCrv = int64(-1)
+	coseLabelOKPX   = int64(-2)
+	coseLabelOKPD   = int64(-4)
+
+	coseLabelRSAN     = int64(-1)
+	coseLabelRSAE     = int64(-2)
+	coseLabelRSAD     = int64(-3)
+	coseLabelRSAP     = int64(-4)
+	coseLabelRSAQ     = int64(-5)
+	coseLabelRSADp    = int64(-6)
+	coseLabelRSADq    = int64(-7)
+	coseLabelRSAQinv  = int64(-8)
+	coseLabelRSAOther = int64(-9) // Array of maps of the following three, for multi-prime keys
+	coseLabelRSARi    = int64(-10)
+	coseLabelRSADi    = int64(-11)
+	coseLabelRSATi    = int64(-12)
+)
+
+// IANA COSE Elliptic Curves registry. Note there's no P-224
+var coseCurves = map[string]int64{
+	"P-256":     1,
+	"P-384":     2,
+	"P-521":     3,
+	"X448":      5,
+	"Ed25519":   6,
+	"Ed448":     7,
+	"secp256k1": 8, // RFC 8812 §3.1
+}
+
+// IANA COSE Algorithms registry, keyed by the equivalent JOSE alg name
+var coseAlgorithms = map[string]int64{
+	"ES256":  -7,
+	"ES384":  -35,
+	"ES512":  -36,
+	"ES256K": -47,
+	"EdDSA":  -8,
+	"PS256":  -37,
+	"PS384":  -38,
+	"PS512":  -39,
+	"RS256":  -257,
+	"RS384":  -258,
+	"RS512":  -259,
+}
+
+// COSEAlgorithm returns the COSE identifier for a JOSE alg name, eg "ES256" -> -7
+func COSEAlgorithm(jose string) (int64, bool) {
+	id, ok := coseAlgorithms[jose]
+	return id, ok
+}
+
+// JOSEAlgorithm returns the JOSE alg name for a COSE identifier, eg -7 -> "ES256"
+func JOSEAlgorithm(cose int64) (string, bool) {
+	for name, id := range coseAlgorithms {
+		if id == cose {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// ===
+// crypto.Key -> CBOR
+// ===
+
+func (k *COSEKey) MarshalCBOR() ([]byte, error) {
+	m, err := k.toMap()
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(m)
+}
+
+func (k *COSEKey) toMap() (cbor.Map, error) {
+	var m cbor.Map
+	var err error
+	switch typedKey := k.Key.(type) {
+	case *rsa.PublicKey:
+		m = renderCOSERsaPublicKey(typedKey)
+	case *rsa.PrivateKey:
+		m, err = renderCOSERsaPrivateKey(typedKey)
+	case *ecdsa.PublicKey:
+		m, err = renderCOSEEcdsaPublicKey(typedKey)
+	case *ecdsa.PrivateKey:
+		m, err = renderCOSEEcdsaPrivateKey(typedKey)
+	case ed25519.PublicKey:
+		m = renderCOSEOkpKey("Ed25519", typedKey, nil)
+	case ed25519.PrivateKey:
+		m = renderCOSEOkpKey("Ed25519", typedKey.Public().(ed25519.PublicKey), typedKey.Seed())
+	case Ed448PublicKey:
+		m = renderCOSEOkpKey("Ed448", typedKey, nil)
+	case Ed448PrivateKey:
+		m = renderCOSEOkpKey("Ed448", typedKey[Ed448KeySize:], typedKey[:Ed448KeySize])
+	case X448PublicKey:
+		m = renderCOSEOkpKey("X448", typedKey, nil)
+	case X448PrivateKey:
+		m = renderCOSEOkpKey("X448", typedKey[X448KeySize:], typedKey[:X448KeySize])
+	default:
+		return nil, &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("%T", k.Key), Format: "COSE_Key"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(k.KeyID) != 0 {
+		m[coseLabelKid] = k.KeyID
+	}
+	if k.Algorithm != 0 {
+		m[coseLabelAlg] = k.Algorithm
+	}
+	return m, nil
+}
+
+func (s *COSEKeySet) MarshalCBOR() ([]byte, error) {
+	arr := make([]any, 0, len(s.Keys))
+	for i, k := range s.Keys {
+		m, err := k.toMap()
+		if err != nil {
+			return nil, &KeyError{Index: i, KeyID: string(k.KeyID), Err: err}
+		}
+		arr = append(arr, m)
+	}
+	return cbor.Marshal(arr)
+}
+
+func renderCOSERsaPublicKey(k *rsa.PublicKey) cbor.Map {
+	return cbor.Map{
+		coseLabelKty:  coseKtyRSA,
+		coseLabelRSAN: k.N.Bytes(),
+		coseLabelRSAE: big.NewInt(int64(k.E)).Bytes(),
+	}
+}
+
+func renderCOSERsaPrivateKey(k *rsa.PrivateKey) (cbor.Map, error) {
+	if len(k.Primes) < 2 {
+		return nil, &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("RSA with %d primes", len(k.Primes))}
+	}
+	m := renderCOSERsaPublicKey(&k.PublicKey)
+	m[coseLabelRSAD] = k.D.Bytes()
+	m[coseLabelRSAP] = k.Primes[0].Bytes()
+	m[coseLabelRSAQ] = k.Primes[1].Bytes()
+	if k.Precomputed.Dp != nil {
+		m[coseLabelRSADp] = k.Precomputed.Dp.Bytes()
+		m[coseLabelRSADq] = k.Precomputed.Dq.Bytes()
+		m[coseLabelRSAQinv] = k.Precomputed.Qinv.Bytes()
+	}
+	if len(k.Primes) > 2 {
+		ds, ts := rsaOtherPrimeValues(k)
+		var others []any
+		for i, r := range k.Primes[2:] {
+			if ts[i] == nil {
+				return nil, &InvalidParameterError{Member: fmt.Sprintf("oth[%d].r", i), Err: fmt.Errorf("not coprime with the other primes")}
+			}
+			others = append(others, cbor.Map{
+				coseLabelRSARi: r.Bytes(),
+				coseLabelRSADi: ds[i].Bytes(),
+				coseLabelRSATi: ts[i].Bytes(),
+			})
+		}
+		m[coseLabelRSAOther] = others
+	}
+	return m, nil
+}
+
+func renderCOSEEcdsaPublicKey(k *ecdsa.PublicKey) (cbor.Map, error) {
+	crv, ok := coseCurves[k.Curve.Params().Name]
+	if !ok {
+		return nil, &UnsupportedCurveError{Curve: k.Curve.Params().Name, Format: "COSE_Key"}
+	}
+	// Unlike JWK, COSE is explicit that leading zeros must be preserved (RFC 9053 §7.1.1)
+	byteLen := (k.Curve.Params().BitSize + 7) / 8
+	return cbor.Map{
+		coseLabelKty:    coseKtyEC2,
+		coseLabelEC2Crv: crv,
+		coseLabelEC2X:   k.X.FillBytes(make([]byte, byteLen)),
+		coseLabelEC2Y:   k.Y.FillBytes(make([]byte, byteLen)),
+	}, nil
+}
+
+func renderCOSEEcdsaPrivateKey(k *ecdsa.PrivateKey) (cbor.Map, error) {
+	m, err := renderCOSEEcdsaPublicKey(&k.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	byteLen := (k.Curve.Params().BitSize + 7) / 8
+	m[coseLabelEC2D] = k.D.FillBytes(make([]byte, byteLen))
+	return m, nil
+}
+
+// renderCOSEOkpKey renders the public key x, and the private key d if it's not nil
+func renderCOSEOkpKey(crv string, x, d []byte) cbor.Map {
+	m := cbor.Map{
+		coseLabelKty:    coseKtyOKP,
+		coseLabelOKPCrv: coseCurves[crv],
+		coseLabelOKPX:   []byte(x),
+	}
+	if d != nil {
+		m[coseLabelOKPD] = []byte(d)
+	}
+	return m
+}
+
+// ===
+// CBOR -> crypto.Key
+// ===
+
+func (k *COSEKey) UnmarshalCBOR(data []byte) error {
+	v, err := cbor.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	m, ok := v.(cbor.Map)
+	if !ok {
+		return fmt.Errorf("COSE_Key must be a CBOR map, not %T", v)
+	}
+	return k.fromMap(m)
+}
+
+func (k *COSEKey) fromMap(m cbor.Map) error {
+	kid, err := coseOptionalBytes(m, coseLabelKid)
+	if err != nil {
+		return err
+	}
+	k.KeyID = kid
+
+	k.Algorithm = 0
+	if alg, ok := m[coseLabelAlg]; ok {
+		// alg may also be a tstr, but none of the ones we know are
+		algInt, ok := alg.(int64)
+		if !ok {
+			return &InvalidParameterError{Member: "alg", Err: fmt.Errorf("must be an integer, not %T", alg)}
+		}
+		k.Algorithm = algInt
+	}
+
+	kty, ok := m[coseLabelKty].(int64)
+	if !ok {
+		return &InvalidParameterError{Member: "kty", Err: fmt.Errorf("must be present and an integer")}
+	}
+	switch kty {
+	case coseKtyRSA:
+		k.Key, err = parseCOSERsaKey(m)
+	case coseKtyEC2:
+		k.Key, err = parseCOSEEcdsaKey(m)
+	case coseKtyOKP:
+		k.Key, err = parseCOSEOkpKey(m)
+	default:
+		return &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("COSE kty %d", kty)}
+	}
+	return err
+}
+
+func (s *COSEKeySet) UnmarshalCBOR(data []byte) error {
+	v, err := cbor.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	arr, ok := v.([]any)
+	if !ok {
+		return fmt.Errorf("COSE_KeySet must be a CBOR array, not %T", v)
+	}
+
+	s.Keys = nil
+	for i, e := range arr {
+		m, ok := e.(cbor.Map)
+		if !ok {
+			return &KeyError{Index: i, Err: fmt.Errorf("COSE_Key must be a CBOR map, not %T", e)}
+		}
+		k := &COSEKey{}
+		if err := k.fromMap(m); err != nil {
+			return &KeyError{Index: i, KeyID: string(k.KeyID), Err: err}
+		}
+		s.Keys = append(s.Keys, k)
+	}
+	return nil
+}
+
+func parseCOSERsaKey(m cbor.Map) (any, error) {
+	n, err := coseRequiredBigInt(m, coseLabelRSAN, "n")
+	if err != nil {
+		return nil, err
+	}
+	e, err := coseRequiredBigInt(m, coseLabelRSAE, "e")
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() > int64(^uint32(0)>>1) {
+		return nil, &InvalidParameterError{Member: "e", Err: fmt.Errorf("too large")}
+	}
+	pubKey := rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	if _, ok := m[coseLabelRSAD]; !ok {
+		return &pubKey, nil
+	}
+
+	d, err := coseRequiredBigInt(m, coseLabelRSAD, "d")
+	if err != nil {
+		return nil, err
+	}
+	p, err := coseRequiredBigInt(m, coseLabelRSAP, "p")
+	if err != nil {
+		return nil, err
+	}
+	q, err := coseRequiredBigInt(m, coseLabelRSAQ, "q")
+	if err != nil {
+		return nil, err
+	}
+	primes := []*big.Int{p, q}
+	crt := map[string]*big.Int{}
+	if others, ok := m[coseLabelRSAOther]; ok {
+		others, ok := others.([]any)
+		if !ok {
+			return nil, &InvalidParameterError{Member: "oth", Err: fmt.Errorf("must be an array")}
+		}
+		for i, other := range others {
+			other, ok := other.(cbor.Map)
+			if !ok {
+				return nil, &InvalidParameterError{Member: fmt.Sprintf("oth[%d]", i), Err: fmt.Errorf("must be a map")}
+			}
+			r, err := coseRequiredBigInt(other, coseLabelRSARi, fmt.Sprintf("oth[%d].r", i))
+			if err != nil {
+				return nil, err
+			}
+			primes = append(primes, r)
+			for member, label := range map[string]int64{fmt.Sprintf("oth[%d].d", i): coseLabelRSADi, fmt.Sprintf("oth[%d].t", i): coseLabelRSATi} {
+				if crt[member], err = coseRequiredBigInt(other, label, member); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	privKey := &rsa.PrivateKey{
+		PublicKey: pubKey,
+		D:         d,
+		Primes:    primes,
+	}
+	// As with JWK, compute our own dP, dQ, qInv, but check any supplied ones match
+	privKey.Precompute()
+	for member, label := range map[string]int64{"dp": coseLabelRSADp, "dq": coseLabelRSADq, "qi": coseLabelRSAQinv} {
+		if _, ok := m[label]; ok {
+			if crt[member], err = coseRequiredBigInt(m, label, member); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := checkRsaPrivateKey(privKey, crt); err != nil {
+		return nil, err
+	}
+
+	return privKey, nil
+}
+
+func parseCOSEEcdsaKey(m cbor.Map) (any, error) {
+	crv, ok := m[coseLabelEC2Crv].(int64)
+	if !ok {
+		return nil, &InvalidParameterError{Member: "crv", Err: fmt.Errorf("must be present and an integer")}
+	}
+
+	pubKey := ecdsa.PublicKey{}
+	switch crv {
+	case coseCurves["P-256"]:
+		pubKey.Curve = elliptic.P256()
+	case coseCurves["P-384"]:
+		pubKey.Curve = elliptic.P384()
+	case coseCurves["P-521"]:
+		pubKey.Curve = elliptic.P521()
+	case coseCurves["secp256k1"]:
+		pubKey.Curve = secp256k1.S256()
+	default:
+		return nil, &UnsupportedCurveError{Curve: fmt.Sprintf("COSE crv %d", crv)}
+	}
+
+	var err error
+	pubKey.X, err = coseRequiredBigInt(m, coseLabelEC2X, "x")
+	if err != nil {
+		return nil, err
+	}
+	// y can also be a bool, for point compression. We don't support that
+	pubKey.Y, err = coseRequiredBigInt(m, coseLabelEC2Y, "y")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := m[coseLabelEC2D]; !ok {
+		return &pubKey, nil
+	}
+
+	d, err := coseRequiredBigInt(m, coseLabelEC2D, "d")
+	if err != nil {
+		return nil, err
+	}
+	privKey := &ecdsa.PrivateKey{PublicKey: pubKey, D: d}
+	if err := checkEcdsaPrivateKey(privKey); err != nil {
+		return nil, err
+	}
+	return privKey, nil
+}
+
+func parseCOSEOkpKey(m cbor.Map) (any, error) {
+	crv, ok := m[coseLabelOKPCrv].(int64)
+	if !ok {
+		return nil, &InvalidParameterError{Member: "crv", Err: fmt.Errorf("must be present and an integer")}
+	}
+	var size int
+	switch crv {
+	case coseCurves["Ed25519"]:
+		size = ed25519.PublicKeySize
+	case coseCurves["Ed448"]:
+		size = Ed448KeySize
+	case coseCurves["X448"]:
+		size = X448KeySize
+	default:
+		return nil, &UnsupportedCurveError{Curve: fmt.Sprintf("COSE crv %d", crv)}
+	}
+
+	x, err := coseOptionalBytes(m, coseLabelOKPX)
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != size {
+		return nil, &InvalidParameterError{Member: "x", Err: fmt.Errorf("must be %d bytes, not %d", size, len(x))}
+	}
+	d, err := coseOptionalBytes(m, coseLabelOKPD)
+	if err != nil {
+		return nil, err
+	}
+
+	if d == nil {
+		switch crv {
+		case coseCurves["Ed25519"]:
+			return ed25519.PublicKey(x), nil
+		case coseCurves["Ed448"]:
+			return Ed448PublicKey(x), nil
+		}
+		return X448PublicKey(x), nil
+	}
+
+	// Deriving the public key checks d's length and x's consistency in one go
+	var priv any
+	var derived []byte
+	switch crv {
+	case coseCurves["Ed25519"]:
+		if len(d) != ed25519.SeedSize {
+			return nil, &InvalidParameterError{Member: "d", Err: fmt.Errorf("must be %d bytes, not %d", ed25519.SeedSize, len(d))}
+		}
+		k := ed25519.NewKeyFromSeed(d)
+		priv, derived = k, k[ed25519.SeedSize:]
+	case coseCurves["Ed448"]:
+		k, err := NewEd448PrivateKey(d)
+		if err != nil {
+			return nil, &InvalidParameterError{Member: "d", Err: err}
+		}
+		priv, derived = k, k[Ed448KeySize:]
+	default:
+		k, err := NewX448PrivateKey(d)
+		if err != nil {
+			return nil, &InvalidParameterError{Member: "d", Err: err}
+		}
+		priv, derived = k, k[X448KeySize:]
+	}
+	if !bytes.Equal(derived, x) {
+		return nil, &InvalidParameterError{Member: "d", Err: fmt.Errorf("private key inconsistent: public key x isn't derived from d")}
+	}
+	return priv, nil
+}
+
+func coseOptionalBytes(m cbor.Map, label int64) ([]byte, error) {
+	v, ok := m[label]
+	if !ok {
+		return nil, nil
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, &InvalidParameterError{Member: fmt.Sprintf("label %d", label), Err: fmt.Errorf("must be a byte string, not %T", v)}
+	}
+	return b, nil
+}
+
+func coseRequiredBigInt(m cbor.Map, label int64, name string) (*big.Int, error) {
+	b, err := coseOptionalBytes(m, label)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, missingParameter(name)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// ===
+// JWK <-> COSE_Key
+// ===
+
+// JWK2COSEKey converts a JWK to a COSE_Key. The kid's string is used as its bytes.
+// The JWK's alg is carried over if it has a COSE equivalent, otherwise it's dropped.
+// Absent an alg, an EC key's is inferred from its curve, an Ed25519 or Ed448 key's is EdDSA, and RSA and X448 keys' are left absent.
+// Like JWK.MarshalJSON, private keys are refused with a PrivateKeyError; see MarshalOptions.JWK2COSEKey.
+func JWK2COSEKey(j *JWK) (*COSEKey, error) {
+	return MarshalOptions{}.JWK2COSEKey(j)
+}
+
+// JWK2COSEKey is JWK2COSEKey, with o's PublicOnly and AllowPrivate applied. Its other options are JSON-specific, so are ignored.
+func (o MarshalOptions) JWK2COSEKey(j *JWK) (*COSEKey, error) {
+	j, err := o.publishableKey(j)
+	if err != nil {
+		return nil, err
+	}
+	c := &COSEKey{Key: j.Key}
+	if j.KeyID != "" {
+		c.KeyID = []byte(j.KeyID)
+	}
+	if alg, ok := coseAlgorithms[j.Algorithm]; ok {
+		c.Algorithm = alg
+	}
+	pub, err := KeyPublicPartErr(j.Key)
+	if err != nil {
+		return nil, err
+	}
+	switch typedKey := pub.(type) {
+	case *ecdsa.PublicKey:
+		if alg, ok := ecdsaCurveAlgorithm(typedKey.Curve); ok && c.Algorithm == 0 {
+			c.Algorithm = coseAlgorithms[alg]
+		}
+	case ed25519.PublicKey, Ed448PublicKey:
+		if c.Algorithm == 0 {
+			c.Algorithm = coseAlgorithms["EdDSA"]
+		}
+	case *rsa.PublicKey, X448PublicKey:
+	default:
+		return nil, &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("%T", j.Key), Format: "COSE_Key"}
+	}
+	return c, nil
+}
+
+// COSEKey2JWK converts a COSE_Key to a JWK. The kid's bytes are used as a string.
+func COSEKey2JWK(c *COSEKey) (*JWK, error) {
+	switch c.Key.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey, *ecdsa.PublicKey, *ecdsa.PrivateKey:
+	case ed25519.PublicKey, ed25519.PrivateKey, Ed448PublicKey, Ed448PrivateKey, X448PublicKey, X448PrivateKey:
+	default:
+		return nil, &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("%T", c.Key), Format: "JWK"}
+	}
+	j := &JWK{KeyID: string(c.KeyID), Key: c.Key}
+	if c.Algorithm != 0 {
+		alg, ok := JOSEAlgorithm(c.Algorithm)
+		if !ok {
+			return nil, &InvalidParameterError{Member: "alg", Err: fmt.Errorf("COSE algorithm %d has no JOSE equivalent", c.Algorithm)}
+		}
+		j.Algorithm = alg
+	}
+	return j, nil
+}
+
+// JWKS2COSEKeySet converts each of the set's keys with JWK2COSEKey, so refuses private ones
+func JWKS2COSEKeySet(js *JWKS) (*COSEKeySet, error) {
+	return MarshalOptions{}.JWKS2COSEKeySet(js)
+}
+
+// JWKS2COSEKeySet is JWKS2COSEKeySet, with o's PublicOnly and AllowPrivate applied
+func (o MarshalOptions) JWKS2COSEKeySet(js *JWKS) (*COSEKeySet, error) {
+	cs := &COSEKeySet{}
+	for i, j := range js.Keys {
+		c, err := o.JWK2COSEKey(j)
+		if err != nil {
+			return nil, &KeyError{Index: i, KeyID: j.KeyID, Err: err}
+		}
+		cs.Keys = append(cs.Keys, c)
+	}
+	return cs, nil
+}
+
+func COSEKeySet2JWKS(cs *COSEKeySet) (*JWKS, error) {
+	js := &JWKS{}
+	for i, c := range cs.Keys {
+		j, err := COSEKey2JWK(c)
+		if err != nil {
+			return nil, &KeyError{Index: i, KeyID: string(c.KeyID), Err: err}
+		}
+		js.Keys = append(js.Keys, j)
+	}
+	return js, nil
+}
+
+// ===
+// PEM <-> CBOR
+// ===
+
+// PEM2COSEKey and PEM2COSEKeySet refuse private keys, like the JSON convenience functions.
+// To render them, take the two steps: get the JWK[S] Marshaler, and convert it with MarshalOptions{AllowPrivate: true}.
+func PEM2COSEKey(p []byte) ([]byte, error) {
+	j, err := PEM2JWKMarshaler(p)
+	if err != nil {
+		return nil, err
+	}
+	c, err := JWK2COSEKey(j)
+	if err != nil {
+		return nil, err
+	}
+	return c.MarshalCBOR()
+}
+
+func PEM2COSEKeySet(p []byte) ([]byte, error) {
+	js, err := PEM2JWKSMarshaler(p)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := JWKS2COSEKeySet(js)
+	if err != nil {
+		return nil, err
+	}
+	return cs.MarshalCBOR()
+}
+
+func COSEKeySet2PEM(c []byte) ([]byte, error) {
+	cs := &COSEKeySet{}
+	if err := cs.UnmarshalCBOR(c); err != nil {
+		return nil, err
+	}
+	keys := make([]any, 0, len(cs.Keys))
+	for _, k := range cs.Keys {
+		keys = append(keys, k.Key)
+	}
+	return Keys2PEM(keys)
+}
+
+func ecdsaCurveAlgorithm(c elliptic.Curve) (string, bool) {
+	switch c.Params().Name {
+	case "P-256":
+		return "ES256", true
+	case "P-384":
+		return "ES384", true
+	case "P-521":
+		return "ES512", true
+	case "secp256k1":
+		return "ES256K", true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,93 @@
+package jwks
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCOSEKeyEcdsaPublic(t *testing.T) {
+	// ECDSA Public P-256, from the jwks tests
+	pem := publics[2].pem
+
+	rendered, err := PEM2COSEKey(pem)
+	require.NoError(t, err)
+
+	// {1: 2, 3: -7, -1: 1, -2: h'...', -3: h'...'}, in deterministic key order
+	expected := "a5" + "0102" + "0326" + "2001" +
+		"215820" + "b1043d00860c6c369f58e8c266742085143f66863b8394f92442eb4370bdd85b" +
+		"225820" + "062fdd58e7c417c40c9f172b402538d6d294f5d2bc45b6ad4b035319f942a50e"
+	require.Equal(t, expected, hex.EncodeToString(rendered), "COSE_Key for crypto object doesn't match expected object")
+}
+
+func TestCOSEKeySetIdentity(t *testing.T) {
+	for _, cse := range append(allPublics, allPrivates...) {
+		js, err := PEM2JWKSMarshaler(cse.pem)
+		require.NoError(t, err)
+		cs, err := MarshalOptions{AllowPrivate: true}.JWKS2COSEKeySet(js)
+		require.NoError(t, err)
+		rendered, err := cs.MarshalCBOR()
+		require.NoError(t, err)
+
+		back, err := COSEKeySet2PEM(rendered)
+		require.NoError(t, err)
+
+		require.Equal(t, cse.pem, back, "PEM->COSE_KeySet->PEM is not identity")
+	}
+}
+
+func TestCOSEKeyJWKConversion(t *testing.T) {
+	js, err := PEM2JWKSMarshaler(privates[2].pem)
+	require.NoError(t, err)
+	js.Keys[0].KeyID = "rsa"
+	js.Keys[1].KeyID = "ec"
+
+	cs, err := MarshalOptions{AllowPrivate: true}.JWKS2COSEKeySet(js)
+	require.NoError(t, err)
+	require.Equal(t, []byte("rsa"), cs.Keys[0].KeyID)
+	require.Equal(t, int64(0), cs.Keys[0].Algorithm)
+	require.Equal(t, []byte("ec"), cs.Keys[1].KeyID)
+	require.Equal(t, int64(-7), cs.Keys[1].Algorithm)
+
+	bs, err := cs.MarshalCBOR()
+	require.NoError(t, err)
+	back := &COSEKeySet{}
+	require.NoError(t, back.UnmarshalCBOR(bs))
+
+	jsBack, err := COSEKeySet2JWKS(back)
+	require.NoError(t, err)
+	require.Equal(t, "rsa", jsBack.Keys[0].KeyID)
+	require.Equal(t, "ec", jsBack.Keys[1].KeyID)
+	require.True(t, js.Keys[0].Key.(actualPrivate).Equal(jsBack.Keys[0].Key))
+	require.True(t, js.Keys[1].Key.(actualPrivate).Equal(jsBack.Keys[1].Key))
+}
+
+func TestCOSEKeyErrors(t *testing.T) {
+	k := &COSEKey{}
+	require.ErrorContains(t, k.UnmarshalCBOR([]byte{0x80}), "must be a CBOR map")
+	require.ErrorContains(t, k.UnmarshalCBOR([]byte{0xa1, 0x01, 0x18, 0x2a}), "unknown key type COSE kty 42")
+	require.ErrorContains(t, k.UnmarshalCBOR([]byte{0xa1, 0x01, 0x02}), "invalid parameter crv: must be present")
+	require.ErrorContains(t, k.UnmarshalCBOR([]byte{0xa2, 0x01, 0x02, 0x01, 0x02}), "duplicate CBOR map key")
+}
+
+func TestCOSEKeyRefusesPrivate(t *testing.T) {
+	_, err := PEM2COSEKey(privates[0].pem)
+	require.ErrorIs(t, err, ErrPrivateKey)
+	_, err = PEM2COSEKeySet(privates[2].pem)
+	require.ErrorIs(t, err, ErrPrivateKey)
+
+	js, err := PEM2JWKSMarshaler(privates[2].pem)
+	require.NoError(t, err)
+	_, err = JWKS2COSEKeySet(js)
+	require.ErrorIs(t, err, ErrPrivateKey)
+	_, err = JWK2COSEKey(js.Keys[0])
+	require.ErrorIs(t, err, ErrPrivateKey)
+
+	// Or just their public parts
+	cs, err := MarshalOptions{PublicOnly: true}.JWKS2COSEKeySet(js)
+	require.NoError(t, err)
+	priv, err := KeyIsPrivateErr(cs.Keys[1].Key)
+	require.NoError(t, err)
+	require.False(t, priv)
+}
@@ -0,0 +1,220 @@
+package jwks
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/mt-inside/go-jwks/internal/curve448"
+)
+
+// Ed448 and X448 (RFC 8032, RFC 7748) support.
+// The stdlib has no types for these, so we define our own, modelled on crypto/ed25519's: byte slices holding the private part followed by the public.
+// They're just containers, for moving keys between formats; there's no signing or key agreement here.
+
+const (
+	Ed448KeySize = curve448.Ed448KeySize
+	X448KeySize  = curve448.X448KeySize
+)
+
+// Ed448PublicKey is the 57-byte encoding of an Ed448 public key
+type Ed448PublicKey []byte
+
+// Ed448PrivateKey is a 57-byte seed followed by its 57-byte public key
+type Ed448PrivateKey []byte
+
+// X448PublicKey is the 56-byte u-coordinate of an X448 public key
+type X448PublicKey []byte
+
+// X448PrivateKey is a 56-byte scalar followed by its 56-byte public key
+type X448PrivateKey []byte
+
+// NewEd448PrivateKey makes a private key from its seed, deriving the public part
+func NewEd448PrivateKey(seed []byte) (Ed448PrivateKey, error) {
+	pub, err := curve448.Ed448PublicKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(make(Ed448PrivateKey, 0, 2*Ed448KeySize), seed...), pub...), nil
+}
+
+// NewX448PrivateKey makes a private key from its scalar, deriving the public part
+func NewX448PrivateKey(scalar []byte) (X448PrivateKey, error) {
+	pub, err := curve448.X448PublicKey(scalar)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(make(X448PrivateKey, 0, 2*X448KeySize), scalar...), pub...), nil
+}
+
+func (k Ed448PublicKey) Equal(x crypto.PublicKey) bool {
+	xx, ok := x.(Ed448PublicKey)
+	return ok && bytes.Equal(k, xx)
+}
+
+func (k Ed448PrivateKey) Public() crypto.PublicKey {
+	return Ed448PublicKey(bytes.Clone(k[Ed448KeySize:]))
+}
+func (k Ed448PrivateKey) Seed() []byte {
+	return bytes.Clone(k[:Ed448KeySize])
+}
+func (k Ed448PrivateKey) Equal(x crypto.PrivateKey) bool {
+	xx, ok := x.(Ed448PrivateKey)
+	return ok && bytes.Equal(k, xx)
+}
+
+func (k X448PublicKey) Equal(x crypto.PublicKey) bool {
+	xx, ok := x.(X448PublicKey)
+	return ok && bytes.Equal(k, xx)
+}
+
+func (k X448PrivateKey) Public() crypto.PublicKey {
+	return X448PublicKey(bytes.Clone(k[X448KeySize:]))
+}
+func (k X448PrivateKey) Scalar() []byte {
+	return bytes.Clone(k[:X448KeySize])
+}
+func (k X448PrivateKey) Equal(x crypto.PrivateKey) bool {
+	xx, ok := x.(X448PrivateKey)
+	return ok && bytes.Equal(k, xx)
+}
+
+// ===
+// ASN.1 (RFC 8410)
+// ===
+
+var (
+	oidPublicKeyX448  = asn1.ObjectIdentifier{1, 3, 101, 111}
+	oidPublicKeyEd448 = asn1.ObjectIdentifier{1, 3, 101, 113}
+)
+
+func parseCurve448PKIXPublicKey(der []byte) (any, error) {
+	var spki pkixPublicKey
+	if rest, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after SubjectPublicKeyInfo")
+	}
+	if len(spki.Algorithm.Parameters.FullBytes) != 0 {
+		return nil, &InvalidParameterError{Member: "algorithm", Err: fmt.Errorf("parameters must be absent")}
+	}
+	pub := spki.PublicKey.RightAlign()
+	switch {
+	case spki.Algorithm.Algorithm.Equal(oidPublicKeyEd448):
+		if len(pub) != Ed448KeySize {
+			return nil, &InvalidParameterError{Member: "subjectPublicKey", Err: fmt.Errorf("Ed448 public key must be %d bytes, not %d", Ed448KeySize, len(pub))}
+		}
+		return Ed448PublicKey(pub), nil
+	case spki.Algorithm.Algorithm.Equal(oidPublicKeyX448):
+		if len(pub) != X448KeySize {
+			return nil, &InvalidParameterError{Member: "subjectPublicKey", Err: fmt.Errorf("X448 public key must be %d bytes, not %d", X448KeySize, len(pub))}
+		}
+		return X448PublicKey(pub), nil
+	default:
+		return nil, fmt.Errorf("not an Ed448 or X448 public key")
+	}
+}
+
+func parseCurve448PKCS8PrivateKey(der []byte) (any, error) {
+	var p8 pkcs8PrivateKey
+	if rest, err := asn1.Unmarshal(der, &p8); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after PrivateKeyInfo")
+	}
+	if len(p8.Algorithm.Parameters.FullBytes) != 0 {
+		return nil, &InvalidParameterError{Member: "privateKeyAlgorithm", Err: fmt.Errorf("parameters must be absent")}
+	}
+	// CurvePrivateKey ::= OCTET STRING, wrapped in PrivateKeyInfo's OCTET STRING
+	var raw []byte
+	if rest, err := asn1.Unmarshal(p8.PrivateKey, &raw); err != nil {
+		return nil, &InvalidParameterError{Member: "privateKey", Err: err}
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after CurvePrivateKey")
+	}
+
+	var priv any
+	var pub []byte
+	switch {
+	case p8.Algorithm.Algorithm.Equal(oidPublicKeyEd448):
+		k, err := NewEd448PrivateKey(raw)
+		if err != nil {
+			return nil, &InvalidParameterError{Member: "privateKey", Err: err}
+		}
+		priv, pub = k, k[Ed448KeySize:]
+	case p8.Algorithm.Algorithm.Equal(oidPublicKeyX448):
+		k, err := NewX448PrivateKey(raw)
+		if err != nil {
+			return nil, &InvalidParameterError{Member: "privateKey", Err: err}
+		}
+		priv, pub = k, k[X448KeySize:]
+	default:
+		return nil, fmt.Errorf("not an Ed448 or X448 private key")
+	}
+
+	// v2 (RFC 5958 OneAsymmetricKey) may carry the public key too; it must match
+	if len(p8.PublicKey.Bytes) != 0 && !bytes.Equal(p8.PublicKey.RightAlign(), pub) {
+		return nil, &InvalidParameterError{Member: "publicKey", Err: fmt.Errorf("doesn't match private key")}
+	}
+	return priv, nil
+}
+
+func curve448AlgorithmIdentifier(key any) (pkix.AlgorithmIdentifier, bool) {
+	switch key.(type) {
+	case Ed448PublicKey, Ed448PrivateKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyEd448}, true
+	case X448PublicKey, X448PrivateKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyX448}, true
+	}
+	return pkix.AlgorithmIdentifier{}, false
+}
+
+func marshalCurve448PKIXPublicKey(key any, pub []byte) ([]byte, error) {
+	algo, _ := curve448AlgorithmIdentifier(key)
+	return asn1.Marshal(pkixPublicKey{
+		Algorithm: algo,
+		PublicKey: asn1.BitString{Bytes: pub, BitLength: 8 * len(pub)},
+	})
+}
+
+// marshalCurve448PKCS8PrivateKey renders the same v1 structure as openssl, ie without the public key
+func marshalCurve448PKCS8PrivateKey(key any, priv []byte) ([]byte, error) {
+	algo, _ := curve448AlgorithmIdentifier(key)
+	raw, err := asn1.Marshal(priv)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs8PrivateKey{
+		Algorithm:  algo,
+		PrivateKey: raw,
+	})
+}
+
+// parseCurve448DER tries the encodings openssl uses for Ed448 and X448 keys
+func parseCurve448DER(der []byte) (any, bool) {
+	if k, err := parseCurve448PKIXPublicKey(der); err == nil {
+		return k, true
+	} else if k, err := parseCurve448PKCS8PrivateKey(der); err == nil {
+		return k, true
+	}
+	return nil, false
+}
+
+// renderCurve448DER is renderDER for Ed448 and X448 keys. ok is false for any other type of key.
+func renderCurve448DER(key any) (der []byte, ok bool, err error) {
+	switch k := key.(type) {
+	case Ed448PublicKey:
+		der, err = marshalCurve448PKIXPublicKey(k, k)
+	case X448PublicKey:
+		der, err = marshalCurve448PKIXPublicKey(k, k)
+	case Ed448PrivateKey:
+		der, err = marshalCurve448PKCS8PrivateKey(k, k[:Ed448KeySize])
+	case X448PrivateKey:
+		der, err = marshalCurve448PKCS8PrivateKey(k, k[:X448KeySize])
+	default:
+		return nil, false, nil
+	}
+	return der, true, err
+}
@@ -0,0 +1,598 @@
+package jwks
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DPoP (RFC 9449) proofs: verification for servers, and generation for clients.
+// A DPoP proof is a JWS, signed by the client, carrying its public key as a "jwk" header; access tokens are bound to that key by its RFC 7638 thumbprint ("jkt").
+
+// DPoPAlgorithms are the proof algorithms accepted by default: all the asymmetric ones we can get keys for.
+var DPoPAlgorithms = []string{"ES256", "ES384", "ES512", "ES256K", "EdDSA", "PS256", "PS384", "PS512", "RS256", "RS384", "RS512"}
+
+// ReplayCache remembers the jtis of proofs that have been used.
+// It must be safe for concurrent use, and entries can be forgotten once they expire, as proofs that old are refused anyway.
+type ReplayCache interface {
+	// Seen records jti, reporting whether it was already there.
+	Seen(jti string, expiry time.Time) bool
+}
+
+// MemoryReplayCache is a ReplayCache for a single process
+type MemoryReplayCache struct {
+	// Now is the clock used to expire entries; defaults to time.Now
+	Now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]time.Time
+	byExp   replayHeap // the same entries, soonest-expiring first, so expiring them doesn't mean walking the map
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{entries: map[string]time.Time{}}
+}
+
+func (c *MemoryReplayCache) Seen(jti string, expiry time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+	t := now()
+	for len(c.byExp) != 0 && t.After(c.byExp[0].expiry) {
+		delete(c.entries, heap.Pop(&c.byExp).(replayEntry).jti)
+	}
+
+	if _, ok := c.entries[jti]; ok {
+		return true
+	}
+	c.entries[jti] = expiry
+	heap.Push(&c.byExp, replayEntry{jti, expiry})
+	return false
+}
+
+type replayEntry struct {
+	jti    string
+	expiry time.Time
+}
+
+// replayHeap is a container/heap of entries, by expiry
+type replayHeap []replayEntry
+
+func (h replayHeap) Len() int           { return len(h) }
+func (h replayHeap) Less(i, j int) bool { return h[i].expiry.Before(h[j].expiry) }
+func (h replayHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *replayHeap) Push(x any)        { *h = append(*h, x.(replayEntry)) }
+func (h *replayHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// DPoPVerifier checks DPoP proofs (RFC 9449 §4.3). The zero value is usable, but doesn't detect replays.
+type DPoPVerifier struct {
+	// Algorithms the proof may be signed with. Defaults to DPoPAlgorithms.
+	Algorithms []string
+	// MaxAge is how old a proof's iat may be. Defaults to a minute.
+	MaxAge time.Duration
+	// Leeway allows for clock skew, in both directions. Defaults to 5 seconds.
+	Leeway time.Duration
+	// ReplayCache, if set, is used to refuse proofs whose jti has been seen before
+	ReplayCache ReplayCache
+	// Now is the clock; defaults to time.Now
+	Now func() time.Time
+}
+
+// DPoPRequest is what a proof is checked against
+type DPoPRequest struct {
+	Method string // HTTP method, eg "POST"
+	URL    string // Target URI. Query and fragment are ignored.
+
+	// AccessToken, if set, is the access token the request carries; the proof's ath must be its hash.
+	AccessToken string
+	// JKT, if set, is the thumbprint the access token is bound to (its cnf.jkt claim); the proof's key must match it.
+	JKT string
+	// Nonce, if set, is the DPoP-Nonce the server gave the client; the proof must carry it.
+	// If it doesn't, the error satisfies errors.Is(err, ErrDPoPNonce), and the server should reply with a fresh nonce.
+	Nonce string
+}
+
+// DPoPProof is a proof that's been verified
+type DPoPProof struct {
+	JWK        *JWK   // The client's public key
+	Thumbprint string // base64url SHA-256 thumbprint of JWK, for comparing against cnf.jkt
+	Algorithm  string
+	ID         string // jti
+	Method     string // htm
+	URL        string // htu
+	IssuedAt   time.Time
+	Nonce      string
+}
+
+// Verify checks the DPoP proof JWS against the request (RFC 9449 §4.3). Failures wrap ErrInvalidDPoPProof.
+func (v *DPoPVerifier) Verify(proof string, req DPoPRequest) (*DPoPProof, error) {
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	algs := v.Algorithms
+	if len(algs) == 0 {
+		algs = DPoPAlgorithms
+	}
+	maxAge := v.MaxAge
+	if maxAge == 0 {
+		maxAge = time.Minute
+	}
+	leeway := v.Leeway
+	if leeway == 0 {
+		leeway = 5 * time.Second
+	}
+
+	out := &DPoPProof{}
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(algs), jwt.WithoutClaimsValidation())
+	_, err := parser.ParseWithClaims(proof, claims, func(tok *jwt.Token) (any, error) {
+		if typ, _ := tok.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, fmt.Errorf("typ must be dpop+jwt, not %q", typ)
+		}
+		header, ok := tok.Header["jwk"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("jwk header must be present and a JSON object")
+		}
+		bs, err := json.Marshal(header)
+		if err != nil {
+			return nil, err
+		}
+		k, err := UnmarshalOptions{RejectPrivate: true}.UnmarshalKey(bs)
+		if err != nil {
+			return nil, fmt.Errorf("jwk header: %w", err)
+		}
+		out.JWK = k
+		out.Algorithm = tok.Method.Alg()
+		return k.Key, nil
+	})
+	if err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: err}
+	}
+
+	if out.ID, err = requiredStringClaim(claims, "jti"); err != nil {
+		return nil, err
+	}
+	if out.Method, err = requiredStringClaim(claims, "htm"); err != nil {
+		return nil, err
+	}
+	if out.URL, err = requiredStringClaim(claims, "htu"); err != nil {
+		return nil, err
+	}
+	iat, err := claims.GetIssuedAt()
+	if err != nil || iat == nil {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: fmt.Errorf("iat must be present and a number")}
+	}
+	out.IssuedAt = iat.Time
+	out.Nonce, _ = claims["nonce"].(string)
+
+	if out.Method != req.Method {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: fmt.Errorf("htm %q doesn't match request method %q", out.Method, req.Method)}
+	}
+	if match, err := dpopURLsMatch(out.URL, req.URL); err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: err}
+	} else if !match {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: fmt.Errorf("htu %q doesn't match request URL %q", out.URL, req.URL)}
+	}
+
+	t := now()
+	if out.IssuedAt.Before(t.Add(-maxAge - leeway)) {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: fmt.Errorf("issued too long ago, at %v", out.IssuedAt)}
+	}
+	if out.IssuedAt.After(t.Add(leeway)) {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: fmt.Errorf("issued in the future, at %v", out.IssuedAt)}
+	}
+
+	if req.Nonce != "" && out.Nonce != req.Nonce {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: ErrDPoPNonce}
+	}
+
+	if req.AccessToken != "" {
+		ath, _ := claims["ath"].(string)
+		if !constantTimeEqual(ath, dpopAccessTokenHash(req.AccessToken)) {
+			return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: fmt.Errorf("ath doesn't match the access token")}
+		}
+	}
+
+	tp, err := out.JWK.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: err}
+	}
+	out.Thumbprint = base64.RawURLEncoding.EncodeToString(tp)
+	if req.JKT != "" && !constantTimeEqual(out.Thumbprint, req.JKT) {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: fmt.Errorf("key thumbprint doesn't match the access token's cnf.jkt")}
+	}
+
+	// Last, so that proofs failing other checks don't use up their jti
+	if v.ReplayCache != nil && v.ReplayCache.Seen(out.ID, out.IssuedAt.Add(maxAge+2*leeway)) {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: fmt.Errorf("jti %q has been used before", out.ID)}
+	}
+
+	return out, nil
+}
+
+// VerifyRequest checks the DPoP header of an incoming request.
+// req's Method and URL are filled from r if they're empty; the URL is reconstructed from r.Host and whether r came over TLS, which won't be right behind a proxy that changes either.
+func (v *DPoPVerifier) VerifyRequest(r *http.Request, req DPoPRequest) (*DPoPProof, error) {
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return nil, &wrappedError{sentinel: ErrInvalidDPoPProof, Err: fmt.Errorf("request must have exactly one DPoP header, not %d", len(proofs))}
+	}
+	if req.Method == "" {
+		req.Method = r.Method
+	}
+	if req.URL == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		req.URL = (&url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path, RawPath: r.URL.RawPath}).String()
+	}
+	return v.Verify(proofs[0], req)
+}
+
+func requiredStringClaim(claims jwt.MapClaims, name string) (string, error) {
+	s, ok := claims[name].(string)
+	if !ok || s == "" {
+		return "", &wrappedError{sentinel: ErrInvalidDPoPProof, Err: fmt.Errorf("%s must be present and a non-empty string", name)}
+	}
+	return s, nil
+}
+
+// dpopAccessTokenHash is the ath claim for an access token: base64url(SHA-256(token))
+func dpopAccessTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// dpopURLsMatch compares htu to the request URL, ignoring query and fragment, after RFC 3986 §6.2.2 (case, percent-encoding, and dot segments) and §6.2.3 (default ports, and empty paths) normalisation
+func dpopURLsMatch(htu, target string) (bool, error) {
+	a, err := normaliseDPoPURL(htu)
+	if err != nil {
+		return false, fmt.Errorf("htu: %w", err)
+	}
+	b, err := normaliseDPoPURL(target)
+	if err != nil {
+		return false, fmt.Errorf("request URL: %w", err)
+	}
+	return a == b, nil
+}
+
+func normaliseDPoPURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return "", fmt.Errorf("%q is not an absolute URL", s)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if scheme == "https" && port == "443" || scheme == "http" && port == "80" {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6 literal
+	}
+	path := removeDotSegments(normalisePercentEncoding(u.EscapedPath()))
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path, nil
+}
+
+// normalisePercentEncoding decodes percent-encoded unreserved characters (RFC 3986 §6.2.2.2), and upper-cases the hex digits of the rest (§6.2.2.1)
+func normalisePercentEncoding(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			b.WriteByte(s[i])
+			continue
+		}
+		if isUnreserved(byte(c)) {
+			b.WriteByte(byte(c))
+		} else {
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+// isUnreserved: ALPHA / DIGIT / "-" / "." / "_" / "~" (RFC 3986 §2.3)
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// removeDotSegments is RFC 3986 §5.2.4, as required by §6.2.2.3.
+// It's not path.Clean, which would also collapse empty segments, and those are significant.
+func removeDotSegments(in string) string {
+	var out []string // each a segment, with its leading "/" if it had one
+	for in != "" {
+		switch {
+		case strings.HasPrefix(in, "../"):
+			in = in[3:]
+		case strings.HasPrefix(in, "./"):
+			in = in[2:]
+		case strings.HasPrefix(in, "/./"):
+			in = in[2:]
+		case in == "/.":
+			in = "/"
+		case strings.HasPrefix(in, "/../"):
+			in = in[3:]
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		case in == "/..":
+			in = "/"
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		case in == "." || in == "..":
+			in = ""
+		default:
+			end := strings.IndexByte(in[1:], '/') + 1 // the first segment, with its leading "/", if any
+			if end == 0 {
+				end = len(in)
+			}
+			out = append(out, in[:end])
+			in = in[end:]
+		}
+	}
+	return strings.Join(out, "")
+}
+
+// ===
+// Clients
+// ===
+
+// DPoPSigner makes DPoP proofs (RFC 9449 §4.2) with a client's private key
+type DPoPSigner struct {
+	// Now is the clock; defaults to time.Now
+	Now func() time.Time
+
+	key        any
+	method     jwt.SigningMethod
+	jwk        json.RawMessage
+	thumbprint string
+}
+
+// NewDPoPSigner makes a signer for key, which is a private key, eg from PEM2Keys, or a *JWK holding one.
+// The alg is the JWK's, if it has one; else ESxxx for EC keys' curves, and for RSA keys, their PSS restrictions, else RS256.
+func NewDPoPSigner(key any) (*DPoPSigner, error) {
+	js, err := newJWSSigner(key, "DPoP")
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(DPoPAlgorithms, js.method.Alg()) {
+		return nil, &InvalidParameterError{Member: "alg", Err: fmt.Errorf("%s can't be used for DPoP", js.method.Alg())}
+	}
+	bs, err := json.Marshal(js.jwk)
+	if err != nil {
+		return nil, err
+	}
+	tp, err := js.thumbprint()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DPoPSigner{
+		key:        js.key,
+		method:     js.method,
+		jwk:        bs,
+		thumbprint: tp,
+	}, nil
+}
+
+// Algorithm is the alg proofs are signed with
+func (s *DPoPSigner) Algorithm() string {
+	return s.method.Alg()
+}
+
+// Thumbprint is the base64url SHA-256 thumbprint of the signer's public key, ie the jkt access tokens will be bound to.
+func (s *DPoPSigner) Thumbprint() string {
+	return s.thumbprint
+}
+
+// Proof makes a proof for req. If req.AccessToken is set, the proof is bound to it with ath. req.JKT is ignored.
+func (s *DPoPSigner) Proof(req DPoPRequest) (string, error) {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return "", err
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return "", fmt.Errorf("%q is not an absolute URL", req.URL)
+	}
+	// htu is the target URI without query and fragment (RFC 9449 §4.2)
+	u.RawQuery, u.ForceQuery, u.Fragment, u.RawFragment = "", false, "", ""
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"jti": base64.RawURLEncoding.EncodeToString(jti),
+		"htm": req.Method,
+		"htu": u.String(),
+		"iat": now().Unix(),
+	}
+	if req.AccessToken != "" {
+		claims["ath"] = dpopAccessTokenHash(req.AccessToken)
+	}
+	if req.Nonce != "" {
+		claims["nonce"] = req.Nonce
+	}
+
+	tok := jwt.NewWithClaims(s.method, claims)
+	tok.Header["typ"] = "dpop+jwt"
+	tok.Header["jwk"] = s.jwk
+	return tok.SignedString(s.key)
+}
+
+// DPoPTransport is an http.RoundTripper that adds a DPoP proof to each request.
+// Requests carrying an "Authorization: DPoP <token>" header get proofs bound to that token.
+// Nonces the server hands out in DPoP-Nonce headers are remembered per origin and included in later proofs;
+// if a request is refused for want of one (RFC 9449 §8, §9), it's retried once with the new nonce, as long as its body can be replayed.
+type DPoPTransport struct {
+	Signer *DPoPSigner
+	// Base does the actual requests; defaults to http.DefaultTransport
+	Base http.RoundTripper
+
+	mu     sync.Mutex
+	nonces map[string]string
+}
+
+func (t *DPoPTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	origin := strings.ToLower(r.URL.Scheme) + "://" + strings.ToLower(r.URL.Host)
+
+	nonce := t.nonce(origin)
+	resp, err := t.roundTrip(base, r, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	newNonce := resp.Header.Get("DPoP-Nonce")
+	if newNonce == "" {
+		return resp, nil
+	}
+	t.setNonce(origin, newNonce)
+
+	if newNonce == nonce || !dpopNonceRequired(resp) {
+		return resp, nil
+	}
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return resp, nil // can't send it again
+	}
+	resp.Body.Close()
+
+	retry := r
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry = r.Clone(r.Context())
+		retry.Body = body
+	}
+	return t.roundTrip(base, retry, newNonce)
+}
+
+func (t *DPoPTransport) roundTrip(base http.RoundTripper, r *http.Request, nonce string) (*http.Response, error) {
+	req := DPoPRequest{Method: r.Method, URL: r.URL.String(), Nonce: nonce}
+	if req.Method == "" {
+		req.Method = http.MethodGet // as net/http takes it to mean
+	}
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "DPoP") {
+		req.AccessToken = token
+	}
+	proof, err := t.Signer.Proof(req)
+	if err != nil {
+		// RoundTrippers must close the body, even on error
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+
+	// RoundTrippers mustn't modify the request
+	out := r.Clone(r.Context())
+	out.Body = r.Body
+	out.Header.Set("DPoP", proof)
+	return base.RoundTrip(out)
+}
+
+func (t *DPoPTransport) nonce(origin string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.nonces[origin]
+}
+
+func (t *DPoPTransport) setNonce(origin, nonce string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.nonces == nil {
+		t.nonces = map[string]string{}
+	}
+	t.nonces[origin] = nonce
+}
+
+// dpopNonceRequired reports whether the response is a use_dpop_nonce error.
+// Resource servers say so with a 401 and WWW-Authenticate (RFC 9449 §9); authorization servers with a 400 and a JSON error body (§8).
+// Any of the body that's read to find out is put back, for the caller.
+func dpopNonceRequired(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		for _, v := range resp.Header.Values("WWW-Authenticate") {
+			if strings.Contains(v, "use_dpop_nonce") {
+				return true
+			}
+		}
+		return false
+	case http.StatusBadRequest:
+		bs, err := io.ReadAll(io.LimitReader(resp.Body, dpopMaxErrorBody))
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(bs), resp.Body), resp.Body}
+		if err != nil {
+			return false
+		}
+		errBody := struct {
+			Error string `json:"error"`
+		}{}
+		return json.Unmarshal(bs, &errBody) == nil && errBody.Error == "use_dpop_nonce"
+	default:
+		return false
+	}
+}
+
+// dpopMaxErrorBody is as much of a 400 response as we'll read looking for an OAuth error; they're small JSON objects (RFC 6749 §5.2)
+const dpopMaxErrorBody = 64 << 10
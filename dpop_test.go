@@ -0,0 +1,414 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mt-inside/go-jwks/internal/secp256k1"
+)
+
+// makeDPoPProof signs claims as a DPoP proof, embedding jwk (the public part of key, unless set)
+func makeDPoPProof(t *testing.T, key any, method jwt.SigningMethod, claims jwt.MapClaims, header map[string]any) string {
+	pub, err := KeyPublicPartErr(key)
+	require.NoError(t, err)
+	j, err := Key2JWK(pub)
+	require.NoError(t, err)
+
+	tok := jwt.NewWithClaims(method, claims)
+	tok.Header["typ"] = "dpop+jwt"
+	tok.Header["jwk"] = json.RawMessage(j)
+	for k, v := range header {
+		tok.Header[k] = v
+	}
+	signed, err := tok.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestDPoPVerify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"jti": "e1j3V_bKic8-LAEB",
+			"htm": "GET",
+			"htu": "https://resource.example.org/protectedresource",
+			"iat": now.Unix(),
+			"ath": dpopAccessTokenHash("Kz~8mXK1EalYznwH-LC-1fBAo.4Ljp~zsPE_NeO.gxU"),
+		}
+	}
+	req := DPoPRequest{
+		Method:      "GET",
+		URL:         "https://Resource.example.org:443/protectedresource?foo=bar",
+		AccessToken: "Kz~8mXK1EalYznwH-LC-1fBAo.4Ljp~zsPE_NeO.gxU",
+	}
+	clock := func() time.Time { return now }
+	cache := NewMemoryReplayCache()
+	cache.Now = clock
+	v := &DPoPVerifier{Now: clock, ReplayCache: cache}
+
+	proof := makeDPoPProof(t, key, jwt.SigningMethodES256, claims(), nil)
+	got, err := v.Verify(proof, req)
+	require.NoError(t, err)
+	require.Equal(t, "ES256", got.Algorithm)
+	require.True(t, key.PublicKey.Equal(got.JWK.Key))
+	tp, err := (&JWK{Key: &key.PublicKey}).Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(tp), got.Thumbprint)
+
+	// Replayed
+	_, err = v.Verify(proof, req)
+	require.ErrorIs(t, err, ErrInvalidDPoPProof)
+	require.ErrorContains(t, err, "used before")
+
+	// Bound to the right key, and a different one
+	req.JKT = got.Thumbprint
+	_, err = v.Verify(makeDPoPProof(t, key, jwt.SigningMethodES256, withClaim(claims(), "jti", "2"), nil), req)
+	require.NoError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, err = v.Verify(makeDPoPProof(t, other, jwt.SigningMethodES256, withClaim(claims(), "jti", "3"), nil), req)
+	require.ErrorContains(t, err, "cnf.jkt")
+	req.JKT = ""
+
+	cases := []struct {
+		name   string
+		proof  string
+		expect string
+	}{
+		{"method", makeDPoPProof(t, key, jwt.SigningMethodES256, withClaim(claims(), "htm", "POST"), nil), "htm"},
+		{"url", makeDPoPProof(t, key, jwt.SigningMethodES256, withClaim(claims(), "htu", "https://resource.example.org/other"), nil), "htu"},
+		{"old", makeDPoPProof(t, key, jwt.SigningMethodES256, withClaim(claims(), "iat", now.Add(-time.Hour).Unix()), nil), "too long ago"},
+		{"future", makeDPoPProof(t, key, jwt.SigningMethodES256, withClaim(claims(), "iat", now.Add(time.Hour).Unix()), nil), "future"},
+		{"ath", makeDPoPProof(t, key, jwt.SigningMethodES256, withClaim(claims(), "ath", "nope"), nil), "ath"},
+		{"jti", makeDPoPProof(t, key, jwt.SigningMethodES256, withClaim(claims(), "jti", nil), nil), "jti must be present"},
+		{"typ", makeDPoPProof(t, key, jwt.SigningMethodES256, claims(), map[string]any{"typ": "JWT"}), "typ must be dpop+jwt"},
+		{"no jwk", makeDPoPProof(t, key, jwt.SigningMethodES256, claims(), map[string]any{"jwk": "nope"}), "jwk header"},
+		{"private jwk", makeDPoPProof(t, key, jwt.SigningMethodES256, claims(), map[string]any{"jwk": privateJWK(t, key)}), "private key"},
+		{"wrong key", makeDPoPProof(t, key, jwt.SigningMethodES256, claims(), map[string]any{"jwk": json.RawMessage(mustKey2JWK(t, &other.PublicKey))}), "signature"},
+		{"symmetric", signedHS256(t, claims()), "signing method HS256 is invalid"},
+	}
+	for _, cse := range cases {
+		_, err := v.Verify(cse.proof, req)
+		require.ErrorIs(t, err, ErrInvalidDPoPProof, cse.name)
+		require.ErrorContains(t, err, cse.expect, cse.name)
+	}
+
+	// Nonces
+	req.Nonce = "eyJ7S_zG.eyJH0-Z.HX4w-7v"
+	_, err = v.Verify(makeDPoPProof(t, key, jwt.SigningMethodES256, withClaim(claims(), "jti", "4"), nil), req)
+	require.ErrorIs(t, err, ErrDPoPNonce)
+	_, err = v.Verify(makeDPoPProof(t, key, jwt.SigningMethodES256, withClaim(withClaim(claims(), "jti", "5"), "nonce", req.Nonce), nil), req)
+	require.NoError(t, err)
+	req.Nonce = ""
+
+	// Ed25519 keys come as OKP JWKs
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	got, err = v.Verify(makeDPoPProof(t, edKey, jwt.SigningMethodEdDSA, withClaim(claims(), "jti", "6"), nil), req)
+	require.NoError(t, err)
+	require.Equal(t, "EdDSA", got.Algorithm)
+	require.True(t, edKey.Public().(ed25519.PublicKey).Equal(got.JWK.Key))
+}
+
+func TestDPoPVerifyRequest(t *testing.T) {
+	// RSA keys in DPoP headers have no alg
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	proof := makeDPoPProof(t, key, jwt.SigningMethodPS256, jwt.MapClaims{
+		"jti": "-BwC3ESc6acc2lTc",
+		"htm": "POST",
+		"htu": "https://server.example.com/token",
+		"iat": time.Now().Unix(),
+	}, nil)
+
+	r := httptest.NewRequest("POST", "https://server.example.com/token", nil)
+	r.Header.Set("DPoP", proof)
+	_, err = (&DPoPVerifier{}).VerifyRequest(r, DPoPRequest{})
+	require.NoError(t, err)
+
+	r.Header.Add("DPoP", proof)
+	_, err = (&DPoPVerifier{}).VerifyRequest(r, DPoPRequest{})
+	require.ErrorContains(t, err, "exactly one DPoP header")
+}
+
+func TestDPoPURLsMatch(t *testing.T) {
+	cases := []struct {
+		htu, target string
+		match       bool
+	}{
+		{"https://server.example.com/token", "https://server.example.com/token?x=1#f", true},
+		{"HTTPS://Server.Example.COM/token", "https://server.example.com/token", true},
+		{"https://server.example.com:443/token", "https://server.example.com/token", true},
+		{"https://server.example.com", "https://server.example.com/", true},
+		{"https://server.example.com:8443/token", "https://server.example.com/token", false},
+		// §6.2.2.1: percent-encoding hex digits are case-insensitive
+		{"https://server.example.com/a%2fb", "https://server.example.com/a%2Fb", true},
+		// §6.2.2.2: percent-encoded unreserved characters are the same as the characters
+		{"https://server.example.com/%7Euser/t%6Fken", "https://server.example.com/~user/token", true},
+		{"https://server.example.com/a%2Fb", "https://server.example.com/a/b", false},
+		// §6.2.2.3: dot segments
+		{"https://server.example.com/a/./b/../token", "https://server.example.com/a/token", true},
+		{"https://server.example.com/../token", "https://server.example.com/token", true},
+		{"https://server.example.com/a/%2E%2E/token", "https://server.example.com/token", true},
+		{"https://server.example.com/a/b/..", "https://server.example.com/a/", true},
+		// Empty segments aren't dot segments
+		{"https://server.example.com//token", "https://server.example.com/token", false},
+		{"https://server.example.com/token/", "https://server.example.com/token", false},
+	}
+	for _, cse := range cases {
+		match, err := dpopURLsMatch(cse.htu, cse.target)
+		require.NoError(t, err)
+		require.Equal(t, cse.match, match, "%s vs %s", cse.htu, cse.target)
+	}
+
+	_, err := dpopURLsMatch("/token", "https://server.example.com/token")
+	require.Error(t, err)
+}
+
+func withClaim(c jwt.MapClaims, name string, value any) jwt.MapClaims {
+	if value == nil {
+		delete(c, name)
+	} else {
+		c[name] = value
+	}
+	return c
+}
+
+func mustKey2JWK(t *testing.T, k any) string {
+	j, err := Key2JWKMarshaler(k)
+	require.NoError(t, err)
+	bs, err := MarshalOptions{AllowPrivate: true}.Marshal(j)
+	require.NoError(t, err)
+	return string(bs)
+}
+
+func privateJWK(t *testing.T, k any) json.RawMessage {
+	return json.RawMessage(mustKey2JWK(t, k))
+}
+
+func signedHS256(t *testing.T, claims jwt.MapClaims) string {
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tok.Header["typ"] = "dpop+jwt"
+	signed, err := tok.SignedString([]byte("secret"))
+	require.NoError(t, err)
+	return signed
+}
+
+func TestMemoryReplayCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewMemoryReplayCache()
+	c.Now = func() time.Time { return now }
+
+	// Expiries needn't arrive in order
+	require.False(t, c.Seen("late", now.Add(3*time.Minute)))
+	require.False(t, c.Seen("early", now.Add(time.Minute)))
+	require.False(t, c.Seen("middle", now.Add(2*time.Minute)))
+	require.True(t, c.Seen("early", now.Add(time.Hour)))
+
+	now = now.Add(90 * time.Second)
+	require.True(t, c.Seen("middle", now.Add(time.Hour)))
+	require.Len(t, c.entries, 2, "expired entries should be dropped")
+	require.Len(t, c.byExp, 2)
+	require.False(t, c.Seen("early", now.Add(time.Minute)), "expired entries are forgotten")
+
+	now = now.Add(time.Hour)
+	require.False(t, c.Seen("late", now.Add(time.Minute)))
+	require.Len(t, c.entries, 1)
+	require.Len(t, c.byExp, 1)
+}
+
+func TestDPoPSigner(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	k256Key, err := ecdsa.GenerateKey(secp256k1.S256(), rand.Reader)
+	require.NoError(t, err)
+	pssKeys, err := PEM2Keys([]byte(rsaPSSPrivPEM))
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	cases := []struct {
+		key any
+		alg string
+	}{
+		{ecKey, "ES384"},
+		{edKey, "EdDSA"},
+		{rsaKey, "RS256"},
+		{&JWK{Key: rsaKey, Algorithm: "PS512"}, "PS512"},
+		{k256Key, "ES256K"},
+		{pssKeys[0], "PS384"},
+	}
+	for _, cse := range cases {
+		s, err := NewDPoPSigner(cse.key)
+		require.NoError(t, err, cse.alg)
+		require.Equal(t, cse.alg, s.Algorithm())
+
+		proof, err := s.Proof(DPoPRequest{Method: "POST", URL: "https://server.example.com/token?x=y#z", AccessToken: "token", Nonce: "n"})
+		require.NoError(t, err, cse.alg)
+		got, err := (&DPoPVerifier{}).Verify(proof, DPoPRequest{Method: "POST", URL: "https://server.example.com/token", AccessToken: "token", Nonce: "n", JKT: s.Thumbprint()})
+		require.NoError(t, err, cse.alg)
+		require.Equal(t, cse.alg, got.Algorithm)
+		require.Equal(t, "https://server.example.com/token", got.URL)
+	}
+
+	_, err = NewDPoPSigner(&ecKey.PublicKey)
+	require.ErrorContains(t, err, "need a private key")
+	_, err = NewDPoPSigner(&JWK{Key: ecKey, Algorithm: "HS256"})
+	require.ErrorIs(t, err, ErrInvalidParameter)
+	ed448Key, err := NewEd448PrivateKey(make([]byte, Ed448KeySize))
+	require.NoError(t, err)
+	_, err = NewDPoPSigner(ed448Key)
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+}
+
+func TestDPoPTransport(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	s, err := NewDPoPSigner(key)
+	require.NoError(t, err)
+
+	const nonce = "eyJ7S_zG.eyJH0-Z.HX4w-7v"
+	v := &DPoPVerifier{ReplayCache: NewMemoryReplayCache()}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "DPoP ")
+		_, err := v.VerifyRequest(r, DPoPRequest{AccessToken: token, JKT: s.Thumbprint(), Nonce: nonce})
+		if errors.Is(err, ErrDPoPNonce) {
+			w.Header().Set("DPoP-Nonce", nonce)
+			w.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &DPoPTransport{Signer: s}}
+
+	// First request is refused for want of a nonce, and retried, body and all
+	req, err := http.NewRequest("POST", srv.URL+"/resource?q=1", strings.NewReader("hello"))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "DPoP Kz~8mXK1EalYznwH-LC-1fBAo.4Ljp~zsPE_NeO.gxU")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, "hello", string(body))
+	require.Equal(t, 2, requests)
+	require.Empty(t, req.Header.Get("DPoP"), "request must not be modified")
+
+	// Later ones use the remembered nonce
+	resp, err = client.Get(srv.URL + "/resource")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 3, requests)
+
+	// A request with no Method is a GET
+	u, err := url.Parse(srv.URL + "/resource")
+	require.NoError(t, err)
+	resp, err = client.Do(&http.Request{URL: u, Header: http.Header{}})
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+
+	// The body's closed even if no proof can be made
+	tracked := &closeTracker{Reader: strings.NewReader("hello")}
+	_, err = (&DPoPTransport{Signer: s}).RoundTrip(&http.Request{Method: "POST", URL: &url.URL{Path: "/relative"}, Header: http.Header{}, Body: tracked})
+	require.Error(t, err)
+	require.True(t, tracked.closed)
+}
+
+func TestDPoPTransportAuthorizationServer(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	s, err := NewDPoPSigner(key)
+	require.NoError(t, err)
+
+	// Authorization servers ask for nonces with a 400 and an OAuth error body (RFC 9449 §8).
+	// Other 400s, and other responses that happen to have a new nonce, aren't retried.
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("DPoP-Nonce", strconv.Itoa(requests))
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/ok":
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/bad":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+		case requests%2 == 1:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"use_dpop_nonce","error_description":"Authorization server requires nonce in DPoP proof"}`))
+		default:
+			w.Write([]byte(`{"access_token":"Kz~8mXK1EalYznwH-LC-1fBAo.4Ljp~zsPE_NeO.gxU"}`))
+		}
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &DPoPTransport{Signer: s}}
+
+	resp, err := client.Post(srv.URL+"/token", "application/x-www-form-urlencoded", strings.NewReader("grant_type=authorization_code"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.Equal(t, 2, requests)
+
+	resp, err = client.Post(srv.URL+"/bad", "application/x-www-form-urlencoded", strings.NewReader("grant_type=authorization_code"))
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, `{"error":"invalid_grant"}`, string(body), "body should be intact after being looked at")
+	require.Equal(t, 3, requests)
+
+	resp, err = client.Get(srv.URL + "/ok")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 4, requests)
+}
+
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
@@ -0,0 +1,234 @@
+package jwks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by this package can be inspected with errors.Is, against these sentinels, and errors.As, against the structured types below, which carry the details.
+var (
+	ErrUnsupportedKeyType = errors.New("unsupported key type")
+	ErrUnsupportedCurve   = errors.New("unsupported curve")
+	ErrInvalidParameter   = errors.New("invalid key parameter")
+	ErrMalformedPEM       = errors.New("malformed PEM")
+	ErrPrivateKey         = errors.New("private key where public key expected")
+	ErrDuplicateKeyID     = errors.New("duplicate kid")
+	ErrInvalidDPoPProof   = errors.New("invalid DPoP proof")
+	ErrCertificateBinding = errors.New("token isn't bound to the client certificate")
+	ErrInvalidSignature   = errors.New("invalid HTTP message signature")
+	ErrInvalidSignedJWKS  = errors.New("invalid signed JWKS")
+	// ErrDPoPNonce is wrapped, along with ErrInvalidDPoPProof, by the error for a proof without the server's current nonce (RFC 9449 §8)
+	ErrDPoPNonce = errors.New("DPoP proof lacks the server's nonce")
+
+	ErrInvalidEntityStatement = errors.New("invalid entity statement")
+	ErrNoTrustChain           = errors.New("no trust chain to a trust anchor")
+	ErrInvalidSVID            = errors.New("invalid SVID")
+)
+
+// UnsupportedKeyTypeError is returned for key types we (or the target format) can't handle.
+// KeyType is either a kty value from a document, or a description of a Go key type.
+// errors.Is(err, ErrUnsupportedKeyType) holds.
+type UnsupportedKeyTypeError struct {
+	KeyType string
+	Format  string // The format that doesn't support the key, eg "JWK". Empty if it's this package that doesn't know the type.
+}
+
+func (e *UnsupportedKeyTypeError) Error() string {
+	if e.Format != "" {
+		return fmt.Sprintf("%s does not support %s", e.Format, e.KeyType)
+	}
+	return fmt.Sprintf("unknown key type %s", e.KeyType)
+}
+
+func (e *UnsupportedKeyTypeError) Is(target error) bool {
+	return target == ErrUnsupportedKeyType
+}
+
+// UnsupportedCurveError is returned for elliptic curves we (or the target format) can't handle.
+// errors.Is(err, ErrUnsupportedCurve) holds.
+type UnsupportedCurveError struct {
+	Curve  string
+	Format string
+}
+
+func (e *UnsupportedCurveError) Error() string {
+	if e.Format != "" {
+		return fmt.Sprintf("%s does not support curve %s", e.Format, e.Curve)
+	}
+	return fmt.Sprintf("unsupported curve %s", e.Curve)
+}
+
+func (e *UnsupportedCurveError) Is(target error) bool {
+	return target == ErrUnsupportedCurve
+}
+
+// InvalidParameterError is returned when a member of a key is missing, can't be decoded, or doesn't make sense.
+// errors.Is(err, ErrInvalidParameter) holds.
+type InvalidParameterError struct {
+	Member string // The JWK member name, eg "n", or the nearest equivalent for other formats
+	Err    error
+}
+
+func (e *InvalidParameterError) Error() string {
+	return fmt.Sprintf("invalid parameter %s: %v", e.Member, e.Err)
+}
+
+func (e *InvalidParameterError) Unwrap() error {
+	return e.Err
+}
+
+func (e *InvalidParameterError) Is(target error) bool {
+	return target == ErrInvalidParameter
+}
+
+// PEMError is returned for PEM input that can't be used: not PEM at all, the wrong number of blocks, or a block that doesn't contain a key we understand.
+// errors.Is(err, ErrMalformedPEM) holds.
+type PEMError struct {
+	Block int // Index of the offending block, or -1 if the problem is with the input as a whole
+	Err   error
+}
+
+func (e *PEMError) Error() string {
+	if e.Block < 0 {
+		return fmt.Sprintf("can't decode input as PEM: %v", e.Err)
+	}
+	return fmt.Sprintf("error in PEM block %d: %v", e.Block, e.Err)
+}
+
+func (e *PEMError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PEMError) Is(target error) bool {
+	return target == ErrMalformedPEM
+}
+
+// PrivateKeyError is returned when private key material turns up somewhere only public keys should be.
+// errors.Is(err, ErrPrivateKey) holds.
+type PrivateKeyError struct {
+	KeyIDs []string // kids of the offending keys. Keys without kids are represented by their index in their set, eg "#2"
+}
+
+func (e *PrivateKeyError) Error() string {
+	if len(e.KeyIDs) == 0 {
+		return ErrPrivateKey.Error()
+	}
+	return fmt.Sprintf("%v: %s", ErrPrivateKey, strings.Join(e.KeyIDs, ", "))
+}
+
+func (e *PrivateKeyError) Is(target error) bool {
+	return target == ErrPrivateKey
+}
+
+// DuplicateKeyIDError is returned when a key would share a kid with another key of the same kty and use in a set.
+// errors.Is(err, ErrDuplicateKeyID) holds.
+type DuplicateKeyIDError struct {
+	KeyID string
+}
+
+func (e *DuplicateKeyIDError) Error() string {
+	return fmt.Sprintf("%v %q", ErrDuplicateKeyID, e.KeyID)
+}
+
+func (e *DuplicateKeyIDError) Is(target error) bool {
+	return target == ErrDuplicateKeyID
+}
+
+// wrappedError is for failures whose only detail is what went wrong, Err, eg a DPoP proof failing one of RFC 9449 §4.3's checks.
+// errors.Is(err, sentinel) holds, for the sentinel saying what had failed, eg ErrInvalidDPoPProof.
+type wrappedError struct {
+	sentinel error
+	Err      error
+}
+
+func (e *wrappedError) Error() string {
+	return fmt.Sprintf("%v: %v", e.sentinel, e.Err)
+}
+
+func (e *wrappedError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+func (e *wrappedError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPSignatureError is returned by HTTPSignatureVerifier.Verify for an HTTP message signature (RFC 9421) that's missing, malformed, or doesn't verify.
+// Label says which of the request's signatures it's about, or is empty if the Signature fields couldn't be parsed at all; when several signatures were tried, Verify joins an HTTPSignatureError for each.
+// It matches ErrInvalidSignature with errors.Is.
+type HTTPSignatureError struct {
+	Label string
+	Err   error
+}
+
+func (e *HTTPSignatureError) Error() string {
+	if e.Label == "" {
+		return fmt.Sprintf("%v: %v", ErrInvalidSignature, e.Err)
+	}
+	return fmt.Sprintf("%v %s: %v", ErrInvalidSignature, e.Label, e.Err)
+}
+
+func (e *HTTPSignatureError) Is(target error) bool {
+	return target == ErrInvalidSignature
+}
+
+func (e *HTTPSignatureError) Unwrap() error {
+	return e.Err
+}
+
+// TrustChainError is returned when an entity can't be shown to be trusted through an OpenID Federation, by TrustChainResolver.Resolve or Verify.
+// EntityID is the entity the chain was for (the subject of a presented chain's first statement, if it could be read).
+// Err is why; after a search of several authority hints it joins the reason each path failed, each prefixed with the superior it went via.
+// It matches ErrNoTrustChain with errors.Is.
+type TrustChainError struct {
+	EntityID string
+	Err      error
+}
+
+func (e *TrustChainError) Error() string {
+	if e.EntityID == "" {
+		return fmt.Sprintf("%v: %v", ErrNoTrustChain, e.Err)
+	}
+	return fmt.Sprintf("%v for %s: %v", ErrNoTrustChain, e.EntityID, e.Err)
+}
+
+func (e *TrustChainError) Is(target error) bool {
+	return target == ErrNoTrustChain
+}
+
+func (e *TrustChainError) Unwrap() error {
+	return e.Err
+}
+
+// keyLabel identifies a key in error messages: its kid, or failing that its index in its set
+func keyLabel(k *JWK, index int) string {
+	if k.KeyID != "" {
+		return k.KeyID
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+// KeyError describes a problem with one key in a set
+type KeyError struct {
+	Index int    // Position of the key in the set's "keys" array
+	KeyID string // kid, if it could be determined
+	Err   error
+}
+
+func (e *KeyError) Error() string {
+	if e.KeyID != "" {
+		return fmt.Sprintf("error in key %d (kid %q): %v", e.Index, e.KeyID, e.Err)
+	}
+	return fmt.Sprintf("error in key %d: %v", e.Index, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+var errMissing = errors.New("required member is missing")
+
+func missingParameter(member string) error {
+	return &InvalidParameterError{Member: member, Err: errMissing}
+}
@@ -0,0 +1,96 @@
+package jwks
+
+import (
+	"crypto/dsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorTypes(t *testing.T) {
+	var err error
+
+	_, err = PEM2JWK([]byte(x25519PubPEM))
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+	ktErr := &UnsupportedKeyTypeError{}
+	require.ErrorAs(t, err, &ktErr)
+	require.Equal(t, "x25519", ktErr.KeyType)
+	require.Equal(t, "JWK", ktErr.Format)
+
+	_, err = JWK2Key([]byte(`{"kty":"oct","k":"AAAA"}`))
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+	require.ErrorAs(t, err, &ktErr)
+	require.Equal(t, "oct", ktErr.KeyType)
+
+	_, err = JWK2Key([]byte(`{"kty":"EC","crv":"P-192","x":"AAAA","y":"AAAA"}`))
+	require.ErrorIs(t, err, ErrUnsupportedCurve)
+	crvErr := &UnsupportedCurveError{}
+	require.ErrorAs(t, err, &crvErr)
+	require.Equal(t, "P-192", crvErr.Curve)
+
+	_, err = JWK2Key([]byte(`{"kty":"EC","crv":"P-256","x":"AAAA"}`))
+	require.ErrorIs(t, err, ErrInvalidParameter)
+	paramErr := &InvalidParameterError{}
+	require.ErrorAs(t, err, &paramErr)
+	require.Equal(t, "y", paramErr.Member)
+
+	_, err = JWK2Key([]byte(`{"kty":"RSA","alg":"RS256","n":"!!!","e":"AQAB"}`))
+	require.ErrorAs(t, err, &paramErr)
+	require.Equal(t, "n", paramErr.Member)
+
+	_, err = PEM2Keys([]byte(rsaPubPEM + "garbage"))
+	require.ErrorIs(t, err, ErrMalformedPEM)
+	pemErr := &PEMError{}
+	require.ErrorAs(t, err, &pemErr)
+	require.Equal(t, 1, pemErr.Block)
+
+	_, err = PEM2JWKMarshaler([]byte(rsaPubPEM + ecdsaPubPEM))
+	require.ErrorAs(t, err, &pemErr)
+	require.Equal(t, -1, pemErr.Block)
+
+	_, err = PEM2Keys([]byte(rsaPubPEM + brainpoolPubPEM))
+	require.ErrorIs(t, err, ErrMalformedPEM)
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+	require.ErrorAs(t, err, &pemErr)
+	require.Equal(t, 1, pemErr.Block)
+
+	_, err = Keys2JWKSMarshaler([]any{&dsa.PublicKey{}})
+	keyErr := &KeyError{}
+	require.ErrorAs(t, err, &keyErr)
+	require.Equal(t, 0, keyErr.Index)
+
+	_, err = JWKS2Keys([]byte(`{"keys":[{"kty":"RSA","alg":"RS256","n":"AQAB","e":"AQAB"},{"kid":"foo","kty":"OKP"}]}`))
+	require.ErrorAs(t, err, &keyErr)
+	require.Equal(t, 1, keyErr.Index)
+	require.Equal(t, "foo", keyErr.KeyID)
+
+	privErr := error(&PrivateKeyError{KeyIDs: []string{"a", "#1"}})
+	require.ErrorIs(t, privErr, ErrPrivateKey)
+	require.EqualError(t, privErr, "private key where public key expected: a, #1")
+}
+
+func TestNoPanics(t *testing.T) {
+	// Things that used to panic on unsupported key types
+	_, err := json.Marshal(&JWK{Key: &dsa.PublicKey{}})
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+
+	_, err = KeyIsPrivateErr("not a key")
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+
+	_, err = KeyPublicPartErr(42)
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+
+	_, err = Keys2PEM([]any{struct{}{}})
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+}
+
+func TestKeyHelpersCompatibility(t *testing.T) {
+	// The original signatures are kept, panics and all
+	keys, err := PEM2Keys([]byte(ecdsaPubPEM))
+	require.NoError(t, err)
+	require.False(t, KeyIsPrivate(keys[0]))
+	require.Equal(t, keys[0], KeyPublicPart(keys[0]))
+	require.Panics(t, func() { KeyIsPrivate("not a key") })
+	require.Panics(t, func() { KeyPublicPart(42) })
+}
@@ -0,0 +1,479 @@
+package jwks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OpenID Federation 1.0: finding an entity's keys by chaining trust up from it to a trust anchor whose keys we already have.
+//
+// Every entity publishes a self-signed Entity Configuration, naming its superiors in authority_hints.
+// Superiors publish Subordinate Statements about each of their subordinates, vouching for their federation keys (jwks) and constraining their metadata (metadata_policy).
+// A trust chain is the leaf's Entity Configuration, then a Subordinate Statement about each entity by the next one up, then the trust anchor's Entity Configuration (§4).
+//
+// We only apply as much metadata policy as is needed to get the leaf's keys out of its metadata; the rest of the metadata is left to the caller.
+// Leaves that publish their keys by jwks_uri or signed_jwks_uri rather than inline don't have any keys we can find. For signed_jwks_uri, fetch it and verify it with ParseSignedJWKS and TrustChain.FederationKeys.
+
+// EntityStatementType is the typ header of an entity statement
+const EntityStatementType = "entity-statement+jwt"
+
+// EntityStatement is a verified Entity Configuration (if Issuer == Subject) or Subordinate Statement (if not)
+type EntityStatement struct {
+	Issuer   string
+	Subject  string
+	IssuedAt time.Time
+	Expiry   time.Time
+	// Keys are Subject's federation keys (jwks), which sign its Entity Configuration and the Subordinate Statements it issues
+	Keys *JWKS
+	// AuthorityHints are the entity IDs of Subject's superiors. Only in Entity Configurations.
+	AuthorityHints []string
+	// Metadata is keyed by entity type (eg "openid_relying_party") then parameter
+	Metadata map[string]map[string]any
+	// MetadataPolicy is keyed by entity type, then parameter, then operator. Only in Subordinate Statements.
+	MetadataPolicy map[string]map[string]map[string]any
+
+	// Raw is the statement's JWT
+	Raw string
+}
+
+type entityStatementClaims struct {
+	jwt.RegisteredClaims
+	Keys           *PublicJWKS                          `json:"jwks"`
+	AuthorityHints []string                             `json:"authority_hints"`
+	Metadata       map[string]map[string]any            `json:"metadata"`
+	MetadataPolicy map[string]map[string]map[string]any `json:"metadata_policy"`
+	Crit           []string                             `json:"crit"`
+}
+
+// ParseEntityStatement verifies an entity statement against keys, which should be its issuer's federation keys, and unpacks it.
+// It must have iss, sub, iat, exp, and jwks, and not have expired.
+// opts are passed to the jwt parser, eg jwt.WithTimeFunc.
+// Failures wrap ErrInvalidEntityStatement.
+func ParseEntityStatement(token string, keys *JWKS, opts ...jwt.ParserOption) (*EntityStatement, error) {
+	claims := &entityStatementClaims{}
+	opts = append(opts, jwt.WithExpirationRequired(), jwt.WithIssuedAt())
+	tok, err := jwt.NewParser(opts...).ParseWithClaims(token, claims, keys.Keyfunc)
+	if err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidEntityStatement, Err: err}
+	}
+	if typ, _ := tok.Header["typ"].(string); !strings.EqualFold(typ, EntityStatementType) {
+		return nil, &wrappedError{sentinel: ErrInvalidEntityStatement, Err: fmt.Errorf("typ must be %s, not %q", EntityStatementType, typ)}
+	}
+	if claims.Issuer == "" {
+		return nil, &wrappedError{sentinel: ErrInvalidEntityStatement, Err: missingParameter("iss")}
+	}
+	if claims.Subject == "" {
+		return nil, &wrappedError{sentinel: ErrInvalidEntityStatement, Err: missingParameter("sub")}
+	}
+	if claims.IssuedAt == nil {
+		return nil, &wrappedError{sentinel: ErrInvalidEntityStatement, Err: missingParameter("iat")}
+	}
+	if claims.Keys == nil {
+		return nil, &wrappedError{sentinel: ErrInvalidEntityStatement, Err: missingParameter("jwks")}
+	}
+	// We don't understand any extension claims, so can't honour any that are critical
+	if len(claims.Crit) != 0 {
+		return nil, &wrappedError{sentinel: ErrInvalidEntityStatement, Err: fmt.Errorf("unsupported critical claims %v", claims.Crit)}
+	}
+
+	return &EntityStatement{
+		Issuer:         claims.Issuer,
+		Subject:        claims.Subject,
+		IssuedAt:       claims.IssuedAt.Time,
+		Expiry:         claims.ExpiresAt.Time,
+		Keys:           &claims.Keys.JWKS,
+		AuthorityHints: claims.AuthorityHints,
+		Metadata:       claims.Metadata,
+		MetadataPolicy: claims.MetadataPolicy,
+		Raw:            token,
+	}, nil
+}
+
+// ParseEntityConfiguration verifies an Entity Configuration, which is signed by one of the keys it contains, and unpacks it.
+// Note that this only shows the statement is self-consistent; the entity's keys aren't trusted until a trust chain vouches for them.
+func ParseEntityConfiguration(token string, opts ...jwt.ParserOption) (*EntityStatement, error) {
+	claims := &entityStatementClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidEntityStatement, Err: err}
+	}
+	if claims.Keys == nil {
+		return nil, &wrappedError{sentinel: ErrInvalidEntityStatement, Err: missingParameter("jwks")}
+	}
+	es, err := ParseEntityStatement(token, &claims.Keys.JWKS, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if es.Issuer != es.Subject {
+		return nil, &wrappedError{sentinel: ErrInvalidEntityStatement, Err: fmt.Errorf("entity configuration's iss %q isn't its sub %q", es.Issuer, es.Subject)}
+	}
+	return es, nil
+}
+
+// ===
+// Fetching
+// ===
+
+// EntityStatementFetcher gets entity statements, eg over HTTP
+type EntityStatementFetcher interface {
+	// EntityConfiguration gets entityID's Entity Configuration
+	EntityConfiguration(ctx context.Context, entityID string) (string, error)
+	// SubordinateStatement gets the statement superior makes about subject
+	SubordinateStatement(ctx context.Context, superior *EntityStatement, subject string) (string, error)
+}
+
+// HTTPEntityStatementFetcher fetches Entity Configurations from their well-known URLs (§9), and Subordinate Statements from their issuers' fetch endpoints (§8.1)
+type HTTPEntityStatementFetcher struct {
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+func (f *HTTPEntityStatementFetcher) EntityConfiguration(ctx context.Context, entityID string) (string, error) {
+	return f.get(ctx, strings.TrimSuffix(entityID, "/")+"/.well-known/openid-federation")
+}
+
+func (f *HTTPEntityStatementFetcher) SubordinateStatement(ctx context.Context, superior *EntityStatement, subject string) (string, error) {
+	endpoint, _ := superior.Metadata["federation_entity"]["federation_fetch_endpoint"].(string)
+	if endpoint == "" {
+		return "", fmt.Errorf("%s has no federation_fetch_endpoint", superior.Subject)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("sub", subject)
+	u.RawQuery = q.Encode()
+	return f.get(ctx, u.String())
+}
+
+func (f *HTTPEntityStatementFetcher) get(ctx context.Context, u string) (string, error) {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: %s", u, resp.Status)
+	}
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+// ===
+// Trust chains
+// ===
+
+// TrustChain is a verified chain of entity statements from a leaf to a trust anchor
+type TrustChain struct {
+	// Statements are the leaf's Entity Configuration, the Subordinate Statements up the chain, and the trust anchor's Entity Configuration.
+	// For a chain with just a trust anchor, there's only one.
+	Statements []*EntityStatement
+	// Expiry is the earliest of the statements', after which the chain must be re-established
+	Expiry time.Time
+}
+
+// TrustAnchor is the entity ID of the chain's trust anchor
+func (c *TrustChain) TrustAnchor() string {
+	return c.Statements[len(c.Statements)-1].Subject
+}
+
+// FederationKeys are the leaf's federation keys, as vouched for by its immediate superior
+func (c *TrustChain) FederationKeys() *JWKS {
+	if len(c.Statements) == 1 {
+		return c.Statements[0].Keys
+	}
+	return c.Statements[1].Keys
+}
+
+// Keys extracts the jwks from the leaf's metadata for entityType (eg "openid_relying_party"), after applying the chain's metadata policy to it (§6.1).
+// Those are the keys the entity uses in that role, eg to sign request objects; not its federation keys.
+func (c *TrustChain) Keys(entityType string) (*JWKS, error) {
+	var jwks any
+	present := false
+	if v, ok := c.Statements[0].Metadata[entityType]["jwks"]; ok {
+		jwks, present = v, true
+	}
+	// the immediate superior can override the leaf's metadata
+	if len(c.Statements) > 1 {
+		if v, ok := c.Statements[1].Metadata[entityType]["jwks"]; ok {
+			jwks, present = v, true
+		}
+	}
+
+	// Policies from the Subordinate Statements, trust anchor's first, merged (§6.1.4.2).
+	// value, default, and essential are the only operators that make sense for an object like jwks; any others would fail anyway.
+	var value, dflt any
+	hasValue, hasDefault, essential := false, false, false
+	for i := len(c.Statements) - 2; i >= 1; i-- {
+		policy := c.Statements[i].MetadataPolicy[entityType]["jwks"]
+		for op, operand := range policy {
+			switch op {
+			case "value":
+				if hasValue && !reflect.DeepEqual(value, operand) {
+					return nil, fmt.Errorf("%s's jwks value policy conflicts with its superiors'", c.Statements[i].Issuer)
+				}
+				value, hasValue = operand, true
+			case "default":
+				if hasDefault && !reflect.DeepEqual(dflt, operand) {
+					return nil, fmt.Errorf("%s's jwks default policy conflicts with its superiors'", c.Statements[i].Issuer)
+				}
+				dflt, hasDefault = operand, true
+			case "essential":
+				b, ok := operand.(bool)
+				if !ok {
+					return nil, fmt.Errorf("%s's jwks essential policy isn't a boolean", c.Statements[i].Issuer)
+				}
+				essential = essential || b
+			default:
+				return nil, fmt.Errorf("%s's jwks %s policy can't be applied to an object", c.Statements[i].Issuer, op)
+			}
+		}
+	}
+	if hasValue {
+		jwks, present = value, value != nil
+	} else if !present && hasDefault {
+		jwks, present = dflt, true
+	}
+	if !present {
+		if essential {
+			return nil, fmt.Errorf("policy requires %s metadata to have jwks, and it doesn't", entityType)
+		}
+		return nil, fmt.Errorf("%s has no jwks in its %s metadata", c.Statements[0].Subject, entityType)
+	}
+
+	bs, err := json.Marshal(jwks)
+	if err != nil {
+		return nil, err
+	}
+	set, _, err := UnmarshalOptions{RejectPrivate: true}.Unmarshal(bs)
+	return set, err
+}
+
+// TrustChainResolver establishes trust chains from entities up to any of a set of trust anchors
+type TrustChainResolver struct {
+	// TrustAnchors maps the entity IDs of the trust anchors to their federation keys, obtained out of band
+	TrustAnchors map[string]*JWKS
+	// Fetcher gets entity statements. Defaults to an HTTPEntityStatementFetcher.
+	Fetcher EntityStatementFetcher
+	// MaxPathLength is how many superiors to go through to reach a trust anchor. Defaults to 8.
+	MaxPathLength int
+	// MaxFetches is how many entity statements Resolve will fetch before giving up, as each entity can name any number of authority hints. Defaults to 64.
+	MaxFetches int
+	// Now is the clock; defaults to time.Now
+	Now func() time.Time
+}
+
+func (r *TrustChainResolver) parserOptions() []jwt.ParserOption {
+	if r.Now != nil {
+		return []jwt.ParserOption{jwt.WithTimeFunc(r.Now)}
+	}
+	return nil
+}
+
+// Verify checks a trust chain that's been presented to us, eg in a trust_chain header (§4.3).
+// statements are entity statement JWTs, in the order of TrustChain.Statements.
+// Failures wrap ErrNoTrustChain.
+func (r *TrustChainResolver) Verify(statements []string) (*TrustChain, error) {
+	c, err := r.verify(statements)
+	if err != nil {
+		subject := ""
+		if len(statements) != 0 {
+			claims := jwt.RegisteredClaims{}
+			if _, _, err := jwt.NewParser().ParseUnverified(statements[0], &claims); err == nil {
+				subject = claims.Subject
+			}
+		}
+		return nil, &TrustChainError{EntityID: subject, Err: err}
+	}
+	return c, nil
+}
+
+func (r *TrustChainResolver) verify(statements []string) (*TrustChain, error) {
+	if len(statements) == 0 {
+		return nil, errors.New("empty trust chain")
+	}
+	opts := r.parserOptions()
+
+	// From the top: the trust anchor's Entity Configuration must verify with the keys we have for it
+	n := len(statements)
+	chain := make([]*EntityStatement, n)
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(statements[n-1], &claims); err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidEntityStatement, Err: err}
+	}
+	anchorKeys, ok := r.TrustAnchors[claims.Issuer]
+	if !ok {
+		return nil, fmt.Errorf("%q isn't a trust anchor", claims.Issuer)
+	}
+	es, err := ParseEntityStatement(statements[n-1], anchorKeys, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if es.Issuer != es.Subject {
+		return nil, fmt.Errorf("last statement in the chain isn't the trust anchor's entity configuration")
+	}
+	chain[n-1] = es
+
+	// then each statement down must be signed by the keys its superior's statement vouches for
+	for i := n - 2; i >= 0; i-- {
+		es, err := ParseEntityStatement(statements[i], chain[i+1].Keys, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if es.Issuer != chain[i+1].Subject {
+			return nil, fmt.Errorf("statement %d is issued by %s, not %s", i, es.Issuer, chain[i+1].Subject)
+		}
+		chain[i] = es
+	}
+	if chain[0].Issuer != chain[0].Subject {
+		return nil, fmt.Errorf("first statement in the chain isn't the leaf's entity configuration")
+	}
+
+	c := &TrustChain{Statements: chain, Expiry: chain[0].Expiry}
+	for _, es := range chain[1:] {
+		if es.Expiry.Before(c.Expiry) {
+			c.Expiry = es.Expiry
+		}
+	}
+	return c, nil
+}
+
+// Resolve establishes a trust chain from entityID to one of the trust anchors, by following authority_hints up from its Entity Configuration.
+// The first chain found is returned. If there are none, the error wraps ErrNoTrustChain, and the reasons each path failed.
+func (r *TrustChainResolver) Resolve(ctx context.Context, entityID string) (*TrustChain, error) {
+	fetcher := r.Fetcher
+	if fetcher == nil {
+		fetcher = &HTTPEntityStatementFetcher{}
+	}
+	maxPath := r.MaxPathLength
+	if maxPath == 0 {
+		maxPath = 8
+	}
+
+	raw, err := fetcher.EntityConfiguration(ctx, entityID)
+	if err != nil {
+		return nil, &TrustChainError{EntityID: entityID, Err: err}
+	}
+	leaf, err := ParseEntityConfiguration(raw, r.parserOptions()...)
+	if err != nil {
+		return nil, &TrustChainError{EntityID: entityID, Err: err}
+	}
+	if leaf.Subject != entityID {
+		return nil, &TrustChainError{EntityID: entityID, Err: fmt.Errorf("entity configuration is for %s", leaf.Subject)}
+	}
+
+	var c *TrustChain
+	if _, ok := r.TrustAnchors[entityID]; ok {
+		c, err = r.verify([]string{raw})
+	} else {
+		maxFetches := r.MaxFetches
+		if maxFetches == 0 {
+			maxFetches = 64
+		}
+		search := &trustChainSearch{fetcher: fetcher, tried: map[string]bool{entityID: true}, fetches: 1, maxFetches: maxFetches}
+		c, err = r.resolve(ctx, search, leaf, []string{raw}, maxPath)
+	}
+	if err != nil {
+		return nil, &TrustChainError{EntityID: entityID, Err: err}
+	}
+	return c, nil
+}
+
+// trustChainSearch is the state of a Resolve, shared by all the paths it tries
+type trustChainSearch struct {
+	fetcher EntityStatementFetcher
+	// tried are the entity IDs whose authority hints have been (or are being) followed, wherever they were found.
+	// That stops loops, and stops an entity being explored again when several of its subordinates name it.
+	tried      map[string]bool
+	fetches    int
+	maxFetches int
+}
+
+var errTooManyFetches = errors.New("too many entity statements fetched")
+
+// fetch counts a fetch against the search's budget
+func (s *trustChainSearch) fetch() error {
+	if s.fetches >= s.maxFetches {
+		return fmt.Errorf("%w (%d)", errTooManyFetches, s.maxFetches)
+	}
+	s.fetches++
+	return nil
+}
+
+// resolve extends path, which ends with a statement about ec.Subject, up to a trust anchor. ec is that entity's Entity Configuration, for its authority hints.
+func (r *TrustChainResolver) resolve(ctx context.Context, search *trustChainSearch, ec *EntityStatement, path []string, remaining int) (*TrustChain, error) {
+	if _, ok := r.TrustAnchors[ec.Subject]; ok {
+		return r.verify(append(path, ec.Raw))
+	}
+	if len(ec.AuthorityHints) == 0 {
+		return nil, fmt.Errorf("%s has no authority hints", ec.Subject)
+	}
+	if remaining == 0 {
+		return nil, fmt.Errorf("%s: too far from a trust anchor", ec.Subject)
+	}
+
+	var errs []error
+	for _, hint := range ec.AuthorityHints {
+		if search.tried[hint] {
+			continue
+		}
+		search.tried[hint] = true
+		c, err := func() (*TrustChain, error) {
+			if err := search.fetch(); err != nil {
+				return nil, err
+			}
+			raw, err := search.fetcher.EntityConfiguration(ctx, hint)
+			if err != nil {
+				return nil, err
+			}
+			superior, err := ParseEntityConfiguration(raw, r.parserOptions()...)
+			if err != nil {
+				return nil, err
+			}
+			if superior.Subject != hint {
+				return nil, fmt.Errorf("entity configuration is for %s", superior.Subject)
+			}
+			if err := search.fetch(); err != nil {
+				return nil, err
+			}
+			ss, err := search.fetcher.SubordinateStatement(ctx, superior, ec.Subject)
+			if err != nil {
+				return nil, err
+			}
+			return r.resolve(ctx, search, superior, append(slices.Clip(path), ss), remaining-1)
+		}()
+		if err == nil {
+			return c, nil
+		}
+		if errors.Is(err, errTooManyFetches) {
+			return nil, err // no point trying the other hints
+		}
+		errs = append(errs, fmt.Errorf("via %s: %w", hint, err))
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("%s's authority hints only lead round in circles, or to entities already tried", ec.Subject)
+	}
+	return nil, errors.Join(errs...)
+}
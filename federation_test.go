@@ -0,0 +1,298 @@
+package jwks
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// federationStandIn serves entity configurations at /<name>/.well-known/openid-federation and subordinate statements at /<name>/fetch
+type federationStandIn struct {
+	*httptest.Server
+	configurations map[string]string            // name -> EC
+	subordinates   map[string]map[string]string // name -> subject entity ID -> statement
+}
+
+func newFederationStandIn() *federationStandIn {
+	f := &federationStandIn{configurations: map[string]string{}, subordinates: map[string]map[string]string{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		var es string
+		switch rest {
+		case ".well-known/openid-federation":
+			es = f.configurations[name]
+		case "fetch":
+			es = f.subordinates[name][r.URL.Query().Get("sub")]
+		}
+		if es == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/entity-statement+jwt")
+		w.Write([]byte(es))
+	}))
+	return f
+}
+
+type federationEntity struct {
+	id  string
+	key *ecdsa.PrivateKey
+	pub *JWKS
+}
+
+func newFederationEntity(t *testing.T, id string) *federationEntity {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &federationEntity{id: id, key: key, pub: &JWKS{Keys: []*JWK{{KeyID: "fed", Key: &key.PublicKey}}}}
+}
+
+// sign issues a statement about sub (whose federation keys are subKeys)
+func (e *federationEntity) sign(t *testing.T, sub string, subKeys *JWKS, extra jwt.MapClaims) string {
+	now := time.Now()
+	claims := jwt.MapClaims{"iss": e.id, "sub": sub, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix(), "jwks": subKeys}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	tok.Header["typ"] = "entity-statement+jwt"
+	tok.Header["kid"] = "fed"
+	s, err := tok.SignedString(e.key)
+	require.NoError(t, err)
+	return s
+}
+
+func (e *federationEntity) configuration(t *testing.T, extra jwt.MapClaims) string {
+	return e.sign(t, e.id, e.pub, extra)
+}
+
+func TestTrustChainResolve(t *testing.T) {
+	f := newFederationStandIn()
+	defer f.Close()
+	ta := newFederationEntity(t, f.URL+"/ta")
+	im := newFederationEntity(t, f.URL+"/im")
+	rp := newFederationEntity(t, f.URL+"/rp")
+
+	rpKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	rpKeys := &JWKS{Keys: []*JWK{{KeyID: "rp-sig", Key: &rpKey.PublicKey}}}
+
+	fetchable := func(e *federationEntity) jwt.MapClaims {
+		return jwt.MapClaims{"metadata": map[string]any{"federation_entity": map[string]any{"federation_fetch_endpoint": e.id + "/fetch"}}}
+	}
+	f.configurations["ta"] = ta.configuration(t, fetchable(ta))
+	f.configurations["im"] = im.configuration(t, jwt.MapClaims{"authority_hints": []string{ta.id}, "metadata": fetchable(im)["metadata"]})
+	// the first hint is a dead end
+	f.configurations["rp"] = rp.configuration(t, jwt.MapClaims{
+		"authority_hints": []string{f.URL + "/nowhere", im.id},
+		"metadata":        map[string]any{"openid_relying_party": map[string]any{"jwks": rpKeys}},
+	})
+	f.subordinates["ta"] = map[string]string{im.id: ta.sign(t, im.id, im.pub, jwt.MapClaims{
+		"metadata_policy": map[string]any{"openid_relying_party": map[string]any{"jwks": map[string]any{"essential": true}}},
+	})}
+	f.subordinates["im"] = map[string]string{rp.id: im.sign(t, rp.id, rp.pub, nil)}
+
+	r := &TrustChainResolver{TrustAnchors: map[string]*JWKS{ta.id: ta.pub}}
+	c, err := r.Resolve(context.Background(), rp.id)
+	require.NoError(t, err)
+	require.Len(t, c.Statements, 4)
+	require.Equal(t, ta.id, c.TrustAnchor())
+	require.Equal(t, rp.id, c.Statements[1].Subject)
+	require.Equal(t, im.id, c.Statements[1].Issuer)
+	require.True(t, rp.key.PublicKey.Equal(c.FederationKeys().Keys[0].Key))
+	require.False(t, c.Expiry.IsZero())
+
+	keys, err := c.Keys("openid_relying_party")
+	require.NoError(t, err)
+	require.Equal(t, "rp-sig", keys.Keys[0].KeyID)
+	require.True(t, rpKey.PublicKey.Equal(keys.Keys[0].Key))
+	_, err = c.Keys("openid_provider")
+	require.ErrorContains(t, err, "no jwks")
+
+	// The same chain, presented to us
+	raws := []string{}
+	for _, es := range c.Statements {
+		raws = append(raws, es.Raw)
+	}
+	_, err = r.Verify(raws)
+	require.NoError(t, err)
+	_, err = r.Verify(raws[:3])
+	require.ErrorIs(t, err, ErrNoTrustChain)
+	_, err = r.Verify([]string{raws[0], raws[2], raws[3]})
+	require.ErrorIs(t, err, ErrInvalidEntityStatement)
+	_, err = (&TrustChainResolver{TrustAnchors: map[string]*JWKS{im.id: im.pub}}).Verify(raws)
+	require.ErrorContains(t, err, "isn't a trust anchor")
+
+	// A trust anchor is trusted on its own
+	c, err = r.Resolve(context.Background(), ta.id)
+	require.NoError(t, err)
+	require.Len(t, c.Statements, 1)
+
+	// Statements expire
+	later := &TrustChainResolver{TrustAnchors: r.TrustAnchors, Now: func() time.Time { return time.Now().Add(2 * time.Hour) }}
+	_, err = later.Resolve(context.Background(), rp.id)
+	require.ErrorIs(t, err, ErrNoTrustChain)
+	require.ErrorIs(t, err, jwt.ErrTokenExpired)
+
+	// The leaf's configuration must be signed with the keys its superior vouches for
+	impostor := newFederationEntity(t, rp.id)
+	f.configurations["rp"] = impostor.configuration(t, jwt.MapClaims{"authority_hints": []string{im.id}})
+	_, err = r.Resolve(context.Background(), rp.id)
+	require.ErrorIs(t, err, ErrNoTrustChain)
+	require.ErrorIs(t, err, ErrInvalidEntityStatement)
+
+	// Going round in circles
+	f.configurations["im"] = im.configuration(t, jwt.MapClaims{"authority_hints": []string{rp.id}, "metadata": fetchable(im)["metadata"]})
+	f.subordinates["rp"] = map[string]string{im.id: rp.sign(t, im.id, im.pub, nil)}
+	f.configurations["rp"] = rp.configuration(t, jwt.MapClaims{"authority_hints": []string{im.id}, "metadata": fetchable(rp)["metadata"]})
+	_, err = r.Resolve(context.Background(), rp.id)
+	require.ErrorContains(t, err, "round in circles")
+
+	// No way up
+	_, err = (&TrustChainResolver{TrustAnchors: map[string]*JWKS{f.URL + "/other": ta.pub}}).Resolve(context.Background(), ta.id)
+	require.ErrorContains(t, err, "has no authority hints")
+}
+
+// fanOutFetcher is a federation where every entity has hints authority hints, generated on demand, none of which lead to a trust anchor.
+// Every entity's hints are the same, so the second level is shared between all of the first: a diamond, then a mesh.
+type fanOutFetcher struct {
+	t       *testing.T
+	key     *federationEntity
+	hints   int
+	fetches map[string]int
+}
+
+func (f *fanOutFetcher) entity(id string) *federationEntity {
+	return &federationEntity{id: id, key: f.key.key, pub: f.key.pub}
+}
+
+func (f *fanOutFetcher) EntityConfiguration(_ context.Context, entityID string) (string, error) {
+	f.fetches[entityID]++
+	hints := []string{}
+	depth := strings.Count(entityID, "/")
+	for i := 0; i < f.hints; i++ {
+		hints = append(hints, fmt.Sprintf("https://e%d.example/%d", depth, i))
+	}
+	return f.entity(entityID).configuration(f.t, jwt.MapClaims{"authority_hints": hints}), nil
+}
+
+func (f *fanOutFetcher) SubordinateStatement(_ context.Context, superior *EntityStatement, subject string) (string, error) {
+	f.fetches[superior.Subject+" -> "+subject]++
+	return f.entity(superior.Subject).sign(f.t, subject, f.key.pub, nil), nil
+}
+
+func TestTrustChainResolveFanOut(t *testing.T) {
+	ta := newFederationEntity(t, "https://ta.example")
+	fetcher := &fanOutFetcher{t: t, key: newFederationEntity(t, ""), hints: 20, fetches: map[string]int{}}
+	r := &TrustChainResolver{TrustAnchors: map[string]*JWKS{ta.id: ta.pub}, Fetcher: fetcher}
+
+	// 20^8 paths, but the fetches are capped
+	_, err := r.Resolve(context.Background(), "https://leaf")
+	require.ErrorIs(t, err, ErrNoTrustChain)
+	require.ErrorContains(t, err, "too many entity statements fetched")
+	total := 0
+	for what, n := range fetcher.fetches {
+		require.Equal(t, 1, n, "%s fetched more than once", what)
+		total += n
+	}
+	require.Equal(t, 64, total)
+
+	// With a big enough budget, the search is exhaustive, but each entity's only explored once
+	fetcher.hints, fetcher.fetches = 3, map[string]int{}
+	r.MaxFetches = 1000
+	_, err = r.Resolve(context.Background(), "https://leaf")
+	require.ErrorIs(t, err, ErrNoTrustChain)
+	for what, n := range fetcher.fetches {
+		require.Equal(t, 1, n, "%s fetched more than once", what)
+	}
+}
+
+func TestParseEntityStatement(t *testing.T) {
+	e := newFederationEntity(t, "https://op.example.com")
+
+	es, err := ParseEntityConfiguration(e.configuration(t, jwt.MapClaims{"authority_hints": []string{"https://ta.example.com"}}))
+	require.NoError(t, err)
+	require.Equal(t, "https://op.example.com", es.Subject)
+	require.Equal(t, []string{"https://ta.example.com"}, es.AuthorityHints)
+
+	cases := []struct {
+		name   string
+		tok    string
+		expect string
+	}{
+		{"not self-issued", e.sign(t, "https://rp.example.com", e.pub, nil), "isn't its sub"},
+		{"self-signed with another key", newFederationEntity(t, "x").sign(t, e.id, e.pub, jwt.MapClaims{"iss": e.id}), "verification error"},
+		{"no exp", e.configuration(t, jwt.MapClaims{"exp": nil}), "exp"},
+		{"crit", e.configuration(t, jwt.MapClaims{"crit": []string{"foo"}, "foo": 1}), "critical"},
+	}
+	for _, cse := range cases {
+		_, err := ParseEntityConfiguration(cse.tok)
+		require.ErrorIs(t, err, ErrInvalidEntityStatement, cse.name)
+		require.ErrorContains(t, err, cse.expect, cse.name)
+	}
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"iss": e.id, "sub": e.id, "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(), "jwks": e.pub})
+	tok.Header["kid"] = "fed"
+	s, err := tok.SignedString(e.key)
+	require.NoError(t, err)
+	_, err = ParseEntityStatement(s, e.pub)
+	require.ErrorContains(t, err, "typ must be")
+}
+
+func TestTrustChainKeysPolicy(t *testing.T) {
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	policyKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwksOf := func(kid string, k *ecdsa.PrivateKey) map[string]any {
+		j, err := Key2JWKMarshaler(&k.PublicKey)
+		require.NoError(t, err)
+		j.KeyID = kid
+		bs, err := (&JWKS{Keys: []*JWK{j}}).MarshalJSON()
+		require.NoError(t, err)
+		m := map[string]any{}
+		require.NoError(t, json.Unmarshal(bs, &m))
+		return m
+	}
+	chain := func(leafMD map[string]any, policies ...map[string]any) *TrustChain {
+		c := &TrustChain{Statements: []*EntityStatement{{Subject: "leaf", Metadata: map[string]map[string]any{"openid_provider": leafMD}}}}
+		for i, p := range policies {
+			c.Statements = append(c.Statements, &EntityStatement{
+				Issuer:         "sup" + string(rune('0'+i)),
+				MetadataPolicy: map[string]map[string]map[string]any{"openid_provider": {"jwks": p}},
+			})
+		}
+		c.Statements = append(c.Statements, &EntityStatement{Subject: "ta"})
+		return c
+	}
+	kidOf := func(c *TrustChain) string {
+		keys, err := c.Keys("openid_provider")
+		require.NoError(t, err)
+		return keys.Keys[0].KeyID
+	}
+
+	leaf := map[string]any{"jwks": jwksOf("leaf", leafKey)}
+	require.Equal(t, "leaf", kidOf(chain(leaf)))
+	require.Equal(t, "policy", kidOf(chain(leaf, map[string]any{"value": jwksOf("policy", policyKey)})))
+	require.Equal(t, "leaf", kidOf(chain(leaf, map[string]any{"default": jwksOf("policy", policyKey)})))
+	require.Equal(t, "policy", kidOf(chain(map[string]any{}, map[string]any{"default": jwksOf("policy", policyKey)})))
+
+	_, err = chain(map[string]any{}, map[string]any{"essential": true}).Keys("openid_provider")
+	require.ErrorContains(t, err, "policy requires")
+	_, err = chain(leaf, map[string]any{"value": jwksOf("a", policyKey)}, map[string]any{"value": jwksOf("b", policyKey)}).Keys("openid_provider")
+	require.ErrorContains(t, err, "conflicts")
+	_, err = chain(leaf, map[string]any{"subset_of": []any{}}).Keys("openid_provider")
+	require.ErrorContains(t, err, "can't be applied")
+	_, err = chain(leaf, map[string]any{"value": nil}).Keys("openid_provider")
+	require.ErrorContains(t, err, "no jwks")
+}
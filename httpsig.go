@@ -0,0 +1,674 @@
+package jwks
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mt-inside/go-jwks/internal/sfv"
+)
+
+// HTTP Message Signatures (RFC 9421) over requests, with keys from a JWKS, found by keyid.
+
+// httpSigAlgorithms are the RFC 9421 §3.3 algorithms we support, and the JWS algs that mean the same thing
+var httpSigAlgorithms = map[string]string{
+	"rsa-pss-sha512":    "PS512",
+	"rsa-v1_5-sha256":   "RS256",
+	"ecdsa-p256-sha256": "ES256",
+	"ecdsa-p384-sha384": "ES384",
+	"ed25519":           "EdDSA",
+}
+
+// HTTPSignatureVerifier checks the signatures on incoming requests
+type HTTPSignatureVerifier struct {
+	// Keys are the signers' public keys; each signature's keyid must be the kid of one of them
+	Keys *JWKS
+	// Label, if set, is the only signature looked at. Otherwise, any signature that verifies will do.
+	Label string
+	// RequiredComponents must all be covered by the signature, eg "@method", "content-digest", `@query-param;name="id"`
+	RequiredComponents []string
+	// MaxAge, if set, is how old a signature's created may be; signatures without one are refused
+	MaxAge time.Duration
+	// Now is the clock; defaults to time.Now
+	Now func() time.Time
+	// MaxBodySize is the largest body that'll be read to check a covered content-digest; bigger ones are refused. Defaults to DefaultHTTPSignatureMaxBodySize
+	MaxBodySize int64
+}
+
+// DefaultHTTPSignatureMaxBodySize is the default HTTPSignatureVerifier.MaxBodySize
+const DefaultHTTPSignatureMaxBodySize = 10 << 20
+
+// HTTPSignature is a signature that's been verified
+type HTTPSignature struct {
+	Label      string
+	Key        *JWK
+	Algorithm  string   // RFC 9421 algorithm name, eg "ecdsa-p256-sha256"
+	Components []string // Covered components, in the format of HTTPSignatureVerifier.RequiredComponents
+	Created    time.Time
+	Expires    time.Time
+	Nonce      string
+	Tag        string
+}
+
+// Verify checks the request's Signature and Signature-Input fields. Failures are HTTPSignatureErrors.
+// If the signature covers content-digest, the body is read (up to MaxBodySize) and checked against it (sha-256 and sha-512 are supported), then put back for the handler.
+func (v *HTTPSignatureVerifier) Verify(r *http.Request) (*HTTPSignature, error) {
+	inputs, err := sfv.ParseDictionary(strings.Join(r.Header.Values("Signature-Input"), ", "))
+	if err != nil {
+		return nil, &HTTPSignatureError{Err: fmt.Errorf("Signature-Input: %w", err)}
+	}
+	sigs, err := sfv.ParseDictionary(strings.Join(r.Header.Values("Signature"), ", "))
+	if err != nil {
+		return nil, &HTTPSignatureError{Err: fmt.Errorf("Signature: %w", err)}
+	}
+
+	var labels []string
+	if v.Label != "" {
+		labels = []string{v.Label}
+	} else {
+		for _, m := range inputs {
+			labels = append(labels, m.Key)
+		}
+	}
+	if len(labels) == 0 {
+		return nil, &HTTPSignatureError{Err: fmt.Errorf("request isn't signed")}
+	}
+
+	var errs []error
+	for _, label := range labels {
+		sig, err := v.verify(r, label, inputs, sigs)
+		if err == nil {
+			return sig, nil
+		}
+		errs = append(errs, &HTTPSignatureError{Label: label, Err: err})
+	}
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (v *HTTPSignatureVerifier) verify(r *http.Request, label string, inputs, sigs sfv.Dictionary) (*HTTPSignature, error) {
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+
+	in, ok := inputs.Get(label)
+	if !ok {
+		return nil, fmt.Errorf("no Signature-Input")
+	}
+	input, ok := in.(sfv.InnerList)
+	if !ok {
+		return nil, fmt.Errorf("Signature-Input must be an inner list")
+	}
+	s, ok := sigs.Get(label)
+	if !ok {
+		return nil, fmt.Errorf("no Signature")
+	}
+	sigItem, ok := s.(sfv.Item)
+	if !ok {
+		return nil, fmt.Errorf("Signature must be a byte sequence")
+	}
+	sigBytes, ok := sigItem.Value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("Signature must be a byte sequence")
+	}
+
+	out := &HTTPSignature{Label: label}
+	for _, p := range input.Params {
+		var ok bool
+		switch p.Key {
+		case "created", "expires":
+			var t int64
+			t, ok = p.Value.(int64)
+			if p.Key == "created" {
+				out.Created = time.Unix(t, 0)
+			} else {
+				out.Expires = time.Unix(t, 0)
+			}
+		case "keyid":
+			var kid string
+			kid, ok = p.Value.(string)
+			if ok {
+				if out.Key = v.Keys.KeyByID(kid); out.Key == nil {
+					return nil, fmt.Errorf("no key with kid %q", kid)
+				}
+			}
+		case "alg":
+			out.Algorithm, ok = p.Value.(string)
+		case "nonce":
+			out.Nonce, ok = p.Value.(string)
+		case "tag":
+			out.Tag, ok = p.Value.(string)
+		default:
+			ok = true // Unknown parameters are signed over, but otherwise ignored
+		}
+		if !ok {
+			return nil, fmt.Errorf("parameter %s has the wrong type", p.Key)
+		}
+	}
+	if out.Key == nil {
+		return nil, fmt.Errorf("keyid is required")
+	}
+
+	for _, it := range input.Items {
+		id, err := httpSigComponentID(it)
+		if err != nil {
+			return nil, err
+		}
+		out.Components = append(out.Components, id)
+	}
+	for _, req := range v.RequiredComponents {
+		if !slices.Contains(out.Components, req) {
+			return nil, fmt.Errorf("doesn't cover %s", req)
+		}
+	}
+
+	t := now()
+	if v.MaxAge != 0 {
+		if out.Created.IsZero() {
+			return nil, fmt.Errorf("created is required")
+		}
+		if out.Created.Before(t.Add(-v.MaxAge)) {
+			return nil, fmt.Errorf("created too long ago, at %v", out.Created)
+		}
+	}
+	if !out.Created.IsZero() && out.Created.After(t.Add(time.Minute)) {
+		return nil, fmt.Errorf("created in the future, at %v", out.Created)
+	}
+	if !out.Expires.IsZero() && t.After(out.Expires) {
+		return nil, fmt.Errorf("expired at %v", out.Expires)
+	}
+
+	alg, err := httpSigAlgorithm(out.Algorithm, out.Key)
+	if err != nil {
+		return nil, err
+	}
+	out.Algorithm = alg
+
+	base, err := httpSignatureBase(r, input)
+	if err != nil {
+		return nil, err
+	}
+	if err := httpSigVerify(alg, out.Key.Key, base, sigBytes); err != nil {
+		return nil, err
+	}
+	// Covering content-digest only protects the body if the digest is of it
+	if slices.Contains(out.Components, "content-digest") {
+		maxBody := v.MaxBodySize
+		if maxBody == 0 {
+			maxBody = DefaultHTTPSignatureMaxBodySize
+		}
+		if err := checkContentDigest(r, maxBody); err != nil {
+			return nil, fmt.Errorf("content-digest: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// ===
+// Content-Digest (RFC 9530)
+// ===
+
+var contentDigestAlgorithms = map[string]crypto.Hash{
+	"sha-256": crypto.SHA256,
+	"sha-512": crypto.SHA512,
+}
+
+// checkContentDigest reads r's body, which must match each of the sha-256 and sha-512 digests in its Content-Digest, and there must be at least one.
+// The body is buffered, and r.Body replaced with it, so handlers can still read it; bodies over maxBody bytes are refused rather than read.
+func checkContentDigest(r *http.Request, maxBody int64) error {
+	digests, err := sfv.ParseDictionary(strings.Join(r.Header.Values("Content-Digest"), ", "))
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err = io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+		r.Body.Close()
+		if err != nil {
+			return fmt.Errorf("reading body: %w", err)
+		}
+		if int64(len(body)) > maxBody {
+			return fmt.Errorf("body is over %d bytes", maxBody)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	checked := false
+	for _, m := range digests {
+		hash, ok := contentDigestAlgorithms[m.Key]
+		if !ok {
+			continue // Other algorithms are allowed, but we can't check them, so they don't count
+		}
+		it, ok := m.Value.(sfv.Item)
+		if !ok {
+			return fmt.Errorf("%s must be a byte sequence", m.Key)
+		}
+		want, ok := it.Value.([]byte)
+		if !ok {
+			return fmt.Errorf("%s must be a byte sequence", m.Key)
+		}
+		h := hash.New()
+		h.Write(body)
+		if !bytes.Equal(h.Sum(nil), want) {
+			return fmt.Errorf("%s doesn't match the body", m.Key)
+		}
+		checked = true
+	}
+	if !checked {
+		return fmt.Errorf("no sha-256 or sha-512 digest")
+	}
+	return nil
+}
+
+// HTTPSigner signs outgoing requests
+type HTTPSigner struct {
+	// Key is the private key to sign with. Its kid is sent as the keyid.
+	Key *JWK
+	// Label for the signature; defaults to "sig1"
+	Label string
+	// Components to cover; defaults to "@method", "@authority", "@path", and "content-digest" if the request has one
+	Components []string
+	// Algorithm is the RFC 9421 algorithm name. Defaults to the one matching the key's alg, or its type.
+	Algorithm string
+	// Expiry, if set, is how long signatures are good for
+	Expiry time.Duration
+	// Nonce and Tag, if set, are included in the signature parameters
+	Nonce, Tag string
+	// Now is the clock; defaults to time.Now
+	Now func() time.Time
+}
+
+// Sign adds a signature to r, in its Signature and Signature-Input fields
+func (s *HTTPSigner) Sign(r *http.Request) error {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	label := s.Label
+	if label == "" {
+		label = "sig1"
+	}
+	components := s.Components
+	if components == nil {
+		components = []string{"@method", "@authority", "@path"}
+		if r.Header.Get("Content-Digest") != "" {
+			components = append(components, "content-digest")
+		}
+	}
+	if priv, err := KeyIsPrivateErr(s.Key.Key); err != nil {
+		return err
+	} else if !priv {
+		return fmt.Errorf("signing needs a private key, not %T", s.Key.Key)
+	}
+	alg, err := httpSigAlgorithm(s.Algorithm, s.Key)
+	if err != nil {
+		return err
+	}
+
+	input := sfv.InnerList{}
+	for _, c := range components {
+		it, err := parseHTTPSigComponentID(c)
+		if err != nil {
+			return err
+		}
+		input.Items = append(input.Items, it)
+	}
+	created := now()
+	input.Params = append(input.Params, sfv.Param{Key: "created", Value: created.Unix()})
+	if s.Expiry != 0 {
+		input.Params = append(input.Params, sfv.Param{Key: "expires", Value: created.Add(s.Expiry).Unix()})
+	}
+	if s.Key.KeyID != "" {
+		input.Params = append(input.Params, sfv.Param{Key: "keyid", Value: s.Key.KeyID})
+	}
+	input.Params = append(input.Params, sfv.Param{Key: "alg", Value: alg})
+	if s.Nonce != "" {
+		input.Params = append(input.Params, sfv.Param{Key: "nonce", Value: s.Nonce})
+	}
+	if s.Tag != "" {
+		input.Params = append(input.Params, sfv.Param{Key: "tag", Value: s.Tag})
+	}
+
+	base, err := httpSignatureBase(r, input)
+	if err != nil {
+		return err
+	}
+	sig, err := httpSigSign(alg, s.Key.Key, base)
+	if err != nil {
+		return err
+	}
+
+	inputField, err := sfv.SerializeDictionary(sfv.Dictionary{{Key: label, Value: input}})
+	if err != nil {
+		return err
+	}
+	sigField, err := sfv.SerializeDictionary(sfv.Dictionary{{Key: label, Value: sfv.Item{Value: sig}}})
+	if err != nil {
+		return err
+	}
+	r.Header.Add("Signature-Input", inputField)
+	r.Header.Add("Signature", sigField)
+	return nil
+}
+
+// ===
+// Signature base (RFC 9421 §2.5)
+// ===
+
+func httpSignatureBase(r *http.Request, input sfv.InnerList) ([]byte, error) {
+	var b strings.Builder
+	seen := map[string]bool{}
+	for _, it := range input.Items {
+		id, err := sfv.SerializeItem(it)
+		if err != nil {
+			return nil, err
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("component %s is covered twice", id)
+		}
+		seen[id] = true
+
+		value, err := httpSigComponentValue(r, it)
+		if err != nil {
+			return nil, fmt.Errorf("component %s: %w", id, err)
+		}
+		b.WriteString(id)
+		b.WriteString(": ")
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+	params, err := sfv.SerializeInnerList(input)
+	if err != nil {
+		return nil, err
+	}
+	b.WriteString(`"@signature-params": `)
+	b.WriteString(params)
+	return []byte(b.String()), nil
+}
+
+func httpSigComponentValue(r *http.Request, it sfv.Item) (string, error) {
+	name := it.Value.(string) // checked by httpSigComponentID
+	for _, p := range it.Params {
+		if p.Key != "name" || name != "@query-param" {
+			return "", fmt.Errorf("parameter %s isn't supported", p.Key)
+		}
+	}
+
+	u := httpSigTargetURL(r)
+	switch name {
+	case "@method":
+		if r.Method == "" {
+			return http.MethodGet, nil
+		}
+		return r.Method, nil
+	case "@target-uri":
+		return u.Scheme + "://" + u.Host + r.URL.RequestURI(), nil
+	case "@authority":
+		return u.Host, nil
+	case "@scheme":
+		return u.Scheme, nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@path":
+		if p := r.URL.EscapedPath(); p != "" {
+			return p, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	case "@query-param":
+		n, ok := it.Params.Get("name")
+		if !ok {
+			return "", fmt.Errorf("name parameter is required")
+		}
+		ns, ok := n.(string)
+		if !ok {
+			return "", fmt.Errorf("name parameter must be a string")
+		}
+		key, err := url.QueryUnescape(ns)
+		if err != nil {
+			return "", err
+		}
+		values := r.URL.Query()[key]
+		if len(values) != 1 {
+			return "", fmt.Errorf("query parameter %s must appear once, not %d times", key, len(values))
+		}
+		return strings.ReplaceAll(url.QueryEscape(values[0]), "+", "%20"), nil
+	case "@status":
+		return "", fmt.Errorf("only responses have a status")
+	case "@signature-params":
+		return "", fmt.Errorf("can't be covered")
+	}
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("unknown derived component")
+	}
+
+	var values []string
+	if name == "host" && r.Header.Get("Host") == "" {
+		values = []string{u.Host} // Go moves Host out of the header map
+	} else {
+		values = slices.Clone(r.Header.Values(name)) // Values is the header map's own slice, which we trim below
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("field isn't present")
+	}
+	for i := range values {
+		values[i] = strings.Trim(values[i], " \t")
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// httpSigTargetURL is the scheme and authority of the request, normalised.
+// For incoming requests, these are reconstructed from the Host and whether it came over TLS, which won't be right behind a proxy that changes either.
+func httpSigTargetURL(r *http.Request) *url.URL {
+	scheme := strings.ToLower(r.URL.Scheme)
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	host = strings.ToLower(host)
+	if scheme == "https" {
+		host = strings.TrimSuffix(host, ":443")
+	} else if scheme == "http" {
+		host = strings.TrimSuffix(host, ":80")
+	}
+	return &url.URL{Scheme: scheme, Host: host}
+}
+
+// httpSigComponentID renders a component identifier for people, eg `@query-param;name="id"`
+func httpSigComponentID(it sfv.Item) (string, error) {
+	name, ok := it.Value.(string)
+	if !ok || name == "" || strings.ToLower(name) != name {
+		return "", fmt.Errorf("component identifiers must be lowercase strings")
+	}
+	quoted, err := sfv.SerializeItem(it)
+	if err != nil {
+		return "", err
+	}
+	return name + quoted[len(name)+2:], nil
+}
+
+func parseHTTPSigComponentID(s string) (sfv.Item, error) {
+	name, params, _ := strings.Cut(s, ";")
+	if params != "" {
+		params = ";" + params
+	}
+	it, err := sfv.ParseItem(`"` + name + `"` + params)
+	if err != nil {
+		return sfv.Item{}, fmt.Errorf("component %s: %w", s, err)
+	}
+	if _, err := httpSigComponentID(it); err != nil {
+		return sfv.Item{}, fmt.Errorf("component %s: %w", s, err)
+	}
+	return it, nil
+}
+
+// ===
+// Algorithms (RFC 9421 §3.3)
+// ===
+
+// httpSigAlgorithm settles the algorithm from the alg parameter (if any), the JWK's alg (if any), and the key type, which must all agree.
+func httpSigAlgorithm(alg string, k *JWK) (string, error) {
+	if alg != "" {
+		jws, ok := httpSigAlgorithms[alg]
+		if !ok {
+			return "", fmt.Errorf("algorithm %s isn't supported", alg)
+		}
+		if k.Algorithm != "" && k.Algorithm != jws {
+			return "", fmt.Errorf("algorithm %s can't be used with a key for %s", alg, k.Algorithm)
+		}
+	} else if k.Algorithm != "" {
+		for name, jws := range httpSigAlgorithms {
+			if jws == k.Algorithm {
+				alg = name
+			}
+		}
+		if alg == "" {
+			return "", fmt.Errorf("key's alg %s has no HTTP message signature equivalent", k.Algorithm)
+		}
+	} else {
+		switch k.Curve() {
+		case "P-256":
+			alg = "ecdsa-p256-sha256"
+		case "P-384":
+			alg = "ecdsa-p384-sha384"
+		}
+		switch k.Key.(type) {
+		case ed25519.PublicKey, ed25519.PrivateKey:
+			alg = "ed25519"
+		}
+		if alg == "" {
+			return "", fmt.Errorf("no algorithm given, and it can't be told from a %s key", httpSigKeyType(k.Key))
+		}
+	}
+
+	pub, err := KeyPublicPartErr(k.Key)
+	if err != nil {
+		return "", err
+	}
+	if want := httpSigKeyType(pub); !strings.HasPrefix(alg, want) {
+		return "", fmt.Errorf("algorithm %s can't be used with a %s key", alg, want)
+	}
+	return alg, nil
+}
+
+// httpSigKeyType names the key's type as the algorithm names start
+func httpSigKeyType(k any) string {
+	switch typedKey := k.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey, *RSAPSSPublicKey, *RSAPSSPrivateKey:
+		return "rsa"
+	case *ecdsa.PublicKey:
+		return "ecdsa-" + strings.ToLower(strings.ReplaceAll(typedKey.Curve.Params().Name, "-", ""))
+	case *ecdsa.PrivateKey:
+		return httpSigKeyType(&typedKey.PublicKey)
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return "ed25519"
+	default:
+		return fmt.Sprintf("%T", k)
+	}
+}
+
+func httpSigDigest(alg string, base []byte) (crypto.Hash, []byte) {
+	switch {
+	case strings.HasSuffix(alg, "sha512"):
+		sum := sha512.Sum512(base)
+		return crypto.SHA512, sum[:]
+	case strings.HasSuffix(alg, "sha384"):
+		sum := sha512.Sum384(base)
+		return crypto.SHA384, sum[:]
+	default:
+		sum := sha256.Sum256(base)
+		return crypto.SHA256, sum[:]
+	}
+}
+
+func httpSigSign(alg string, key any, base []byte) ([]byte, error) {
+	hash, digest := httpSigDigest(alg, base)
+	switch typedKey := key.(type) {
+	case *RSAPSSPrivateKey:
+		return httpSigSign(alg, typedKey.PrivateKey, base)
+	case *rsa.PrivateKey:
+		if alg == "rsa-pss-sha512" {
+			return rsa.SignPSS(rand.Reader, typedKey, hash, digest, &rsa.PSSOptions{SaltLength: 64, Hash: hash})
+		}
+		return rsa.SignPKCS1v15(rand.Reader, typedKey, hash, digest)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, typedKey, digest)
+		if err != nil {
+			return nil, err
+		}
+		// r || s, as for JWS (RFC 9421 §3.3.4)
+		size := (typedKey.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(typedKey, base), nil
+	default:
+		return nil, &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("%T", key), Format: "HTTP message signatures"}
+	}
+}
+
+func httpSigVerify(alg string, key any, base, sig []byte) error {
+	key, err := KeyPublicPartErr(key)
+	if err != nil {
+		return err
+	}
+	hash, digest := httpSigDigest(alg, base)
+	switch typedKey := key.(type) {
+	case *RSAPSSPublicKey:
+		return httpSigVerify(alg, typedKey.PublicKey, base, sig)
+	case *rsa.PublicKey:
+		if alg == "rsa-pss-sha512" {
+			err = rsa.VerifyPSS(typedKey, hash, digest, sig, &rsa.PSSOptions{SaltLength: 64, Hash: hash})
+		} else {
+			err = rsa.VerifyPKCS1v15(typedKey, hash, digest, sig)
+		}
+		if err != nil {
+			return fmt.Errorf("signature doesn't verify")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (typedKey.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("signature must be %d bytes, not %d", 2*size, len(sig))
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(typedKey, digest, r, s) {
+			return fmt.Errorf("signature doesn't verify")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(typedKey, base, sig) {
+			return fmt.Errorf("signature doesn't verify")
+		}
+		return nil
+	default:
+		return &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("%T", key), Format: "HTTP message signatures"}
+	}
+}
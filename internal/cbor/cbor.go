@@ -0,0 +1,292 @@
+// Package cbor is a minimal RFC 8949 CBOR codec, covering just the data model needed by COSE_Key.
+// Supported types are integers, byte strings, text strings, arrays, maps, booleans and null.
+// Encoding is always "core deterministic" (RFC 8949 §4.2.1): shortest-form arguments, definite lengths, and map keys sorted by their encoded bytes.
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorSimple = 7
+)
+
+const (
+	simpleFalse = 20
+	simpleTrue  = 21
+	simpleNull  = 22
+)
+
+// Arbitrary, but stops a hostile length prefix making us allocate the world.
+const maxNesting = 32
+
+// Map is a CBOR map. Keys are int64 or string; other key types aren't needed by COSE.
+type Map map[any]any
+
+// Marshal encodes v, which must be built from int, int64, uint64, []byte, string, []any, Map, bool and nil.
+func Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, v any) error {
+	switch t := v.(type) {
+	case nil:
+		buf.WriteByte(majorSimple<<5 | simpleNull)
+	case bool:
+		if t {
+			buf.WriteByte(majorSimple<<5 | simpleTrue)
+		} else {
+			buf.WriteByte(majorSimple<<5 | simpleFalse)
+		}
+	case int:
+		encodeInt(buf, int64(t))
+	case int64:
+		encodeInt(buf, t)
+	case uint64:
+		writeHead(buf, majorUint, t)
+	case []byte:
+		writeHead(buf, majorBytes, uint64(len(t)))
+		buf.Write(t)
+	case string:
+		writeHead(buf, majorText, uint64(len(t)))
+		buf.WriteString(t)
+	case []any:
+		writeHead(buf, majorArray, uint64(len(t)))
+		for _, e := range t {
+			if err := encode(buf, e); err != nil {
+				return err
+			}
+		}
+	case Map:
+		type entry struct{ k, v []byte }
+		entries := make([]entry, 0, len(t))
+		for k, v := range t {
+			switch k.(type) {
+			case int, int64, string:
+			default:
+				return fmt.Errorf("unsupported map key type %T", k)
+			}
+			kb, err := Marshal(k)
+			if err != nil {
+				return err
+			}
+			vb, err := Marshal(v)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{kb, vb})
+		}
+		// Deterministic encoding: bytewise lexicographic order of the encoded keys
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].k, entries[j].k) < 0 })
+		// Distinct Go keys can encode the same, eg int(1) and int64(1)
+		for i := 1; i < len(entries); i++ {
+			if bytes.Equal(entries[i-1].k, entries[i].k) {
+				return fmt.Errorf("duplicate map key %x", entries[i].k)
+			}
+		}
+		writeHead(buf, majorMap, uint64(len(entries)))
+		for _, e := range entries {
+			buf.Write(e.k)
+			buf.Write(e.v)
+		}
+	default:
+		return fmt.Errorf("unsupported type %T", v)
+	}
+	return nil
+}
+
+func encodeInt(buf *bytes.Buffer, i int64) {
+	if i >= 0 {
+		writeHead(buf, majorUint, uint64(i))
+	} else {
+		writeHead(buf, majorNegInt, uint64(-1-i))
+	}
+}
+
+func writeHead(buf *bytes.Buffer, major byte, arg uint64) {
+	m := major << 5
+	switch {
+	case arg < 24:
+		buf.WriteByte(m | byte(arg))
+	case arg <= math.MaxUint8:
+		buf.WriteByte(m | 24)
+		buf.WriteByte(byte(arg))
+	case arg <= math.MaxUint16:
+		buf.WriteByte(m | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(arg)))
+	case arg <= math.MaxUint32:
+		buf.WriteByte(m | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(arg)))
+	default:
+		buf.WriteByte(m | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, arg))
+	}
+}
+
+// Unmarshal decodes exactly one data item from data.
+// Integers decode to int64, maps to Map, arrays to []any.
+func Unmarshal(data []byte) (any, error) {
+	d := &decoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(d.data) {
+		return nil, fmt.Errorf("%d trailing bytes after CBOR data item", len(d.data)-d.off)
+	}
+	return v, nil
+}
+
+var errTruncated = errors.New("unexpected end of CBOR data")
+
+type decoder struct {
+	data []byte
+	off  int
+}
+
+func (d *decoder) readHead() (byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, errTruncated
+	}
+	ib := d.data[d.off]
+	d.off++
+	major, info := ib>>5, ib&0x1f
+
+	var n int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	default:
+		// 28-30 are reserved, 31 is indefinite-length, which we don't support
+		return 0, 0, fmt.Errorf("unsupported CBOR additional information %d", info)
+	}
+	if len(d.data)-d.off < n {
+		return 0, 0, errTruncated
+	}
+	var arg uint64
+	for _, b := range d.data[d.off : d.off+n] {
+		arg = arg<<8 | uint64(b)
+	}
+	d.off += n
+	return major, arg, nil
+}
+
+func (d *decoder) readN(n uint64) ([]byte, error) {
+	if uint64(len(d.data)-d.off) < n {
+		return nil, errTruncated
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+func (d *decoder) decode(depth int) (any, error) {
+	if depth > maxNesting {
+		return nil, fmt.Errorf("CBOR nesting deeper than %d", maxNesting)
+	}
+
+	major, arg, err := d.readHead()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case majorUint:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("CBOR integer %d overflows int64", arg)
+		}
+		return int64(arg), nil
+	case majorNegInt:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("CBOR negative integer -1-%d overflows int64", arg)
+		}
+		return -1 - int64(arg), nil
+	case majorBytes:
+		b, err := d.readN(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case majorText:
+		b, err := d.readN(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case majorArray:
+		// Every item is at least one byte, which bounds the allocation by the input size
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, errTruncated
+		}
+		arr := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case majorMap:
+		if arg > uint64(len(d.data)-d.off) {
+			return nil, errTruncated
+		}
+		m := Map{}
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("unsupported CBOR map key type %T", k)
+			}
+			if _, ok := m[k]; ok {
+				return nil, fmt.Errorf("duplicate CBOR map key %v", k)
+			}
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case majorSimple:
+		switch arg {
+		case simpleFalse:
+			return false, nil
+		case simpleTrue:
+			return true, nil
+		case simpleNull:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unsupported CBOR simple value or float %d", arg)
+	default:
+		// Major type 6: tags
+		return nil, fmt.Errorf("unsupported CBOR major type %d", major)
+	}
+}
@@ -0,0 +1,128 @@
+package cbor
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendixA(t *testing.T) {
+	// RFC 8949 Appendix A, those in the data model we support. Values are as they decode.
+	cases := []struct {
+		v   any
+		hex string
+	}{
+		{int64(0), "00"},
+		{int64(1), "01"},
+		{int64(10), "0a"},
+		{int64(23), "17"},
+		{int64(24), "1818"},
+		{int64(25), "1819"},
+		{int64(100), "1864"},
+		{int64(1000), "1903e8"},
+		{int64(1000000), "1a000f4240"},
+		{int64(1000000000000), "1b000000e8d4a51000"},
+		{int64(-1), "20"},
+		{int64(-10), "29"},
+		{int64(-100), "3863"},
+		{int64(-1000), "3903e7"},
+		{false, "f4"},
+		{true, "f5"},
+		{nil, "f6"},
+		{[]byte{}, "40"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{"", "60"},
+		{"a", "6161"},
+		{"IETF", "6449455446"},
+		{"\"\\", "62225c"},
+		{"ü", "62c3bc"},
+		{"水", "63e6b0b4"},
+		{"\U00010151", "64f0908591"},
+		{[]any{}, "80"},
+		{[]any{int64(1), int64(2), int64(3)}, "83010203"},
+		{[]any{int64(1), []any{int64(2), int64(3)}, []any{int64(4), int64(5)}}, "8301820203820405"},
+		{Map{}, "a0"},
+		{Map{int64(1): int64(2), int64(3): int64(4)}, "a201020304"},
+		{Map{"a": int64(1), "b": []any{int64(2), int64(3)}}, "a26161016162820203"},
+		{[]any{"a", Map{"b": "c"}}, "826161a161626163"},
+		{Map{"a": "A", "b": "B", "c": "C", "d": "D", "e": "E"}, "a56161614161626142616361436164614461656145"},
+	}
+	long := []any{}
+	for i := int64(1); i <= 25; i++ {
+		long = append(long, i)
+	}
+	cases = append(cases, struct {
+		v   any
+		hex string
+	}{long, "98190102030405060708090a0b0c0d0e0f101112131415161718181819"})
+
+	for _, cse := range cases {
+		bs, err := Marshal(cse.v)
+		require.NoError(t, err, cse.hex)
+		require.Equal(t, cse.hex, hex.EncodeToString(bs))
+
+		v, err := Unmarshal(bs)
+		require.NoError(t, err, cse.hex)
+		require.Equal(t, cse.v, v, cse.hex)
+	}
+
+	// Only encodable; it doesn't fit in the int64 we decode to
+	bs, err := Marshal(uint64(18446744073709551615))
+	require.NoError(t, err)
+	require.Equal(t, "1bffffffffffffffff", hex.EncodeToString(bs))
+	_, err = Unmarshal(bs)
+	require.ErrorContains(t, err, "overflows int64")
+}
+
+func TestMarshalDeterministic(t *testing.T) {
+	// Keys sorted by their encodings, whatever their Go types
+	bs, err := Marshal(Map{"a": 1, -1: 2, 10: 3, int64(100): 4})
+	require.NoError(t, err)
+	require.Equal(t, "a40a0318640420026161"+"01", hex.EncodeToString(bs))
+
+	_, err = Marshal(Map{1: "x", int64(1): "y"})
+	require.ErrorContains(t, err, "duplicate map key")
+	_, err = Marshal(Map{1.5: "x"})
+	require.ErrorContains(t, err, "unsupported map key type")
+	_, err = Marshal(1.5)
+	require.ErrorContains(t, err, "unsupported type")
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	cases := []struct {
+		hex    string
+		expect string
+	}{
+		// RFC 8949 Appendix A's indefinite-length items
+		{"5f42010243030405ff", "additional information 31"},
+		{"7f657374726561646d696e67ff", "additional information 31"},
+		{"9fff", "additional information 31"},
+		{"9f018202039f0405ffff", "additional information 31"},
+		{"bf61610161629f0203ffff", "additional information 31"},
+		// Floats and tags
+		{"f90000", "float"},
+		{"fb3ff199999999999a", "float"},
+		{"c074323031332d30332d32315432303a30343a30305a", "major type 6"},
+		// Duplicate keys
+		{"a201020103", "duplicate CBOR map key 1"},
+		{"a2616101616102", "duplicate CBOR map key a"},
+		{"a1f401", "unsupported CBOR map key type"},
+		// Malformed
+		{"", "unexpected end"},
+		{"1903", "unexpected end"},
+		{"4401", "unexpected end"},
+		{"83", "unexpected end"},
+		{"9bffffffffffffffff", "unexpected end"},
+		{"0000", "1 trailing bytes"},
+		{"1c", "additional information 28"},
+		{strings.Repeat("81", 40) + "00", "nesting"},
+	}
+	for _, cse := range cases {
+		bs, err := hex.DecodeString(cse.hex)
+		require.NoError(t, err)
+		_, err = Unmarshal(bs)
+		require.ErrorContains(t, err, cse.expect, cse.hex)
+	}
+}
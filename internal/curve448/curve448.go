@@ -0,0 +1,194 @@
+// Package curve448 implements just enough of Ed448 (RFC 8032) and X448 (RFC 7748) to derive public keys from private ones.
+// It doesn't sign, verify, or do key agreement.
+//
+// The arithmetic is math/big, and NOT constant-time, which is tolerable for the one-off derivation of a public key, but nothing more.
+package curve448
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	Ed448KeySize = 57 // Both seeds and encoded public keys
+	X448KeySize  = 56 // Both scalars and u-coordinates
+)
+
+var (
+	// p = 2^448 - 2^224 - 1, shared by both curves
+	p = func() *big.Int {
+		p := new(big.Int).Lsh(big.NewInt(1), 448)
+		p.Sub(p, new(big.Int).Lsh(big.NewInt(1), 224))
+		return p.Sub(p, big.NewInt(1))
+	}()
+
+	// Edwards448: x² + y² = 1 + d·x²·y²
+	edD = new(big.Int).Sub(p, big.NewInt(39081))
+	// Base point, RFC 8032 §5.2
+	edBx, _ = new(big.Int).SetString("224580040295924300187604334099896036246789641632564134246125461686950415467406032909029192869357953282578032075146446173674602635247710", 10)
+	edBy, _ = new(big.Int).SetString("298819210078481492676017930443930673437544040154080242095928241372331506189835876003536878655418784733982303233503462500531545062832660", 10)
+
+	// Curve448: (A - 2) / 4
+	a24 = big.NewInt(39081)
+)
+
+// ===
+// Ed448
+// ===
+
+// Ed448PublicKey derives the public key corresponding to a private key seed, per RFC 8032 §5.2.5
+func Ed448PublicKey(seed []byte) ([]byte, error) {
+	if len(seed) != Ed448KeySize {
+		return nil, fmt.Errorf("Ed448 private key must be %d bytes, not %d", Ed448KeySize, len(seed))
+	}
+	h := shake256(seed, 2*Ed448KeySize)
+	s := h[:Ed448KeySize]
+	s[0] &= 0xFC
+	s[55] |= 0x80
+	s[56] = 0
+
+	x, y := edScalarBaseMult(leToInt(s))
+	out := intToLE(y, Ed448KeySize)
+	out[Ed448KeySize-1] |= byte(x.Bit(0)) << 7
+	return out, nil
+}
+
+// Projective coordinates: (X:Y:Z) is the affine point (X/Z, Y/Z)
+type edPoint struct {
+	x, y, z *big.Int
+}
+
+func edScalarBaseMult(k *big.Int) (*big.Int, *big.Int) {
+	base := &edPoint{edBx, edBy, big.NewInt(1)}
+	acc := &edPoint{big.NewInt(0), big.NewInt(1), big.NewInt(1)} // neutral element
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		acc = edDouble(acc)
+		if k.Bit(i) == 1 {
+			acc = edAdd(acc, base)
+		}
+	}
+	zInv := new(big.Int).ModInverse(acc.z, p)
+	return mul(acc.x, zInv), mul(acc.y, zInv)
+}
+
+// RFC 8032 §5.2.4
+func edAdd(p1, p2 *edPoint) *edPoint {
+	a := mul(p1.z, p2.z)
+	b := mul(a, a)
+	c := mul(p1.x, p2.x)
+	d := mul(p1.y, p2.y)
+	e := mul(mul(edD, c), d)
+	f := sub(b, e)
+	g := add(b, e)
+	h := mul(add(p1.x, p1.y), add(p2.x, p2.y))
+	return &edPoint{
+		x: mul(mul(a, f), sub(sub(h, c), d)),
+		y: mul(mul(a, g), sub(d, c)),
+		z: mul(f, g),
+	}
+}
+
+// RFC 8032 §5.2.4
+func edDouble(pt *edPoint) *edPoint {
+	xy := add(pt.x, pt.y)
+	b := mul(xy, xy)
+	c := mul(pt.x, pt.x)
+	d := mul(pt.y, pt.y)
+	e := add(c, d)
+	h := mul(pt.z, pt.z)
+	j := sub(e, add(h, h))
+	return &edPoint{
+		x: mul(sub(b, e), j),
+		y: mul(e, sub(c, d)),
+		z: mul(e, j),
+	}
+}
+
+// edIsOnCurve reports whether the affine point is on Edwards448
+func edIsOnCurve(x, y *big.Int) bool {
+	x2, y2 := mul(x, x), mul(y, y)
+	lhs := add(x2, y2)
+	rhs := add(big.NewInt(1), mul(edD, mul(x2, y2)))
+	return lhs.Cmp(rhs) == 0
+}
+
+// ===
+// X448
+// ===
+
+// X448 computes the X448 function of RFC 7748 §5, ie scalar * u
+func X448(scalar, u []byte) ([]byte, error) {
+	if len(scalar) != X448KeySize || len(u) != X448KeySize {
+		return nil, fmt.Errorf("X448 inputs must be %d bytes", X448KeySize)
+	}
+	k := make([]byte, X448KeySize)
+	copy(k, scalar)
+	k[0] &= 252
+	k[55] |= 128
+
+	return intToLE(montgomeryLadder(leToInt(k), new(big.Int).Mod(leToInt(u), p)), X448KeySize), nil
+}
+
+// X448PublicKey derives the public key corresponding to a private key scalar, ie scalar * 5
+func X448PublicKey(scalar []byte) ([]byte, error) {
+	base := make([]byte, X448KeySize)
+	base[0] = 5
+	return X448(scalar, base)
+}
+
+func montgomeryLadder(k, u *big.Int) *big.Int {
+	x1 := u
+	x2, z2 := big.NewInt(1), big.NewInt(0)
+	x3, z3 := new(big.Int).Set(u), big.NewInt(1)
+	swap := uint(0)
+	for t := 447; t >= 0; t-- {
+		kt := k.Bit(t)
+		if swap^kt == 1 {
+			x2, x3 = x3, x2
+			z2, z3 = z3, z2
+		}
+		swap = kt
+
+		a := add(x2, z2)
+		aa := mul(a, a)
+		b := sub(x2, z2)
+		bb := mul(b, b)
+		e := sub(aa, bb)
+		c := add(x3, z3)
+		d := sub(x3, z3)
+		da := mul(d, a)
+		cb := mul(c, b)
+		x3 = mul(add(da, cb), add(da, cb))
+		z3 = mul(x1, mul(sub(da, cb), sub(da, cb)))
+		x2 = mul(aa, bb)
+		z2 = mul(e, add(aa, mul(a24, e)))
+	}
+	if swap == 1 {
+		x2, z2 = x3, z3
+	}
+	return mul(x2, new(big.Int).Exp(z2, new(big.Int).Sub(p, big.NewInt(2)), p))
+}
+
+// ===
+// Field and encoding helpers
+// ===
+
+func add(a, b *big.Int) *big.Int { r := new(big.Int).Add(a, b); return r.Mod(r, p) }
+func sub(a, b *big.Int) *big.Int { r := new(big.Int).Sub(a, b); return r.Mod(r, p) }
+func mul(a, b *big.Int) *big.Int { r := new(big.Int).Mul(a, b); return r.Mod(r, p) }
+
+func leToInt(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+func intToLE(i *big.Int, size int) []byte {
+	out := i.FillBytes(make([]byte, size))
+	for l, r := 0, len(out)-1; l < r; l, r = l+1, r-1 {
+		out[l], out[r] = out[r], out[l]
+	}
+	return out
+}
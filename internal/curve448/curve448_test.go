@@ -0,0 +1,61 @@
+package curve448
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestShake256(t *testing.T) {
+	require.Equal(t, "46b9dd2b0ba88d13233b3feb743eeb243fcd52ea62b81b82b50c27646ed5762f", hex.EncodeToString(shake256(nil, 32)))
+	// Spans several blocks, both in and out
+	long := shake256(make([]byte, 300), 300)
+	require.Len(t, long, 300)
+	require.Equal(t, long[:32], shake256(make([]byte, 300), 32))
+}
+
+func TestEd448BasePoint(t *testing.T) {
+	require.True(t, edIsOnCurve(edBx, edBy))
+}
+
+// RFC 8032 §7.4
+func TestEd448PublicKey(t *testing.T) {
+	cases := []struct{ seed, public string }{
+		{
+			"6c82a562cb808d10d632be89c8513ebf6c929f34ddfa8c9f63c9960ef6e348a3528c8a3fcc2f044e39a3fc5b94492f8f032e7549a20098f95b",
+			"5fd7449b59b461fd2ce787ec616ad46a1da1342485a70e1f8a0ea75d80e96778edf124769b46c7061bd6783df1e50f6cd1fa1abeafe8256180",
+		},
+	}
+	for _, cse := range cases {
+		pub, err := Ed448PublicKey(unhex(t, cse.seed))
+		require.NoError(t, err)
+		require.Equal(t, cse.public, hex.EncodeToString(pub))
+	}
+}
+
+// RFC 7748 §6.2
+func TestX448(t *testing.T) {
+	alicePriv := unhex(t, "9a8f4925d1519f5775cf46b04b5800d4ee9ee8bae8bc5565d498c28dd9c9baf574a9419744897391006382a6f127ab1d9ac2d8c0a598726b")
+	alicePub := "9b08f7cc31b7e3e67d22d5aea121074a273bd2b83de09c63faa73d2c22c5d9bbc836647241d953d40c5b12da88120d53177f80e532c41fa0"
+	bobPriv := unhex(t, "1c306a7ac2a0e2e0990b294470cba339e6453772b075811d8fad0d1d6927c120bb5ee8972b0d3e21374c9c921b09d1b0366f10b65173992d")
+	bobPub := "3eb7a829b0cd20f5bcfc0b599b6feccf6da4627107bdb0d4f345b43027d8b972fc3e34fb4232a13ca706dcb57aec3dae07bdc1c67bf33609"
+	shared := "07fff4181ac6cc95ec1c16a94a0f74d12da232ce40a77552281d282bb60c0b56fd2464c335543936521c24403085d59a449a5037514a879d"
+
+	pub, err := X448PublicKey(alicePriv)
+	require.NoError(t, err)
+	require.Equal(t, alicePub, hex.EncodeToString(pub))
+	pub, err = X448PublicKey(bobPriv)
+	require.NoError(t, err)
+	require.Equal(t, bobPub, hex.EncodeToString(pub))
+
+	k, err := X448(alicePriv, unhex(t, bobPub))
+	require.NoError(t, err)
+	require.Equal(t, shared, hex.EncodeToString(k))
+}
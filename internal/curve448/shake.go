@@ -0,0 +1,94 @@
+package curve448
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// A minimal SHAKE256 (FIPS 202), which Ed448 needs to expand its private keys.
+// crypto/sha3 doesn't exist in the Go versions we support, and this saves depending on x/crypto for one function.
+
+var keccakRoundConstants = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808A, 0x8000000080008000,
+	0x000000000000808B, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008A, 0x0000000000000088, 0x0000000080008009, 0x000000008000000A,
+	0x000000008000808B, 0x800000000000008B, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800A, 0x800000008000000A,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+// Indexed by x + 5y
+var keccakRotations = [25]int{
+	0, 1, 62, 28, 27,
+	36, 44, 6, 55, 20,
+	3, 10, 43, 25, 39,
+	41, 45, 15, 21, 8,
+	18, 2, 61, 56, 14,
+}
+
+func keccakF1600(a *[25]uint64) {
+	var c [5]uint64
+	var b [25]uint64
+	for round := 0; round < 24; round++ {
+		// θ
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+			for y := 0; y < 25; y += 5 {
+				a[x+y] ^= d
+			}
+		}
+		// ρ and π
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y], keccakRotations[x+5*y])
+			}
+		}
+		// χ
+		for y := 0; y < 25; y += 5 {
+			for x := 0; x < 5; x++ {
+				a[x+y] = b[x+y] ^ (^b[(x+1)%5+y] & b[(x+2)%5+y])
+			}
+		}
+		// ι
+		a[0] ^= keccakRoundConstants[round]
+	}
+}
+
+// shake256 returns outLen bytes of SHAKE256(in)
+func shake256(in []byte, outLen int) []byte {
+	const rate = 136 // (1600 - 2*256) / 8
+
+	var state [25]uint64
+	absorb := func(block []byte) {
+		for i := 0; i < rate/8; i++ {
+			state[i] ^= binary.LittleEndian.Uint64(block[8*i:])
+		}
+		keccakF1600(&state)
+	}
+
+	for len(in) >= rate {
+		absorb(in[:rate])
+		in = in[rate:]
+	}
+	last := make([]byte, rate)
+	copy(last, in)
+	last[len(in)] ^= 0x1F // SHAKE domain separation, and the first bit of pad10*1
+	last[rate-1] ^= 0x80
+	absorb(last)
+
+	out := make([]byte, 0, outLen+rate)
+	block := make([]byte, rate)
+	for {
+		for i := 0; i < rate/8; i++ {
+			binary.LittleEndian.PutUint64(block[8*i:], state[i])
+		}
+		out = append(out, block...)
+		if len(out) >= outLen {
+			return out[:outLen]
+		}
+		keccakF1600(&state)
+	}
+}
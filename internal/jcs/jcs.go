@@ -0,0 +1,175 @@
+// Package jcs implements the RFC 8785 JSON Canonicalization Scheme.
+// The output of Transform is a deterministic function of the input's data model, so it can be hashed and signed reproducibly.
+package jcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Transform re-serialises the JSON text in data in canonical form.
+func Transform(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("trailing data after JSON value")
+	}
+
+	var buf bytes.Buffer
+	if err := write(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func write(buf *bytes.Buffer, v any) error {
+	switch t := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(t))
+	case json.Number:
+		s, err := formatNumber(t)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(s)
+	case string:
+		writeString(buf, t)
+	case []any:
+		buf.WriteByte('[')
+		for i, e := range t {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			if err := write(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		// §3.2.3: members are sorted by their names' UTF-16 code units, not by UTF-8 bytes or runes
+		sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			writeString(buf, k)
+			buf.WriteByte(':')
+			if err := write(buf, t[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected JSON type %T", v)
+	}
+	return nil
+}
+
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+// §3.2.2.2: only ", \ and control characters are escaped, using the short forms where they exist
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// §3.2.2.3: numbers are serialised as ECMAScript's Number.prototype.toString() would
+func formatNumber(n json.Number) (string, error) {
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return "", fmt.Errorf("number %s is not representable as an IEEE 754 double: %w", n, err)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("number %s is not finite", n)
+	}
+	if f == 0 {
+		return "0", nil // Includes -0
+	}
+
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+
+	// Shortest round-tripping digits, which is what ECMAScript mandates too
+	sci := strconv.FormatFloat(f, 'e', -1, 64) // d[.ddd]e±XX
+	mantissa, expStr, _ := strings.Cut(sci, "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	exp, err := strconv.Atoi(expStr)
+	if err != nil {
+		return "", err
+	}
+	k := len(digits)
+	e := exp + 1 // ECMAScript's n: the value is 0.digits × 10^n
+
+	var out string
+	switch {
+	case k <= e && e <= 21:
+		out = digits + strings.Repeat("0", e-k)
+	case 0 < e && e <= 21:
+		out = digits[:e] + "." + digits[e:]
+	case -6 < e && e <= 0:
+		out = "0." + strings.Repeat("0", -e) + digits
+	default:
+		out = digits[:1]
+		if k > 1 {
+			out += "." + digits[1:]
+		}
+		if e-1 >= 0 {
+			out += "e+" + strconv.Itoa(e-1)
+		} else {
+			out += "e" + strconv.Itoa(e-1)
+		}
+	}
+	return sign + out, nil
+}
@@ -0,0 +1,40 @@
+package jcs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransform(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected string
+	}{
+		// RFC 8785 §3.2.4
+		{
+			`{"numbers":[333333333.33333329,1E30,4.50,2e-3,0.000000000000000000000000001],"string":"\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/","literals":[null,true,false]}`,
+			`{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		// RFC 8785 §3.2.3: sorted by UTF-16 code units, so U+1F600 (a surrogate pair) sorts before U+FB33
+		{
+			"{\"\ufb33\":1,\"\U0001f600\":2,\"a\":3}",
+			"{\"a\":3,\"\U0001f600\":2,\"\ufb33\":1}",
+		},
+		// RFC 8785 Appendix B
+		{`[0,-0,5e-324,-5e-324,1.7976931348623157e308,9007199254740992,295147905179352825856,1e23,0.000001,1e-7]`,
+			`[0,0,5e-324,-5e-324,1.7976931348623157e+308,9007199254740992,295147905179352830000,1e+23,0.000001,1e-7]`},
+		{` { "b" : [ ] , "a" : { } } `, `{"a":{},"b":[]}`},
+	}
+
+	for _, cse := range cases {
+		got, err := Transform([]byte(cse.in))
+		require.NoError(t, err)
+		require.Equal(t, cse.expected, string(got))
+	}
+
+	_, err := Transform([]byte(`[1e400]`))
+	require.Error(t, err)
+	_, err = Transform([]byte(`{} {}`))
+	require.Error(t, err)
+}
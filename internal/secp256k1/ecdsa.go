@@ -0,0 +1,62 @@
+package secp256k1
+
+import (
+	"errors"
+	"io"
+)
+
+// Sign makes an ECDSA signature (SEC 1 §4.1.3) of hash, which should be a SHA-256 digest, with the private key d: a 32-byte big-endian scalar in [1, n-1].
+// It returns r and s as 32 bytes each, big-endian, which concatenated are a JWS ES256K signature.
+// The nonce is read from rand. All the arithmetic involving d and the nonce is constant-time.
+func Sign(rand io.Reader, d *[32]byte, hash []byte) (r, s [32]byte, err error) {
+	c := S256().(*curve)
+	fn := c.fn
+
+	dm := fn.fromBytes(d)
+	if dm.isZero() {
+		return r, s, errors.New("secp256k1: private key out of range")
+	}
+	// e is the leftmost 256 bits of the hash, reduced mod n
+	var eb [32]byte
+	if len(hash) > 32 {
+		hash = hash[:32]
+	}
+	copy(eb[32-len(hash):], hash)
+	em := fn.fromBytes(&eb)
+
+	for {
+		// A nonce in [1, n-1]: reduce 512 random bits mod n, so the bias is negligible
+		var buf [64]byte
+		if _, err := io.ReadFull(rand, buf[:]); err != nil {
+			return r, s, err
+		}
+		hi, lo := limbsFromBytes((*[32]byte)(buf[:32])), limbsFromBytes((*[32]byte)(buf[32:]))
+		hi, lo = fn.reduce(hi, 0), fn.reduce(lo, 0)
+		k := fn.mul(&hi, &fn.rr) // hi·2²⁵⁶ mod n, as mul divides by R once
+		k = fn.add(&k, &lo)
+		km := fn.mul(&k, &fn.rr)
+		if km.isZero() {
+			continue
+		}
+
+		// r = x(kG) mod n
+		kb := fn.bytes(&km)
+		x, _ := c.toAffine(c.scalarMult(&c.g, kb[:]))
+		x.FillBytes(r[:])
+		rm := fn.fromBytes(&r)
+		if rm.isZero() {
+			continue
+		}
+		r = fn.bytes(&rm)
+
+		// s = k⁻¹(e + rd) mod n
+		sm := fn.mul(&rm, &dm)
+		sm = fn.add(&sm, &em)
+		kInv := fn.inv(&km)
+		sm = fn.mul(&kInv, &sm)
+		if sm.isZero() {
+			continue
+		}
+		return r, fn.bytes(&sm), nil
+	}
+}
@@ -0,0 +1,175 @@
+package secp256k1
+
+import (
+	"encoding/binary"
+	"math/big"
+	"math/bits"
+)
+
+// ===
+// Constant-time arithmetic modulo a 256-bit odd number: the field prime p, or the group order n.
+// Elements are 4 little-endian 64-bit limbs, in Montgomery form (aR mod m, R = 2²⁵⁶), and always fully reduced.
+// Nothing here branches on, or indexes memory by, an element's value.
+// ===
+
+type elem [4]uint64
+
+type modulus struct {
+	m    elem
+	mInv uint64 // -m⁻¹ mod 2⁶⁴
+	rr   elem   // R² mod m, for getting into Montgomery form
+	one  elem   // R mod m, ie 1 in Montgomery form
+	exp  []byte // m - 2, big-endian, for inverting by Fermat's little theorem. Public, so it's fine to branch on its bits
+}
+
+func newModulus(m *big.Int) *modulus {
+	md := &modulus{m: limbs(m)}
+	inv := uint64(1) // Newton's method; each step doubles the number of correct low bits
+	for i := 0; i < 6; i++ {
+		inv *= 2 - md.m[0]*inv
+	}
+	md.mInv = -inv
+	r := new(big.Int).Lsh(big.NewInt(1), 256)
+	md.one = limbs(new(big.Int).Mod(r, m))
+	md.rr = limbs(new(big.Int).Mod(new(big.Int).Mul(r, r), m))
+	md.exp = new(big.Int).Sub(m, big.NewInt(2)).Bytes()
+	return md
+}
+
+func limbs(x *big.Int) elem {
+	var buf [32]byte
+	x.FillBytes(buf[:])
+	return limbsFromBytes(&buf)
+}
+
+func limbsFromBytes(b *[32]byte) elem {
+	return elem{
+		binary.BigEndian.Uint64(b[24:]),
+		binary.BigEndian.Uint64(b[16:24]),
+		binary.BigEndian.Uint64(b[8:16]),
+		binary.BigEndian.Uint64(b[:8]),
+	}
+}
+
+// fromBytes takes a 32-byte big-endian number, reduces it (anything that fits in 256 bits is less than 2m), and puts it in Montgomery form
+func (md *modulus) fromBytes(b *[32]byte) elem {
+	x := md.reduce(limbsFromBytes(b), 0)
+	return md.mul(&x, &md.rr)
+}
+
+// bytes takes an element out of Montgomery form, returning it as 32 big-endian bytes
+func (md *modulus) bytes(a *elem) [32]byte {
+	x := md.mul(a, &elem{1})
+	var b [32]byte
+	binary.BigEndian.PutUint64(b[:8], x[3])
+	binary.BigEndian.PutUint64(b[8:16], x[2])
+	binary.BigEndian.PutUint64(b[16:24], x[1])
+	binary.BigEndian.PutUint64(b[24:], x[0])
+	return b
+}
+
+// reduce returns carry·2²⁵⁶ + x mod m, given that's less than 2m
+func (md *modulus) reduce(x elem, carry uint64) elem {
+	var y elem
+	var borrow uint64
+	y[0], borrow = bits.Sub64(x[0], md.m[0], 0)
+	y[1], borrow = bits.Sub64(x[1], md.m[1], borrow)
+	y[2], borrow = bits.Sub64(x[2], md.m[2], borrow)
+	y[3], borrow = bits.Sub64(x[3], md.m[3], borrow)
+	// Keep x iff subtracting m went negative, and there was no carry to absorb that
+	keepX := -(borrow &^ carry)
+	for i := range y {
+		y[i] = x[i]&keepX | y[i]&^keepX
+	}
+	return y
+}
+
+func (md *modulus) add(a, b *elem) elem {
+	var x elem
+	var carry uint64
+	x[0], carry = bits.Add64(a[0], b[0], 0)
+	x[1], carry = bits.Add64(a[1], b[1], carry)
+	x[2], carry = bits.Add64(a[2], b[2], carry)
+	x[3], carry = bits.Add64(a[3], b[3], carry)
+	return md.reduce(x, carry)
+}
+
+func (md *modulus) sub(a, b *elem) elem {
+	var x elem
+	var borrow uint64
+	x[0], borrow = bits.Sub64(a[0], b[0], 0)
+	x[1], borrow = bits.Sub64(a[1], b[1], borrow)
+	x[2], borrow = bits.Sub64(a[2], b[2], borrow)
+	x[3], borrow = bits.Sub64(a[3], b[3], borrow)
+	// Add m back if that went negative
+	mask := -borrow
+	var carry uint64
+	x[0], carry = bits.Add64(x[0], md.m[0]&mask, 0)
+	x[1], carry = bits.Add64(x[1], md.m[1]&mask, carry)
+	x[2], carry = bits.Add64(x[2], md.m[2]&mask, carry)
+	x[3], _ = bits.Add64(x[3], md.m[3]&mask, carry)
+	return x
+}
+
+// mul returns abR⁻¹ mod m, which for a and b in Montgomery form is their product in Montgomery form.
+// It's the CIOS method: interleave multiplying by each limb of b with knocking a limb off the bottom of the accumulator.
+func (md *modulus) mul(a, b *elem) elem {
+	var t [6]uint64
+	for i := 0; i < 4; i++ {
+		// t += a * b[i]
+		var c, cc uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(a[j], b[i])
+			lo, cc = bits.Add64(lo, t[j], 0)
+			hi += cc
+			lo, cc = bits.Add64(lo, c, 0)
+			hi += cc
+			t[j], c = lo, hi
+		}
+		t[4], cc = bits.Add64(t[4], c, 0)
+		t[5] = cc
+
+		// t = (t + q*m) / 2⁶⁴, with q chosen so the bottom limb is zero
+		q := t[0] * md.mInv
+		hi, lo := bits.Mul64(q, md.m[0])
+		_, cc = bits.Add64(lo, t[0], 0)
+		c = hi + cc
+		for j := 1; j < 4; j++ {
+			hi, lo := bits.Mul64(q, md.m[j])
+			lo, cc = bits.Add64(lo, t[j], 0)
+			hi += cc
+			lo, cc = bits.Add64(lo, c, 0)
+			hi += cc
+			t[j-1], c = lo, hi
+		}
+		t[3], cc = bits.Add64(t[4], c, 0)
+		t[4] = t[5] + cc
+	}
+	return md.reduce(elem{t[0], t[1], t[2], t[3]}, t[4])
+}
+
+// inv returns a⁻¹ (0 if a is 0), as a^(m-2), m being prime
+func (md *modulus) inv(a *elem) elem {
+	x := md.one
+	for _, b := range md.exp {
+		for bit := 7; bit >= 0; bit-- {
+			x = md.mul(&x, &x)
+			if (b>>bit)&1 == 1 {
+				x = md.mul(&x, a)
+			}
+		}
+	}
+	return x
+}
+
+func (a *elem) isZero() bool {
+	return a[0]|a[1]|a[2]|a[3] == 0
+}
+
+// selectElem sets a to b if cond is 1, and leaves it alone if cond is 0
+func selectElem(a, b *elem, cond int) {
+	mask := -uint64(cond)
+	for i := range a {
+		a[i] ^= mask & (a[i] ^ b[i])
+	}
+}
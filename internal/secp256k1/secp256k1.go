@@ -0,0 +1,265 @@
+// Package secp256k1 implements the SEC 2 secp256k1 curve (as used by Bitcoin, and by JOSE's ES256K) as an elliptic.Curve.
+// crypto/elliptic's generic CurveParams methods assume a = -3, whereas secp256k1 has a = 0, so the arithmetic is done here.
+//
+// The point arithmetic is constant-time: 4×64-bit Montgomery field elements, complete projective formulae, and fixed-window scalar multiplication, so ScalarMult and ScalarBaseMult are safe with secret scalars, and Sign with secret keys.
+// Encoding and IsOnCurve, which only ever see public points, use math/big.
+package secp256k1
+
+import (
+	"crypto/elliptic"
+	"crypto/subtle"
+	"math/big"
+	"sync"
+)
+
+// OID is the ASN.1 object identifier of the curve, from SEC 2 (certicom-arc 0 10)
+var OID = []int{1, 3, 132, 0, 10}
+
+type curve struct {
+	params *elliptic.CurveParams
+	fp     *modulus // arithmetic mod p, for coordinates
+	fn     *modulus // arithmetic mod n, for scalars
+	g      point
+	b3     elem // 3b, in Montgomery form mod p, which the point formulae want
+}
+
+var (
+	initOnce sync.Once
+	s256     *curve
+)
+
+func initS256() {
+	params := &elliptic.CurveParams{Name: "secp256k1", BitSize: 256}
+	params.P, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F", 16)
+	params.N, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", 16)
+	params.B = big.NewInt(7)
+	params.Gx, _ = new(big.Int).SetString("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798", 16)
+	params.Gy, _ = new(big.Int).SetString("483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8", 16)
+	s256 = &curve{params: params, fp: newModulus(params.P), fn: newModulus(params.N)}
+	s256.g = s256.fromAffine(params.Gx, params.Gy)
+	s256.b3 = s256.fp.mul(ptr(limbs(big.NewInt(3*7))), &s256.fp.rr)
+}
+
+// S256 returns the secp256k1 curve. Its Params().Name is "secp256k1", which is also the JWK crv name (RFC 8812).
+// Don't use the methods on the returned Params() directly; they do a = -3 arithmetic.
+func S256() elliptic.Curve {
+	initOnce.Do(initS256)
+	return s256
+}
+
+func (c *curve) Params() *elliptic.CurveParams {
+	return c.params
+}
+
+// IsOnCurve reports whether y² = x³ + 7 (mod p)
+func (c *curve) IsOnCurve(x, y *big.Int) bool {
+	p := c.params.P
+	if x.Sign() < 0 || x.Cmp(p) >= 0 || y.Sign() < 0 || y.Cmp(p) >= 0 {
+		return false
+	}
+	y2 := new(big.Int).Mul(y, y)
+	y2.Mod(y2, p)
+	return c.rhs(x).Cmp(y2) == 0
+}
+
+// rhs returns x³ + 7 (mod p)
+func (c *curve) rhs(x *big.Int) *big.Int {
+	x3 := new(big.Int).Mul(x, x)
+	x3.Mul(x3, x)
+	x3.Add(x3, c.params.B)
+	return x3.Mod(x3, c.params.P)
+}
+
+func (c *curve) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	p1, p2 := c.fromAffine(x1, y1), c.fromAffine(x2, y2)
+	return c.toAffine(c.add(&p1, &p2))
+}
+
+func (c *curve) Double(x1, y1 *big.Int) (*big.Int, *big.Int) {
+	p1 := c.fromAffine(x1, y1)
+	return c.toAffine(c.double(&p1))
+}
+
+func (c *curve) ScalarMult(x1, y1 *big.Int, k []byte) (*big.Int, *big.Int) {
+	base := c.fromAffine(x1, y1)
+	return c.toAffine(c.scalarMult(&base, k))
+}
+
+func (c *curve) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return c.toAffine(c.scalarMult(&c.g, k))
+}
+
+// ===
+// Point encoding
+// ===
+
+// Marshal encodes a point in uncompressed SEC 1 form, ie 0x04 || x || y
+func Marshal(x, y *big.Int) []byte {
+	out := make([]byte, 1+2*32)
+	out[0] = 4
+	x.FillBytes(out[1:33])
+	y.FillBytes(out[33:])
+	return out
+}
+
+// Unmarshal decodes a point in uncompressed or compressed SEC 1 form, returning nils if it's malformed or not on the curve
+func Unmarshal(data []byte) (*big.Int, *big.Int) {
+	c := S256().(*curve)
+	p := c.params.P
+	switch {
+	case len(data) == 1+2*32 && data[0] == 4:
+		x := new(big.Int).SetBytes(data[1:33])
+		y := new(big.Int).SetBytes(data[33:])
+		if !c.IsOnCurve(x, y) {
+			return nil, nil
+		}
+		return x, y
+	case len(data) == 1+32 && (data[0] == 2 || data[0] == 3):
+		x := new(big.Int).SetBytes(data[1:])
+		if x.Cmp(p) >= 0 {
+			return nil, nil
+		}
+		// p ≡ 3 (mod 4), so a square root of a is a^((p+1)/4)
+		exp := new(big.Int).Add(p, big.NewInt(1))
+		exp.Rsh(exp, 2)
+		y := new(big.Int).Exp(c.rhs(x), exp, p)
+		if y.Bit(0) != uint(data[0]&1) {
+			y.Sub(p, y)
+		}
+		if !c.IsOnCurve(x, y) { // rhs wasn't a square
+			return nil, nil
+		}
+		return x, y
+	default:
+		return nil, nil
+	}
+}
+
+// ===
+// Projective arithmetic: (X, Y, Z) represents the affine point (X/Z, Y/Z). (0, 1, 0) is the point at infinity.
+// The formulae are complete, ie they're correct for any inputs, including infinity and doubling, so there's no need to branch on the points' values.
+// ===
+
+type point struct {
+	x, y, z elem
+}
+
+func (c *curve) infinity() point {
+	return point{y: c.fp.one}
+}
+
+func (c *curve) fromAffine(x, y *big.Int) point {
+	// (0, 0) is elliptic's convention for infinity
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return c.infinity()
+	}
+	p := c.params.P
+	return point{
+		x: c.fp.mul(ptr(limbs(new(big.Int).Mod(x, p))), &c.fp.rr),
+		y: c.fp.mul(ptr(limbs(new(big.Int).Mod(y, p))), &c.fp.rr),
+		z: c.fp.one,
+	}
+}
+
+// toAffine needs no special case for infinity: Z⁻¹ comes out as 0, giving (0, 0)
+func (c *curve) toAffine(pt point) (*big.Int, *big.Int) {
+	zInv := c.fp.inv(&pt.z)
+	x, y := c.fp.mul(&pt.x, &zInv), c.fp.mul(&pt.y, &zInv)
+	xb, yb := c.fp.bytes(&x), c.fp.bytes(&y)
+	return new(big.Int).SetBytes(xb[:]), new(big.Int).SetBytes(yb[:])
+}
+
+// add is algorithm 7 of Renes, Costello, and Batina, "Complete addition formulas for prime order elliptic curves" (2015), which is for a = 0
+func (c *curve) add(p1, p2 *point) point {
+	f := c.fp
+	t0 := f.mul(&p1.x, &p2.x)
+	t1 := f.mul(&p1.y, &p2.y)
+	t2 := f.mul(&p1.z, &p2.z)
+	t3 := f.add(&p1.x, &p1.y)
+	t4 := f.add(&p2.x, &p2.y)
+	t3 = f.mul(&t3, &t4)
+	t4 = f.add(&t0, &t1)
+	t3 = f.sub(&t3, &t4)
+	t4 = f.add(&p1.y, &p1.z)
+	x3 := f.add(&p2.y, &p2.z)
+	t4 = f.mul(&t4, &x3)
+	x3 = f.add(&t1, &t2)
+	t4 = f.sub(&t4, &x3)
+	x3 = f.add(&p1.x, &p1.z)
+	y3 := f.add(&p2.x, &p2.z)
+	x3 = f.mul(&x3, &y3)
+	y3 = f.add(&t0, &t2)
+	y3 = f.sub(&x3, &y3)
+	x3 = f.add(&t0, &t0)
+	t0 = f.add(&x3, &t0)
+	t2 = f.mul(&c.b3, &t2)
+	z3 := f.add(&t1, &t2)
+	t1 = f.sub(&t1, &t2)
+	y3 = f.mul(&c.b3, &y3)
+	x3 = f.mul(&t4, &y3)
+	t2 = f.mul(&t3, &t1)
+	x3 = f.sub(&t2, &x3)
+	y3 = f.mul(&y3, &t0)
+	t1 = f.mul(&t1, &z3)
+	y3 = f.add(&t1, &y3)
+	t0 = f.mul(&t0, &t3)
+	z3 = f.mul(&z3, &t4)
+	z3 = f.add(&z3, &t0)
+	return point{x3, y3, z3}
+}
+
+// double is algorithm 9 of the same paper
+func (c *curve) double(p1 *point) point {
+	f := c.fp
+	t0 := f.mul(&p1.y, &p1.y)
+	z3 := f.add(&t0, &t0)
+	z3 = f.add(&z3, &z3)
+	z3 = f.add(&z3, &z3)
+	t1 := f.mul(&p1.y, &p1.z)
+	t2 := f.mul(&p1.z, &p1.z)
+	t2 = f.mul(&c.b3, &t2)
+	x3 := f.mul(&t2, &z3)
+	y3 := f.add(&t0, &t2)
+	z3 = f.mul(&t1, &z3)
+	t1 = f.add(&t2, &t2)
+	t2 = f.add(&t1, &t2)
+	t0 = f.sub(&t0, &t2)
+	y3 = f.mul(&t0, &y3)
+	y3 = f.add(&x3, &y3)
+	t1 = f.mul(&p1.x, &p1.y)
+	x3 = f.mul(&t0, &t1)
+	x3 = f.add(&x3, &x3)
+	return point{x3, y3, z3}
+}
+
+// scalarMult computes k*p1 with 4-bit fixed windows: each nibble of k costs four doublings and an addition, whatever its value, and the table entry to add is found by reading every entry.
+// The time taken depends only on len(k).
+func (c *curve) scalarMult(p1 *point, k []byte) point {
+	var table [16]point
+	table[0] = c.infinity()
+	for i := 1; i < 16; i++ {
+		table[i] = c.add(&table[i-1], p1)
+	}
+
+	acc := c.infinity()
+	for _, b := range k {
+		for _, nibble := range [2]byte{b >> 4, b & 0xf} {
+			for i := 0; i < 4; i++ {
+				acc = c.double(&acc)
+			}
+			var addend point
+			for i := range table {
+				cond := subtle.ConstantTimeByteEq(byte(i), nibble)
+				selectElem(&addend.x, &table[i].x, cond)
+				selectElem(&addend.y, &table[i].y, cond)
+				selectElem(&addend.z, &table[i].z, cond)
+			}
+			acc = c.add(&acc, &addend)
+		}
+	}
+	return acc
+}
+
+func ptr(e elem) *elem {
+	return &e
+}
@@ -0,0 +1,179 @@
+package secp256k1
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func hexInt(t *testing.T, s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 16)
+	require.True(t, ok)
+	return i
+}
+
+func TestScalarBaseMult(t *testing.T) {
+	cases := []struct {
+		k    int64
+		x, y string
+	}{
+		{1, "79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798", "483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8"},
+		{2, "C6047F9441ED7D6D3045406E95C07CD85C778E4B8CEF3CA7ABAC09B95C709EE5", "1AE168FEA63DC339A3C58419466CEAEEF7F632653266D0E1236431A950CFE52A"},
+		{3, "F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9", "388F7B0F632DE8140FE337E62A37F3566500A99934C2231B6CB9FD7584B8E672"},
+	}
+
+	c := S256()
+	for _, cse := range cases {
+		x, y := c.ScalarBaseMult(big.NewInt(cse.k).Bytes())
+		require.Equal(t, hexInt(t, cse.x), x, "k=%d", cse.k)
+		require.Equal(t, hexInt(t, cse.y), y, "k=%d", cse.k)
+		require.True(t, c.IsOnCurve(x, y))
+	}
+
+	// Double and Add should agree with ScalarBaseMult
+	params := c.Params()
+	x2, y2 := c.Double(params.Gx, params.Gy)
+	x3, y3 := c.Add(x2, y2, params.Gx, params.Gy)
+	require.Equal(t, hexInt(t, cases[2].x), x3)
+	require.Equal(t, hexInt(t, cases[2].y), y3)
+
+	// n*G is the point at infinity
+	x, y := c.ScalarBaseMult(params.N.Bytes())
+	require.Zero(t, x.Sign())
+	require.Zero(t, y.Sign())
+}
+
+func TestEncoding(t *testing.T) {
+	params := S256().Params()
+	for k := int64(1); k < 20; k++ {
+		x, y := S256().ScalarBaseMult(big.NewInt(k).Bytes())
+
+		bx, by := Unmarshal(Marshal(x, y))
+		require.Equal(t, x, bx)
+		require.Equal(t, y, by)
+
+		compressed := append([]byte{byte(2 + y.Bit(0))}, x.FillBytes(make([]byte, 32))...)
+		cx, cy := Unmarshal(compressed)
+		require.Equal(t, x, cx)
+		require.Equal(t, y, cy)
+	}
+
+	x, _ := Unmarshal(Marshal(params.Gx, new(big.Int).Add(params.Gy, big.NewInt(1))))
+	require.Nil(t, x, "point off the curve should be rejected")
+}
+
+func TestECDSA(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(S256(), rand.Reader)
+	require.NoError(t, err)
+	require.True(t, S256().IsOnCurve(priv.X, priv.Y))
+
+	digest := sha256.Sum256([]byte("hello"))
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest[:])
+	require.NoError(t, err)
+	require.True(t, ecdsa.VerifyASN1(&priv.PublicKey, digest[:], sig))
+
+	digest[0] ^= 1
+	require.False(t, ecdsa.VerifyASN1(&priv.PublicKey, digest[:], sig))
+}
+
+// refScalarMult is textbook affine double-and-add, to check the constant-time arithmetic against
+func refScalarMult(x, y, k *big.Int) (*big.Int, *big.Int) {
+	p := S256().Params().P
+	add := func(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+		if x1 == nil {
+			return x2, y2
+		}
+		var l *big.Int
+		if x1.Cmp(x2) == 0 {
+			if new(big.Int).Add(y1, y2).Cmp(p) == 0 || y1.Sign() == 0 {
+				return nil, nil
+			}
+			l = new(big.Int).Mul(x1, x1) // 3x² / 2y
+			l.Mul(l, big.NewInt(3))
+			l.Mul(l, new(big.Int).ModInverse(new(big.Int).Lsh(y1, 1), p))
+		} else {
+			l = new(big.Int).Sub(y2, y1)
+			l.Mul(l, new(big.Int).ModInverse(new(big.Int).Sub(x2, x1), p))
+		}
+		l.Mod(l, p)
+		x3 := new(big.Int).Mul(l, l)
+		x3.Sub(x3, x1)
+		x3.Sub(x3, x2)
+		x3.Mod(x3, p)
+		y3 := new(big.Int).Sub(x1, x3)
+		y3.Mul(y3, l)
+		y3.Sub(y3, y1)
+		y3.Mod(y3, p)
+		return x3, y3
+	}
+	var rx, ry *big.Int
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		rx, ry = add(rx, ry, rx, ry)
+		if k.Bit(i) == 1 {
+			rx, ry = add(rx, ry, x, y)
+		}
+	}
+	if rx == nil {
+		return new(big.Int), new(big.Int)
+	}
+	return rx, ry
+}
+
+func TestScalarMult(t *testing.T) {
+	c := S256()
+	params := c.Params()
+	nMinus1 := new(big.Int).Sub(params.N, big.NewInt(1))
+	scalars := []*big.Int{big.NewInt(0), big.NewInt(1), big.NewInt(15), big.NewInt(16), nMinus1, params.N}
+	for i := 0; i < 20; i++ {
+		k, err := rand.Int(rand.Reader, params.N)
+		require.NoError(t, err)
+		scalars = append(scalars, k)
+	}
+
+	px, py := c.ScalarBaseMult(big.NewInt(12345).Bytes())
+	for _, k := range scalars {
+		for _, base := range [][2]*big.Int{{params.Gx, params.Gy}, {px, py}} {
+			wantX, wantY := refScalarMult(base[0], base[1], k)
+			x, y := c.ScalarMult(base[0], base[1], k.FillBytes(make([]byte, 32)))
+			require.Zero(t, wantX.Cmp(x), "k=%x", k)
+			require.Zero(t, wantY.Cmp(y), "k=%x", k)
+		}
+	}
+
+	// The formulae are complete: P + -P, P + P, and anything with infinity all just work
+	negY := new(big.Int).Sub(params.P, py)
+	x, y := c.Add(px, py, px, negY)
+	require.Zero(t, x.Sign())
+	require.Zero(t, y.Sign())
+	x, y = c.Add(px, py, px, py)
+	wantX, wantY := c.Double(px, py)
+	require.Equal(t, wantX, x)
+	require.Equal(t, wantY, y)
+	x, y = c.Add(px, py, new(big.Int), new(big.Int))
+	require.Equal(t, px, x)
+	require.Equal(t, py, y)
+}
+
+func TestSign(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(S256(), rand.Reader)
+	require.NoError(t, err)
+	var d [32]byte
+	priv.D.FillBytes(d[:])
+
+	digest := sha256.Sum256([]byte("hello"))
+	r, s, err := Sign(rand.Reader, &d, digest[:])
+	require.NoError(t, err)
+	require.True(t, ecdsa.Verify(&priv.PublicKey, digest[:], new(big.Int).SetBytes(r[:]), new(big.Int).SetBytes(s[:])))
+
+	// Fresh nonce each time
+	r2, _, err := Sign(rand.Reader, &d, digest[:])
+	require.NoError(t, err)
+	require.NotEqual(t, r, r2)
+
+	_, _, err = Sign(rand.Reader, &[32]byte{}, digest[:])
+	require.Error(t, err)
+}
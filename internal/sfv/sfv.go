@@ -0,0 +1,492 @@
+// Package sfv implements the parts of RFC 8941 Structured Field Values that HTTP Message Signatures (RFC 9421) need: dictionaries, inner lists, and items with parameters.
+// Decimals aren't supported.
+package sfv
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Token is a bare-word item, as opposed to a quoted string
+type Token string
+
+// Item is a bare item - int64, string, Token, []byte, or bool - and its parameters
+type Item struct {
+	Value  any
+	Params Params
+}
+
+// InnerList is a parenthesised list of items, with its own parameters
+type InnerList struct {
+	Items  []Item
+	Params Params
+}
+
+// Param is one key=value parameter. Parameters without a value are true.
+type Param struct {
+	Key   string
+	Value any
+}
+
+// Params are kept in order, as serialisations must reproduce it
+type Params []Param
+
+// Get returns the value of the parameter key, and whether it's present
+func (ps Params) Get(key string) (any, bool) {
+	for _, p := range ps {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Member is one key of a dictionary. Value is an Item or an InnerList.
+type Member struct {
+	Key   string
+	Value any
+}
+
+// Dictionary members are kept in order. Duplicate keys are resolved as RFC 8941 §4.2.2 says: the last value wins, in the first one's position.
+type Dictionary []Member
+
+// Get returns the member key, and whether it's present
+func (d Dictionary) Get(key string) (any, bool) {
+	for _, m := range d {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ===
+// Parsing (RFC 8941 §4.2)
+// ===
+
+type parser struct {
+	s string
+	i int
+}
+
+func (p *parser) eof() bool  { return p.i >= len(p.s) }
+func (p *parser) peek() byte { return p.s[p.i] }
+
+func (p *parser) skipSP() {
+	for !p.eof() && p.peek() == ' ' {
+		p.i++
+	}
+}
+
+func (p *parser) skipOWS() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.i++
+	}
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("structured field, at %d: %s", p.i, fmt.Sprintf(format, args...))
+}
+
+// ParseDictionary parses a dictionary-valued field. Multiple field lines should be joined with ", " first.
+func ParseDictionary(s string) (Dictionary, error) {
+	p := &parser{s: s}
+	p.skipSP()
+	d := Dictionary{}
+	for !p.eof() {
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var v any
+		if !p.eof() && p.peek() == '=' {
+			p.i++
+			v, err = p.itemOrInnerList()
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			ps, err := p.params()
+			if err != nil {
+				return nil, err
+			}
+			v = Item{Value: true, Params: ps}
+		}
+		replaced := false
+		for i := range d {
+			if d[i].Key == key {
+				d[i].Value = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			d = append(d, Member{Key: key, Value: v})
+		}
+
+		p.skipOWS()
+		if p.eof() {
+			return d, nil
+		}
+		if p.peek() != ',' {
+			return nil, p.errorf("expected ','")
+		}
+		p.i++
+		p.skipOWS()
+		if p.eof() {
+			return nil, p.errorf("trailing ','")
+		}
+	}
+	return d, nil
+}
+
+// ParseItem parses an item-valued field, eg `"@query-param";name="id"`
+func ParseItem(s string) (Item, error) {
+	p := &parser{s: s}
+	p.skipSP()
+	it, err := p.item()
+	if err != nil {
+		return Item{}, err
+	}
+	p.skipSP()
+	if !p.eof() {
+		return Item{}, p.errorf("trailing characters")
+	}
+	return it, nil
+}
+
+func (p *parser) itemOrInnerList() (any, error) {
+	if !p.eof() && p.peek() == '(' {
+		return p.innerList()
+	}
+	return p.item()
+}
+
+func (p *parser) innerList() (InnerList, error) {
+	p.i++ // (
+	l := InnerList{Items: []Item{}}
+	for !p.eof() {
+		p.skipSP()
+		if p.eof() {
+			break
+		}
+		if p.peek() == ')' {
+			p.i++
+			ps, err := p.params()
+			if err != nil {
+				return l, err
+			}
+			l.Params = ps
+			return l, nil
+		}
+		it, err := p.item()
+		if err != nil {
+			return l, err
+		}
+		l.Items = append(l.Items, it)
+		if !p.eof() && p.peek() != ' ' && p.peek() != ')' {
+			return l, p.errorf("expected ' ' or ')' in inner list")
+		}
+	}
+	return l, p.errorf("unterminated inner list")
+}
+
+func (p *parser) item() (Item, error) {
+	v, err := p.bareItem()
+	if err != nil {
+		return Item{}, err
+	}
+	ps, err := p.params()
+	if err != nil {
+		return Item{}, err
+	}
+	return Item{Value: v, Params: ps}, nil
+}
+
+func (p *parser) params() (Params, error) {
+	ps := Params{}
+	for !p.eof() && p.peek() == ';' {
+		p.i++
+		p.skipSP()
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var v any = true
+		if !p.eof() && p.peek() == '=' {
+			p.i++
+			v, err = p.bareItem()
+			if err != nil {
+				return nil, err
+			}
+		}
+		replaced := false
+		for i := range ps {
+			if ps[i].Key == key {
+				ps[i].Value = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			ps = append(ps, Param{Key: key, Value: v})
+		}
+	}
+	return ps, nil
+}
+
+func (p *parser) key() (string, error) {
+	if p.eof() || !(isLCAlpha(p.peek()) || p.peek() == '*') {
+		return "", p.errorf("expected a key")
+	}
+	start := p.i
+	for !p.eof() && (isLCAlpha(p.peek()) || isDigit(p.peek()) || strings.IndexByte("_-.*", p.peek()) >= 0) {
+		p.i++
+	}
+	return p.s[start:p.i], nil
+}
+
+func (p *parser) bareItem() (any, error) {
+	if p.eof() {
+		return nil, p.errorf("expected an item")
+	}
+	c := p.peek()
+	switch {
+	case c == '-' || isDigit(c):
+		return p.integer()
+	case c == '"':
+		return p.str()
+	case c == ':':
+		return p.byteSeq()
+	case c == '?':
+		return p.boolean()
+	case isAlpha(c) || c == '*':
+		return p.token(), nil
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+func (p *parser) integer() (int64, error) {
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+	for !p.eof() && isDigit(p.peek()) {
+		p.i++
+	}
+	if !p.eof() && p.peek() == '.' {
+		return 0, p.errorf("decimals aren't supported")
+	}
+	digits := strings.TrimPrefix(p.s[start:p.i], "-")
+	if len(digits) == 0 || len(digits) > 15 {
+		return 0, p.errorf("integer must have 1-15 digits")
+	}
+	return strconv.ParseInt(p.s[start:p.i], 10, 64)
+}
+
+func (p *parser) str() (string, error) {
+	p.i++ // "
+	var b strings.Builder
+	for !p.eof() {
+		c := p.peek()
+		p.i++
+		switch {
+		case c == '\\':
+			if p.eof() || (p.peek() != '"' && p.peek() != '\\') {
+				return "", p.errorf("bad escape in string")
+			}
+			b.WriteByte(p.peek())
+			p.i++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("bad character in string")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *parser) token() Token {
+	start := p.i
+	for !p.eof() && isTChar(p.peek()) {
+		p.i++
+	}
+	return Token(p.s[start:p.i])
+}
+
+func (p *parser) byteSeq() ([]byte, error) {
+	p.i++ // :
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, p.errorf("unterminated byte sequence")
+	}
+	bs, err := base64.StdEncoding.DecodeString(p.s[p.i : p.i+end])
+	if err != nil {
+		return nil, p.errorf("byte sequence: %v", err)
+	}
+	p.i += end + 1
+	return bs, nil
+}
+
+func (p *parser) boolean() (bool, error) {
+	p.i++ // ?
+	if p.eof() {
+		return false, p.errorf("expected a boolean")
+	}
+	c := p.peek()
+	p.i++
+	switch c {
+	case '1':
+		return true, nil
+	case '0':
+		return false, nil
+	default:
+		return false, p.errorf("expected a boolean")
+	}
+}
+
+func isLCAlpha(c byte) bool { return c >= 'a' && c <= 'z' }
+func isAlpha(c byte) bool   { return isLCAlpha(c) || (c >= 'A' && c <= 'Z') }
+func isDigit(c byte) bool   { return c >= '0' && c <= '9' }
+
+// RFC 9110 tchar, plus ':' and '/' (RFC 8941 §3.3.4)
+func isTChar(c byte) bool {
+	return isAlpha(c) || isDigit(c) || strings.IndexByte("!#$%&'*+-.^_`|~:/", c) >= 0
+}
+
+// ===
+// Serialisation (RFC 8941 §4.1)
+// ===
+
+// SerializeDictionary renders d as a field value
+func SerializeDictionary(d Dictionary) (string, error) {
+	var b strings.Builder
+	for i, m := range d {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(m.Key)
+		switch v := m.Value.(type) {
+		case Item:
+			if v.Value == true {
+				if err := writeParams(&b, v.Params); err != nil {
+					return "", err
+				}
+				continue
+			}
+			b.WriteByte('=')
+			if err := writeItem(&b, v); err != nil {
+				return "", err
+			}
+		case InnerList:
+			b.WriteByte('=')
+			if err := writeInnerList(&b, v); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("dictionary member %s: unsupported value %T", m.Key, m.Value)
+		}
+	}
+	return b.String(), nil
+}
+
+// SerializeInnerList renders l, eg `("a" "b");k=1`
+func SerializeInnerList(l InnerList) (string, error) {
+	var b strings.Builder
+	err := writeInnerList(&b, l)
+	return b.String(), err
+}
+
+// SerializeItem renders it, eg `"a";k=1`
+func SerializeItem(it Item) (string, error) {
+	var b strings.Builder
+	err := writeItem(&b, it)
+	return b.String(), err
+}
+
+func writeInnerList(b *strings.Builder, l InnerList) error {
+	b.WriteByte('(')
+	for i, it := range l.Items {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if err := writeItem(b, it); err != nil {
+			return err
+		}
+	}
+	b.WriteByte(')')
+	return writeParams(b, l.Params)
+}
+
+func writeItem(b *strings.Builder, it Item) error {
+	if err := writeBareItem(b, it.Value); err != nil {
+		return err
+	}
+	return writeParams(b, it.Params)
+}
+
+func writeParams(b *strings.Builder, ps Params) error {
+	for _, p := range ps {
+		b.WriteByte(';')
+		b.WriteString(p.Key)
+		if p.Value == true {
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeBareItem(b, p.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBareItem(b *strings.Builder, v any) error {
+	switch v := v.(type) {
+	case int64:
+		if v > 999_999_999_999_999 || v < -999_999_999_999_999 {
+			return fmt.Errorf("integer %d out of range", v)
+		}
+		b.WriteString(strconv.FormatInt(v, 10))
+	case int:
+		return writeBareItem(b, int64(v))
+	case string:
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			c := v[i]
+			if c < 0x20 || c > 0x7e {
+				return fmt.Errorf("string %q has characters that can't be serialised", v)
+			}
+			if c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('"')
+	case Token:
+		if len(v) == 0 || !(isAlpha(v[0]) || v[0] == '*') {
+			return fmt.Errorf("bad token %q", v)
+		}
+		for i := 0; i < len(v); i++ {
+			if !isTChar(v[i]) {
+				return fmt.Errorf("bad token %q", v)
+			}
+		}
+		b.WriteString(string(v))
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	default:
+		return fmt.Errorf("unsupported item type %T", v)
+	}
+	return nil
+}
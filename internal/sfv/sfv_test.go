@@ -0,0 +1,74 @@
+package sfv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected string
+	}{
+		// RFC 9421 §4.1
+		{`sig1=("@method" "@authority" "@path" "content-digest" "content-length" "content-type");created=1618884473;keyid="test-key-rsa-pss"`, ""},
+		{`sig-b21=();created=1618884473;keyid="test-key-rsa-pss";nonce="b3k2pp5k7z-50gnwp.yemd"`, ""},
+		{`sig1=:YQ==:, sig2=:Yg==:`, ""},
+		// RFC 8941 examples
+		{`a=?0, b, c;foo=bar`, ""},
+		{`en="Applepie", da=:w4ZibGV0w6ZydGU=:`, ""},
+		{`rating=("foo" bar);x=-5;y=?1`, `rating=("foo" bar);x=-5;y`},
+		// Whitespace is normalised, and a duplicated key keeps its first position but its last value
+		{`a=1 ,	b=2,a=3`, `a=3, b=2`},
+		{`a=("x"  "y" )`, `a=("x" "y")`},
+	}
+	for _, cse := range cases {
+		d, err := ParseDictionary(cse.in)
+		require.NoError(t, err, cse.in)
+		out, err := SerializeDictionary(d)
+		require.NoError(t, err, cse.in)
+		expected := cse.expected
+		if expected == "" {
+			expected = cse.in
+		}
+		require.Equal(t, expected, out)
+	}
+}
+
+func TestParseDictionaryValues(t *testing.T) {
+	d, err := ParseDictionary(`sig1=("@query-param";name="Pet" "x");created=1;alg="ed25519", s=:AQID:`)
+	require.NoError(t, err)
+
+	v, ok := d.Get("sig1")
+	require.True(t, ok)
+	l := v.(InnerList)
+	require.Len(t, l.Items, 2)
+	require.Equal(t, "@query-param", l.Items[0].Value)
+	name, _ := l.Items[0].Params.Get("name")
+	require.Equal(t, "Pet", name)
+	created, _ := l.Params.Get("created")
+	require.Equal(t, int64(1), created)
+
+	v, ok = d.Get("s")
+	require.True(t, ok)
+	require.Equal(t, []byte{1, 2, 3}, v.(Item).Value)
+}
+
+func TestParseDictionaryErrors(t *testing.T) {
+	for _, in := range []string{
+		`a=`,
+		`a=1,`,
+		`A=1`,
+		`a=("x"`,
+		`a="unterminated`,
+		`a=:AQID`,
+		`a=1.5`,
+		`a=1234567890123456`,
+		`a=?2`,
+		`a=1 b=2`,
+	} {
+		_, err := ParseDictionary(in)
+		require.Error(t, err, in)
+	}
+}
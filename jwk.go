@@ -5,6 +5,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 )
@@ -12,6 +13,86 @@ import (
 type JWK struct {
 	KeyID string
 	Key   any
+
+	// Optional metadata (RFC 7517 §4). These are preserved when parsing, and rendered if set.
+	Use       string   // Intended use of the public key: "sig" or "enc". If empty, crypto/ecdh keys are rendered with "enc".
+	KeyOps    []string // Permitted operations, eg "sign", "verify"
+	Algorithm string   // Intended algorithm, eg "ES256". If empty, RSA keys are rendered with a default.
+	// Certificates is the x5c chain, leaf first. The leaf must certify Key (RFC 7517 §4.7), which is checked when parsing.
+	Certificates []*x509.Certificate
+}
+
+func (k *JWK) commonFields() commonFields {
+	return commonFields{
+		KeyID:     k.KeyID,
+		Use:       k.Use,
+		KeyOps:    k.KeyOps,
+		Algorithm: k.Algorithm,
+		X5C:       certificates2X5C(k.Certificates),
+	}
+}
+
+// PublicOnly returns a copy of the JWK holding just the public part of its key. Metadata is kept.
+func (k *JWK) PublicOnly() (*JWK, error) {
+	pub, err := KeyPublicPartErr(k.Key)
+	if err != nil {
+		return nil, err
+	}
+	out := *k
+	out.Key = pub
+	return &out, nil
+}
+
+// KeyType returns the kty this key would be rendered with, eg "RSA", or "" if it's not a type JWK supports.
+func (k *JWK) KeyType() string {
+	switch typedKey := k.Key.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey, *RSAPSSPublicKey, *RSAPSSPrivateKey:
+		return "RSA"
+	case *ecdsa.PublicKey, *ecdsa.PrivateKey:
+		return "EC"
+	case *ecdh.PublicKey:
+		if _, ok := ecdhEllipticCurve(typedKey.Curve()); ok {
+			return "EC"
+		}
+		return ""
+	case *ecdh.PrivateKey:
+		if _, ok := ecdhEllipticCurve(typedKey.Curve()); ok {
+			return "EC"
+		}
+		return ""
+	case ed25519.PublicKey, ed25519.PrivateKey, Ed448PublicKey, Ed448PrivateKey, X448PublicKey, X448PrivateKey:
+		return "OKP"
+	default:
+		return ""
+	}
+}
+
+// Curve returns the crv this key would be rendered with, eg "P-256", or "" if it's not an elliptic curve key.
+func (k *JWK) Curve() string {
+	switch typedKey := k.Key.(type) {
+	case *ecdsa.PublicKey:
+		return typedKey.Curve.Params().Name
+	case *ecdsa.PrivateKey:
+		return typedKey.Curve.Params().Name
+	case *ecdh.PublicKey:
+		if c, ok := ecdhEllipticCurve(typedKey.Curve()); ok {
+			return c.Params().Name
+		}
+		return ""
+	case *ecdh.PrivateKey:
+		if c, ok := ecdhEllipticCurve(typedKey.Curve()); ok {
+			return c.Params().Name
+		}
+		return ""
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return "Ed25519"
+	case Ed448PublicKey, Ed448PrivateKey:
+		return "Ed448"
+	case X448PublicKey, X448PrivateKey:
+		return "X448"
+	default:
+		return ""
+	}
 }
 
 // ===
@@ -21,15 +102,15 @@ type JWK struct {
 func PEM2JWKMarshaler(p []byte) (*JWK, error) {
 	ders, err := parsePEM(p)
 	if err != nil {
-		return nil, fmt.Errorf("can't decode input as PEM: %w", err)
+		return nil, err
 	}
 	if len(ders) != 1 {
-		return nil, fmt.Errorf("PEM must contain precisely one block")
+		return nil, &PEMError{Block: -1, Err: fmt.Errorf("PEM must contain precisely one block, not %d", len(ders))}
 	}
 
 	key, err := parseDER(ders[0])
 	if err != nil {
-		return nil, fmt.Errorf("error in PEM block: %w", err)
+		return nil, &PEMError{Block: 0, Err: err}
 	}
 
 	return Key2JWKMarshaler(key)
@@ -53,8 +134,12 @@ func JWK2PEM(j []byte) ([]byte, error) {
 		return nil, fmt.Errorf("error in key: %w", err)
 	}
 
+	priv, err := KeyIsPrivateErr(key)
+	if err != nil {
+		return nil, err
+	}
 	blockTitle := "PUBLIC KEY"
-	if KeyIsPrivate(key) {
+	if priv {
 		// Because we encode all priv keys as pkcs8 (even ecdsa, for which this isn't the openssl default), this string is always correct. If we used openssl's default SEC1 for ecdsa, this would need to be "EC PRIVATE KEY"
 		blockTitle = "PRIVATE KEY"
 	}
@@ -65,47 +150,98 @@ func JWK2PEM(j []byte) ([]byte, error) {
 // crypto.Key -> JSON / Marshaler
 // ===
 
+// MarshalJSON renders the key. Like JWKS.MarshalJSON, it refuses private keys with a PrivateKeyError; use MarshalOptions to allow them, or PublicOnly to strip them.
 func (k *JWK) MarshalJSON() ([]byte, error) {
+	return MarshalOptions{}.marshalKey(k)
+}
+
+// render does the work of MarshalJSON, per the options in o that affect individual keys' members.
+// It doesn't check privateness; that's the caller's job.
+func (k *JWK) render(o MarshalOptions) ([]byte, error) {
+	common := k.commonFields()
 	switch typedKey := k.Key.(type) {
 	case *rsa.PublicKey:
-		return renderRsaPublicKey(typedKey, k.KeyID)
+		return renderRsaPublicKey(typedKey, common)
 	case *ecdsa.PublicKey:
-		return renderEcdsaPublicKey(typedKey, k.KeyID)
+		return renderEcdsaPublicKey(typedKey, common)
 	case *rsa.PrivateKey:
-		return renderRsaPrivateKey(typedKey, k.KeyID)
+		return renderRsaPrivateKey(typedKey, common, o.MinimalRSA)
 	case *ecdsa.PrivateKey:
-		return renderEcdsaPrivateKey(typedKey, k.KeyID)
+		return renderEcdsaPrivateKey(typedKey, common)
+	case *RSAPSSPublicKey:
+		common, err := rsaPSSCommonFields(typedKey.Params, common)
+		if err != nil {
+			return nil, err
+		}
+		return renderRsaPublicKey(typedKey.PublicKey, common)
+	case *RSAPSSPrivateKey:
+		common, err := rsaPSSCommonFields(typedKey.Params, common)
+		if err != nil {
+			return nil, err
+		}
+		return renderRsaPrivateKey(typedKey.PrivateKey, common, o.MinimalRSA)
+	case *ecdh.PublicKey:
+		pub, err := ecdhPublicKeyToEcdsa(typedKey)
+		if err != nil {
+			return nil, err
+		}
+		return renderEcdsaPublicKey(pub, ecdhCommonFields(common))
+	case *ecdh.PrivateKey:
+		priv, err := ecdhPrivateKeyToEcdsa(typedKey)
+		if err != nil {
+			return nil, err
+		}
+		return renderEcdsaPrivateKey(priv, ecdhCommonFields(common))
+	case ed25519.PublicKey:
+		return renderOkpKey("Ed25519", typedKey, nil, common)
+	case ed25519.PrivateKey:
+		return renderOkpKey("Ed25519", typedKey.Public().(ed25519.PublicKey), typedKey.Seed(), common)
+	case Ed448PublicKey:
+		return renderOkpKey("Ed448", typedKey, nil, common)
+	case Ed448PrivateKey:
+		return renderOkpKey("Ed448", typedKey[Ed448KeySize:], typedKey[:Ed448KeySize], common)
+	case X448PublicKey:
+		return renderOkpKey("X448", typedKey, nil, common)
+	case X448PrivateKey:
+		return renderOkpKey("X448", typedKey[X448KeySize:], typedKey[:X448KeySize], common)
 	default:
-		panic(fmt.Errorf("invalid key type %T", k.Key))
+		return nil, &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("%T", k.Key), Format: "JWK"}
 	}
 }
 
-// This does a bit more than the JWKS-version because
-// - needs to check for JWK-unsupported key types.
-// - does the public-part extraction. When we have generics we can do it at render time? No! If we want one type, that won't encode whether we should do it, so we need to do so here at ctor time.
-//   - TODO factor out to inisial call
-//
-// TODO: extra key types (wait for go 1.21; this API is being sorted apaz)
+// Key2JWKMarshaler wraps k in a JWK, having checked that it's a type JWKs can hold:
+// RSA (including RSA-PSS), EC (the NIST curves, from crypto/ecdsa or crypto/ecdh, and secp256k1), and OKP (Ed25519, Ed448, and X448).
+// X25519 keys aren't supported, as yet.
 func Key2JWKMarshaler(k any) (*JWK, error) {
-	switch k.(type) {
+	switch typedKey := k.(type) {
 	case *rsa.PublicKey:
 		return &JWK{Key: k}, nil
 	case *ecdsa.PublicKey:
 		return &JWK{Key: k}, nil
 	case ed25519.PublicKey: // Not a pointer *shrug*
-		return nil, fmt.Errorf("JWK does not support Ed25519")
+		return &JWK{Key: k}, nil
 	case *ecdh.PublicKey:
-		return nil, fmt.Errorf("JWK does not support x25519")
+		if _, ok := ecdhEllipticCurve(typedKey.Curve()); ok {
+			return &JWK{Key: k}, nil
+		}
+		return nil, &UnsupportedKeyTypeError{KeyType: "x25519", Format: "JWK"}
 	case *rsa.PrivateKey:
 		return &JWK{Key: k}, nil
 	case *ecdsa.PrivateKey:
 		return &JWK{Key: k}, nil
 	case ed25519.PrivateKey: // Not a pointer *shrug*
-		return nil, fmt.Errorf("JWK does not support Ed25519")
+		return &JWK{Key: k}, nil
 	case *ecdh.PrivateKey:
-		return nil, fmt.Errorf("JWK does not support x25519")
+		if _, ok := ecdhEllipticCurve(typedKey.Curve()); ok {
+			return &JWK{Key: k}, nil
+		}
+		return nil, &UnsupportedKeyTypeError{KeyType: "x25519", Format: "JWK"}
+	case Ed448PublicKey, Ed448PrivateKey, X448PublicKey, X448PrivateKey:
+		return &JWK{Key: k}, nil
+	case *RSAPSSPublicKey, *RSAPSSPrivateKey:
+		return &JWK{Key: k}, nil
 	default:
-		return nil, fmt.Errorf("unknown key type: %T", k)
+		return nil, &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("%T", k)}
 	}
 }
 func Key2JWK(k any) (string, error) {
@@ -116,35 +252,45 @@ func Key2JWK(k any) (string, error) {
 // JSON -> crypto.Key / Unmarshaler
 // ===
 
+// UnmarshalJSON parses a JWK. Private keys are checked for consistency; use UnmarshalOptions.UnmarshalKey to skip that.
 func (p *JWK) UnmarshalJSON(data []byte) error {
-	protoKey := struct {
-		KeyID   string `json:"kid,omitempty"`
-		KeyType string `json:"kty"`
-	}{}
+	return p.unmarshal(data, UnmarshalOptions{})
+}
+
+func (p *JWK) unmarshal(data []byte, o UnmarshalOptions) error {
+	protoKey := commonFields{}
 	err := json.Unmarshal(data, &protoKey)
 	if err != nil {
 		return err
 	}
 	p.KeyID = protoKey.KeyID
+	p.Use = protoKey.Use
+	p.KeyOps = protoKey.KeyOps
+	p.Algorithm = protoKey.Algorithm
 
 	switch protoKey.KeyType {
 	case "RSA":
-		k, err := parseRsaKey(data)
-		if err != nil {
-			return err
-		}
-		p.Key = k
-		return nil
+		p.Key, err = parseRsaKey(data, o)
 	case "EC":
-		k, err := parseEcdsaKey(data)
-		if err != nil {
-			return err
+		var k any
+		k, err = parseEcdsaKey(data, o)
+		if err == nil && o.ECDH {
+			k, err = ecdsaKeyToEcdh(k)
 		}
 		p.Key = k
-		return nil
+	case "OKP":
+		p.Key, err = parseOkpKey(data, o)
+	case "":
+		return missingParameter("kty")
 	default:
-		return fmt.Errorf("unknown key type %s", protoKey.KeyType)
+		return &UnsupportedKeyTypeError{KeyType: protoKey.KeyType}
 	}
+	if err != nil {
+		return err
+	}
+
+	p.Certificates, err = x5c2Certificates(protoKey.X5C, p.Key)
+	return err
 }
 
 func JWK2Key(j []byte) (any, error) {
@@ -1,7 +1,10 @@
 package jwks
 
 import (
+	"bytes"
+	"crypto/ecdh"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rsa"
 	"encoding/base64"
@@ -11,76 +14,215 @@ import (
 	"math/big"
 	"strconv"
 	"strings"
+
+	"github.com/mt-inside/go-jwks/internal/curve448"
+	"github.com/mt-inside/go-jwks/internal/secp256k1"
 )
 
 // The parse funcs need to be combined, because of the caller - this is the only place we know its privateness
 // render funcs make more sense uncombined
 
+// Members common to all key types (RFC 7517 §4).
+// Embedded first in each type's fields struct, so these come first in the output, as they always have.
+type commonFields struct {
+	KeyID     string   `json:"kid,omitempty"`
+	KeyType   string   `json:"kty"`
+	Use       string   `json:"use,omitempty"`
+	KeyOps    []string `json:"key_ops,omitempty"`
+	Algorithm string   `json:"alg,omitempty"`
+	X5C       []string `json:"x5c,omitempty"`
+}
+
 // ===
 // Impl for RSA
 // ===
 
 type rsaPublicKeyFields struct {
-	KeyID     string `json:"kid,omitempty"`
-	KeyType   string `json:"kty"`
-	Algorithm string `json:"alg"`
-	N         string `json:"n"` // Modulus ie P * Q
-	E         string `json:"e"` // Public exponent
+	commonFields
+	N string `json:"n"` // Modulus ie P * Q
+	E string `json:"e"` // Public exponent
 }
 
 type rsaPrivateKeyFields struct {
 	rsaPublicKeyFields
 	D string `json:"d"` // Private exponent
-	// Pre-computed values to speed stuff up.
-	P string `json:"p"`
-	Q string `json:"q"`
+	// Pre-computed values to speed stuff up. Optional: a private key can be just n, e, d (RFC 7518 §6.3.2), from which we recover the rest
+	P string `json:"p,omitempty"`
+	Q string `json:"q,omitempty"`
 	// Primes - some other programmes (like npm pem-jwk) output a field called Primes which I guess contains P and Q but I can't work out the format of it. Ths actual spec just shows P and Q though.
 	Dp   string `json:"dp,omitempty"`
 	Dq   string `json:"dq,omitempty"`
 	Qinv string `json:"qi,omitempty"`
+	// Third and subsequent primes, for multi-prime keys (RFC 7518 §6.3.2.7)
+	Oth []rsaOtherPrimeFields `json:"oth,omitempty"`
+}
+
+type rsaOtherPrimeFields struct {
+	R string `json:"r"` // Prime factor
+	D string `json:"d"` // Factor CRT exponent, ie d mod (r-1)
+	T string `json:"t"` // Factor CRT coefficient, ie the inverse of the product of all previous primes, mod r
 }
 
-func renderRsaPublicKey(k *rsa.PublicKey, kid string) ([]byte, error) {
+func renderRsaPublicKey(k *rsa.PublicKey, common commonFields) ([]byte, error) {
 	bufE := make([]byte, 8)
 	binary.LittleEndian.PutUint64(bufE, uint64(k.E)) // Seems to need to be little-endian to make the URL-encoded version ome out right
 	// TODO: try big-endian, and trim the string from the other end
 	bufE = bufE[:determineLenE(k.E)]
+	common.KeyType = "RSA"
+	if common.Algorithm == "" {
+		common.Algorithm = "RS256" // the one RSA alg everyone supports (RFC 7518 §3.1); nothing to do with the key's size
+	}
 	return json.Marshal(&rsaPublicKeyFields{
-		KeyID:     kid,
-		KeyType:   "RSA",
-		Algorithm: "RS" + strconv.Itoa((*rsa.PublicKey)(k).Size()),
-		N:         base64.RawURLEncoding.EncodeToString(k.N.Bytes()), // Bytes returns big-endian
-		E:         base64.RawURLEncoding.EncodeToString(bufE),
+		commonFields: common,
+		N:            base64.RawURLEncoding.EncodeToString(k.N.Bytes()), // Bytes returns big-endian
+		E:            base64.RawURLEncoding.EncodeToString(bufE),
 	})
 }
 
-func renderRsaPrivateKey(k *rsa.PrivateKey, kid string) ([]byte, error) {
-	if len(k.Primes) != 2 {
-		return nil, fmt.Errorf("don't know how to deal with keys that don't have precisely 2 factors")
-	}
+func renderRsaPrivateKey(k *rsa.PrivateKey, common commonFields, minimal bool) ([]byte, error) {
 	bufE := make([]byte, 8)
 	binary.LittleEndian.PutUint64(bufE, uint64(k.E)) // Seems to need to be little-endian to make the URL-encoded version ome out right
 	bufE = bufE[:determineLenE(k.E)]
-	return json.Marshal(&rsaPrivateKeyFields{
+	common.KeyType = "RSA"
+	if common.Algorithm == "" {
+		common.Algorithm = "RS256"
+	}
+	fields := rsaPrivateKeyFields{
 		rsaPublicKeyFields: rsaPublicKeyFields{
-			KeyID:     kid,
-			KeyType:   "RSA",
-			Algorithm: "RS" + strconv.Itoa(k.Size()),
-			N:         base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
-			E:         base64.RawURLEncoding.EncodeToString(bufE),
+			commonFields: common,
+			N:            base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:            base64.RawURLEncoding.EncodeToString(bufE),
 		},
 		D: base64.RawURLEncoding.EncodeToString(k.D.Bytes()),
-		P: base64.RawURLEncoding.EncodeToString(k.Primes[0].Bytes()),
-		Q: base64.RawURLEncoding.EncodeToString(k.Primes[1].Bytes()),
-		// We render these pre-computed values incase they're of use to anyone, but eg the Go library says they mustn't be manually touched (must be generated by .Precomputed()). Assume any decent ingester of these values will ignore them & compute its own. Note there are also private fields in here
-		Dp:   base64.RawURLEncoding.EncodeToString(k.Precomputed.Dp.Bytes()),
-		Dq:   base64.RawURLEncoding.EncodeToString(k.Precomputed.Dq.Bytes()),
-		Qinv: base64.RawURLEncoding.EncodeToString(k.Precomputed.Qinv.Bytes()),
-	})
+	}
+
+	// A key with no primes at all can only be rendered minimally, but it's a perfectly good JWK.
+	// Conversely, a multi-prime key is always rendered in full, as only two primes can be recovered from d.
+	if (minimal && len(k.Primes) <= 2) || len(k.Primes) == 0 {
+		return json.Marshal(&fields)
+	}
+	if len(k.Primes) < 2 {
+		return nil, &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("RSA with %d primes", len(k.Primes))}
+	}
+
+	var oth []rsaOtherPrimeFields
+	ds, ts := rsaOtherPrimeValues(k)
+	for i, r := range k.Primes[2:] {
+		if ts[i] == nil {
+			return nil, &InvalidParameterError{Member: fmt.Sprintf("oth[%d].r", i), Err: fmt.Errorf("not coprime with the other primes")}
+		}
+		oth = append(oth, rsaOtherPrimeFields{
+			R: base64.RawURLEncoding.EncodeToString(r.Bytes()),
+			D: base64.RawURLEncoding.EncodeToString(ds[i].Bytes()),
+			T: base64.RawURLEncoding.EncodeToString(ts[i].Bytes()),
+		})
+	}
+	dp, dq, qi := k.Precomputed.Dp, k.Precomputed.Dq, k.Precomputed.Qinv
+	if dp == nil {
+		// Caller hasn't called Precompute(); do the sums ourselves rather than poke their key
+		dp, dq, qi = rsaCRTValues(k.D, k.Primes[0], k.Primes[1])
+		if qi == nil {
+			return nil, &InvalidParameterError{Member: "q", Err: fmt.Errorf("not coprime with p")}
+		}
+	}
+
+	fields.P = base64.RawURLEncoding.EncodeToString(k.Primes[0].Bytes())
+	fields.Q = base64.RawURLEncoding.EncodeToString(k.Primes[1].Bytes())
+	// We render these pre-computed values incase they're of use to anyone. We check any that we ingest match the primes.
+	fields.Dp = base64.RawURLEncoding.EncodeToString(dp.Bytes())
+	fields.Dq = base64.RawURLEncoding.EncodeToString(dq.Bytes())
+	fields.Qinv = base64.RawURLEncoding.EncodeToString(qi.Bytes())
+	fields.Oth = oth
+	return json.Marshal(&fields)
+}
+
+// rsaCRTValues computes dp, dq, and qi for the first two primes. qi is nil if p and q aren't coprime
+func rsaCRTValues(d, p, q *big.Int) (dp, dq, qi *big.Int) {
+	one := big.NewInt(1)
+	dp = new(big.Int).Mod(d, new(big.Int).Sub(p, one))
+	dq = new(big.Int).Mod(d, new(big.Int).Sub(q, one))
+	qi = new(big.Int).ModInverse(q, p)
+	return dp, dq, qi
+}
+
+// rsaMaxRecoverBits is the largest modulus we'll try to factor from d; it's openssl's limit on RSA moduli.
+// Each attempt costs modular exponentiations by a number as long as n, and the keys come from untrusted JWKs (eg DPoP and ACME jwk headers).
+const rsaMaxRecoverBits = 16384
+
+// rsaRecoverPrimes factors n, given the private exponent, as per NIST SP 800-56B rev 2 appendix C.
+// It only works for two-prime keys, which is fine as multi-prime keys must come with their primes.
+// Returns the primes with the larger first, as openssl does.
+func rsaRecoverPrimes(n *big.Int, e int, d *big.Int) (*big.Int, *big.Int, error) {
+	one := big.NewInt(1)
+	nMinus1 := new(big.Int).Sub(n, one)
+
+	// Bound the work before doing any: d has to be in (0, n) anyway, and this stops a huge d (or n) costing minutes of CPU
+	if d.Sign() <= 0 || d.Cmp(n) >= 0 {
+		return nil, nil, fmt.Errorf("must be between 0 and n")
+	}
+	if n.BitLen() > rsaMaxRecoverBits {
+		return nil, nil, fmt.Errorf("can't recover the primes of a modulus over %d bits", rsaMaxRecoverBits)
+	}
+
+	// k = de - 1 is a multiple of λ(n), so is even. Write it as 2^t * r, r odd
+	k := new(big.Int).Mul(d, big.NewInt(int64(e)))
+	k.Sub(k, one)
+	if k.Sign() <= 0 || k.Bit(0) != 0 {
+		return nil, nil, fmt.Errorf("d*e - 1 isn't even")
+	}
+	t := k.TrailingZeroBits()
+	r := new(big.Int).Rsh(k, t)
+
+	// For random g, g^r has a good chance of leading to a non-trivial square root of 1 mod n, which gives a factor.
+	// Rather than random, just try small g in turn, so the result's deterministic.
+	y, x := new(big.Int), new(big.Int)
+	for g := int64(2); g < 100; g++ {
+		y.Exp(big.NewInt(g), r, n)
+		if y.Cmp(one) == 0 || y.Cmp(nMinus1) == 0 {
+			continue
+		}
+		for i := uint(1); i <= t; i++ {
+			x.Exp(y, big.NewInt(2), n)
+			if x.Cmp(one) == 0 {
+				// y is a non-trivial square root of 1
+				p := new(big.Int).GCD(nil, nil, new(big.Int).Sub(y, one), n)
+				q := new(big.Int).Div(n, p)
+				if p.Cmp(q) < 0 {
+					p, q = q, p
+				}
+				return p, q, nil
+			}
+			if x.Cmp(nMinus1) == 0 {
+				break
+			}
+			if i == t {
+				// x = g^(de-1) isn't 1, so de-1 isn't a multiple of λ(n); no point trying another g
+				return nil, nil, fmt.Errorf("can't factor n; d doesn't correspond to n and e")
+			}
+			y.Set(x)
+		}
+	}
+	return nil, nil, fmt.Errorf("can't factor n; d doesn't correspond to n and e")
+}
+
+// rsaOtherPrimeValues computes the "oth" CRT values for the third and subsequent primes.
+// rsa.PrivateKey does have these, in Precomputed.CRTValues, but that's deprecated and we'd rather not call Precompute on the caller's key.
+func rsaOtherPrimeValues(k *rsa.PrivateKey) (ds, ts []*big.Int) {
+	if len(k.Primes) <= 2 {
+		return nil, nil
+	}
+	product := new(big.Int).Mul(k.Primes[0], k.Primes[1])
+	for _, r := range k.Primes[2:] {
+		rMinus1 := new(big.Int).Sub(r, big.NewInt(1))
+		ds = append(ds, new(big.Int).Mod(k.D, rMinus1))
+		ts = append(ts, new(big.Int).ModInverse(product, r)) // nil if the primes aren't coprime, which Validate will catch
+		product.Mul(product, r)
+	}
+	return ds, ts
 }
 
 // TODO: would be nice to more closely specify the return type, but not possible since they're structs?
-func parseRsaKey(data []byte) (any, error) {
+func parseRsaKey(data []byte, o UnmarshalOptions) (any, error) {
 	pubFields := rsaPublicKeyFields{}
 	err := json.Unmarshal(data, &pubFields)
 	if err != nil {
@@ -88,23 +230,32 @@ func parseRsaKey(data []byte) (any, error) {
 	}
 
 	if pubFields.KeyType != "RSA" {
-		return nil, fmt.Errorf("key type must be RSA, not %s", pubFields.KeyType)
+		return nil, &InvalidParameterError{Member: "kty", Err: fmt.Errorf("must be RSA, not %s", pubFields.KeyType)}
 	}
-	if !strings.HasPrefix(pubFields.Algorithm, "RS") {
-		return nil, fmt.Errorf("unknown algorithm %s; must start 'RS'", pubFields.Algorithm)
+	// alg is optional (RFC 7517 §4.4), and eg DPoP's jwk headers never have one.
+	// PSxxx keys come back as plain RSA keys; the alg is kept in the JWK
+	if pubFields.Algorithm != "" && !strings.HasPrefix(pubFields.Algorithm, "RS") && !strings.HasPrefix(pubFields.Algorithm, "PS") {
+		return nil, &InvalidParameterError{Member: "alg", Err: fmt.Errorf("unknown algorithm %s; must start 'RS' or 'PS'", pubFields.Algorithm)}
 	}
 
-	eBytes, err := base64.RawURLEncoding.DecodeString(pubFields.E)
+	eBytes, err := base64Member("e", pubFields.E)
 	if err != nil {
 		return nil, err
 	}
+	if len(eBytes) > 8 {
+		return nil, &InvalidParameterError{Member: "e", Err: fmt.Errorf("too large")}
+	}
 	eBuf := make([]byte, 8) // will be zero-filled
 	//copy(eBuf[8-len(eBytes):], eBytes) - even though these numbers are allegedly big-endian, we have to put this at the start of the memory
 	copy(eBuf[:], eBytes)
 	e := binary.LittleEndian.Uint64(eBuf[:])
 
+	n, err := base64toBigInt("n", pubFields.N)
+	if err != nil {
+		return nil, err
+	}
 	pubKey := rsa.PublicKey{
-		N: base64toBigInt(pubFields.N),
+		N: n,
 		E: int(e),
 	}
 
@@ -124,21 +275,103 @@ func parseRsaKey(data []byte) (any, error) {
 			return nil, err
 		}
 
+		d, err := base64toBigInt("d", privFields.D)
+		if err != nil {
+			return nil, err
+		}
+		var p, q *big.Int
+		if privFields.P == "" && privFields.Q == "" && len(privFields.Oth) == 0 {
+			// Minimal private key (RFC 7518 §6.3.2); Go needs the primes, so work them out.
+			// Unless the caller's going to refuse it for being private anyway, or has asked us not to do the sums; then it comes back without them
+			if o.RejectPrivate || o.SkipConsistencyChecks {
+				if d.Sign() <= 0 || d.Cmp(n) >= 0 {
+					return nil, &InvalidParameterError{Member: "d", Err: fmt.Errorf("must be between 0 and n")}
+				}
+				return &rsa.PrivateKey{PublicKey: pubKey, D: d}, nil
+			}
+			p, q, err = rsaRecoverPrimes(n, int(e), d)
+			if err != nil {
+				return nil, &InvalidParameterError{Member: "d", Err: err}
+			}
+		} else {
+			p, err = base64toBigInt("p", privFields.P)
+			if err != nil {
+				return nil, err
+			}
+			q, err = base64toBigInt("q", privFields.Q)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		primes := []*big.Int{p, q}
+		for i, other := range privFields.Oth {
+			r, err := base64toBigInt(fmt.Sprintf("oth[%d].r", i), other.R)
+			if err != nil {
+				return nil, err
+			}
+			primes = append(primes, r)
+		}
+
 		privKey := &rsa.PrivateKey{
 			PublicKey: pubKey,
-			D:         base64toBigInt(privFields.D),
-			Primes: []*big.Int{
-				base64toBigInt(privFields.P),
-				base64toBigInt(privFields.Q),
-			},
-			// Precomputed: although we render the (public) pre-computed values (qv), we ignore any that are present in keys we ingest, and call .Precompute() like we're meant to. Note we couldn't deserialise properly anyway because there's private fields in rsa.PrivateKey
+			D:         d,
+			Primes:    primes,
+			// Precomputed: although we render the pre-computed values (qv), we don't use any that are present in keys we ingest; we call .Precompute() like we're meant to, then check theirs match. Note we couldn't deserialise properly anyway because there's private fields in rsa.PrivateKey
 		}
 		privKey.Precompute()
 
+		if !o.SkipConsistencyChecks {
+			crt := map[string]string{"dp": privFields.Dp, "dq": privFields.Dq, "qi": privFields.Qinv}
+			supplied := map[string]*big.Int{}
+			for member, val := range crt {
+				if val == "" {
+					continue
+				}
+				supplied[member], err = base64toBigInt(member, val)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for i, other := range privFields.Oth {
+				for member, val := range map[string]string{fmt.Sprintf("oth[%d].d", i): other.D, fmt.Sprintf("oth[%d].t", i): other.T} {
+					supplied[member], err = base64toBigInt(member, val)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+			if err := checkRsaPrivateKey(privKey, supplied); err != nil {
+				return nil, err
+			}
+		}
+
 		return privKey, nil
 	}
 }
 
+// checkRsaPrivateKey makes sure the private parameters actually go together, and with the public key.
+// crt holds any pre-computed values that came with the key, by JWK member name; they're checked against the ones we computed.
+func checkRsaPrivateKey(k *rsa.PrivateKey, crt map[string]*big.Int) error {
+	if err := k.Validate(); err != nil {
+		return &InvalidParameterError{Member: "d", Err: fmt.Errorf("private key inconsistent: %w", err)}
+	}
+	computed := map[string]*big.Int{"dp": k.Precomputed.Dp, "dq": k.Precomputed.Dq, "qi": k.Precomputed.Qinv}
+	members := []string{"dp", "dq", "qi"} // ordered, for deterministic errors
+	ds, ts := rsaOtherPrimeValues(k)
+	for i := range ds {
+		dMember, tMember := fmt.Sprintf("oth[%d].d", i), fmt.Sprintf("oth[%d].t", i)
+		computed[dMember], computed[tMember] = ds[i], ts[i]
+		members = append(members, dMember, tMember)
+	}
+	for _, member := range members {
+		if v, ok := crt[member]; ok && v.Cmp(computed[member]) != 0 {
+			return &InvalidParameterError{Member: member, Err: fmt.Errorf("doesn't match the key's primes")}
+		}
+	}
+	return nil
+}
+
 func determineLenE(e int) uint {
 	// https://www.ibm.com/docs/en/linux-on-systems?topic=formats-rsa-public-key-token
 	if e == 3 || e == 5 || e == 17 {
@@ -155,11 +388,10 @@ func determineLenE(e int) uint {
 // ===
 
 type ecdsaPublicKeyFields struct {
-	KeyID   string `json:"kid,omitempty"`
-	KeyType string `json:"kty"`
-	Curve   string `json:"crv"`
-	X       string `json:"x"`
-	Y       string `json:"y"`
+	commonFields
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
 }
 
 type ecdsaPrivateKeyFields struct {
@@ -167,30 +399,36 @@ type ecdsaPrivateKeyFields struct {
 	D string `json:"d"`
 }
 
-func renderEcdsaPublicKey(k *ecdsa.PublicKey, kid string) ([]byte, error) {
+func renderEcdsaPublicKey(k *ecdsa.PublicKey, common commonFields) ([]byte, error) {
+	common.KeyType = "EC"
 	return json.Marshal(&ecdsaPublicKeyFields{
-		KeyID:   kid,
-		KeyType: "EC",
-		Curve:   k.Curve.Params().Name,
-		X:       base64.RawURLEncoding.EncodeToString(k.X.Bytes()),
-		Y:       base64.RawURLEncoding.EncodeToString(k.Y.Bytes()),
+		commonFields: common,
+		Curve:        k.Curve.Params().Name,
+		X:            ecdsaFieldToBase64(k.Curve, k.X),
+		Y:            ecdsaFieldToBase64(k.Curve, k.Y),
 	})
 }
 
-func renderEcdsaPrivateKey(k *ecdsa.PrivateKey, kid string) ([]byte, error) {
+func renderEcdsaPrivateKey(k *ecdsa.PrivateKey, common commonFields) ([]byte, error) {
+	common.KeyType = "EC"
 	return json.Marshal(&ecdsaPrivateKeyFields{
 		ecdsaPublicKeyFields{
-			KeyID:   kid,
-			KeyType: "EC",
-			Curve:   k.Curve.Params().Name,
-			X:       base64.RawURLEncoding.EncodeToString(k.X.Bytes()),
-			Y:       base64.RawURLEncoding.EncodeToString(k.Y.Bytes()),
+			commonFields: common,
+			Curve:        k.Curve.Params().Name,
+			X:            ecdsaFieldToBase64(k.Curve, k.X),
+			Y:            ecdsaFieldToBase64(k.Curve, k.Y),
 		},
-		base64.RawURLEncoding.EncodeToString(k.D.Bytes()),
+		ecdsaFieldToBase64(k.Curve, k.D),
 	})
 }
 
-func parseEcdsaKey(data []byte) (any, error) {
+// RFC 7518 §6.2.1.2: coordinates (and d) MUST be the full size of the field, leading zeros and all, which big.Int.Bytes() would strip. This matters for thumbprints.
+func ecdsaFieldToBase64(c elliptic.Curve, i *big.Int) string {
+	byteLen := (c.Params().BitSize + 7) / 8
+	return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, byteLen)))
+}
+
+func parseEcdsaKey(data []byte, o UnmarshalOptions) (any, error) {
 	pubFields := ecdsaPublicKeyFields{}
 	err := json.Unmarshal(data, &pubFields)
 	if err != nil {
@@ -198,7 +436,7 @@ func parseEcdsaKey(data []byte) (any, error) {
 	}
 
 	if pubFields.KeyType != "EC" {
-		return nil, fmt.Errorf("key type must be EC, not %s", pubFields.KeyType)
+		return nil, &InvalidParameterError{Member: "kty", Err: fmt.Errorf("must be EC, not %s", pubFields.KeyType)}
 	}
 
 	pubKey := ecdsa.PublicKey{}
@@ -211,12 +449,22 @@ func parseEcdsaKey(data []byte) (any, error) {
 		pubKey.Curve = elliptic.P384()
 	case "P-521":
 		pubKey.Curve = elliptic.P521()
+	case "secp256k1":
+		pubKey.Curve = secp256k1.S256()
+	case "":
+		return nil, missingParameter("crv")
 	default:
-		return nil, fmt.Errorf("unknown Curve %s", pubFields.Curve)
+		return nil, &UnsupportedCurveError{Curve: pubFields.Curve}
 	}
 
-	pubKey.X = base64toBigInt(pubFields.X)
-	pubKey.Y = base64toBigInt(pubFields.Y)
+	pubKey.X, err = base64toBigInt("x", pubFields.X)
+	if err != nil {
+		return nil, err
+	}
+	pubKey.Y, err = base64toBigInt("y", pubFields.Y)
+	if err != nil {
+		return nil, err
+	}
 
 	privCheck := &struct {
 		D string `json:"d,omitempty"` // Private exponent
@@ -235,16 +483,231 @@ func parseEcdsaKey(data []byte) (any, error) {
 			return nil, err
 		}
 
+		d, err := base64toBigInt("d", privFields.D)
+		if err != nil {
+			return nil, err
+		}
+
 		privKey := &ecdsa.PrivateKey{
 			PublicKey: pubKey,
-			D:         base64toBigInt(privFields.D),
+			D:         d,
+		}
+
+		if !o.SkipConsistencyChecks {
+			if err := checkEcdsaPrivateKey(privKey); err != nil {
+				return nil, err
+			}
 		}
 
 		return privKey, nil
 	}
 }
 
-func base64toBigInt(data string) *big.Int {
-	bytes, _ := base64.RawURLEncoding.DecodeString(data)
-	return new(big.Int).SetBytes(bytes)
+// crypto/ecdh keys on the NIST curves are EC JWKs too, for ECDH-ES (RFC 7518 §4.6).
+// crypto/ecdh doesn't expose coordinates, so we go via crypto/ecdsa keys, which the EC code already handles.
+
+func ecdhEllipticCurve(c ecdh.Curve) (elliptic.Curve, bool) {
+	switch c {
+	case ecdh.P256():
+		return elliptic.P256(), true
+	case ecdh.P384():
+		return elliptic.P384(), true
+	case ecdh.P521():
+		return elliptic.P521(), true
+	default:
+		return nil, false // X25519
+	}
+}
+
+// ECDH keys are for key agreement, so say so unless the user's said something else
+func ecdhCommonFields(common commonFields) commonFields {
+	if common.Use == "" {
+		common.Use = "enc"
+	}
+	return common
+}
+
+func ecdhPublicKeyToEcdsa(k *ecdh.PublicKey) (*ecdsa.PublicKey, error) {
+	c, ok := ecdhEllipticCurve(k.Curve())
+	if !ok {
+		return nil, &UnsupportedKeyTypeError{KeyType: "x25519", Format: "JWK"}
+	}
+	// Uncompressed SEC 1 point: 0x04 || x || y
+	point := k.Bytes()
+	byteLen := (len(point) - 1) / 2
+	return &ecdsa.PublicKey{
+		Curve: c,
+		X:     new(big.Int).SetBytes(point[1 : 1+byteLen]),
+		Y:     new(big.Int).SetBytes(point[1+byteLen:]),
+	}, nil
+}
+
+func ecdhPrivateKeyToEcdsa(k *ecdh.PrivateKey) (*ecdsa.PrivateKey, error) {
+	pub, err := ecdhPublicKeyToEcdsa(k.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	return &ecdsa.PrivateKey{PublicKey: *pub, D: new(big.Int).SetBytes(k.Bytes())}, nil
+}
+
+// ecdsaKeyToEcdh converts the output of parseEcdsaKey. Curves crypto/ecdh doesn't have (P-224, secp256k1) are an UnsupportedCurveError.
+func ecdsaKeyToEcdh(k any) (any, error) {
+	var out any
+	var err error
+	var c elliptic.Curve
+	switch typedKey := k.(type) {
+	case *ecdsa.PublicKey:
+		c = typedKey.Curve
+		out, err = typedKey.ECDH()
+	case *ecdsa.PrivateKey:
+		c = typedKey.Curve
+		out, err = typedKey.ECDH()
+	default:
+		return nil, &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("%T", k), Format: "crypto/ecdh"}
+	}
+	if err != nil {
+		return nil, &UnsupportedCurveError{Curve: c.Params().Name, Format: "crypto/ecdh"}
+	}
+	return out, nil
+}
+
+// checkEcdsaPrivateKey makes sure d is in range and that (x, y) really is d*G
+func checkEcdsaPrivateKey(k *ecdsa.PrivateKey) error {
+	params := k.Curve.Params()
+	if k.D.Sign() <= 0 || k.D.Cmp(params.N) >= 0 {
+		return &InvalidParameterError{Member: "d", Err: fmt.Errorf("out of range for curve %s", params.Name)}
+	}
+	if !k.Curve.IsOnCurve(k.X, k.Y) {
+		return &InvalidParameterError{Member: "x", Err: fmt.Errorf("(x, y) is not on curve %s", params.Name)}
+	}
+	x, y := k.Curve.ScalarBaseMult(k.D.FillBytes(make([]byte, (params.BitSize+7)/8)))
+	if x.Cmp(k.X) != 0 || y.Cmp(k.Y) != 0 {
+		return &InvalidParameterError{Member: "d", Err: fmt.Errorf("private key inconsistent: public point (x, y) isn't d*G")}
+	}
+	return nil
+}
+
+// ===
+// Impl for OKP (RFC 8037). Ed25519, Ed448, and X448; X25519 as yet isn't
+// ===
+
+type okpPublicKeyFields struct {
+	commonFields
+	Curve string `json:"crv"`
+	X     string `json:"x"` // Public key
+}
+
+type okpPrivateKeyFields struct {
+	okpPublicKeyFields
+	D string `json:"d,omitempty"` // Private key
+}
+
+// renderOkpKey renders the public key x, and the private key d if it's not nil
+func renderOkpKey(crv string, x, d []byte, common commonFields) ([]byte, error) {
+	common.KeyType = "OKP"
+	pub := okpPublicKeyFields{
+		commonFields: common,
+		Curve:        crv,
+		X:            base64.RawURLEncoding.EncodeToString(x),
+	}
+	if d == nil {
+		return json.Marshal(&pub)
+	}
+	return json.Marshal(&okpPrivateKeyFields{pub, base64.RawURLEncoding.EncodeToString(d)})
+}
+
+func parseOkpKey(data []byte, o UnmarshalOptions) (any, error) {
+	fields := okpPrivateKeyFields{}
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	if fields.KeyType != "OKP" {
+		return nil, &InvalidParameterError{Member: "kty", Err: fmt.Errorf("must be OKP, not %s", fields.KeyType)}
+	}
+
+	var size int
+	var derive func([]byte) ([]byte, error)
+	switch fields.Curve {
+	case "Ed25519":
+		size, derive = ed25519.PublicKeySize, ed25519PublicKey
+	case "Ed448":
+		size, derive = Ed448KeySize, curve448.Ed448PublicKey
+	case "X448":
+		size, derive = X448KeySize, curve448.X448PublicKey
+	case "":
+		return nil, missingParameter("crv")
+	default:
+		return nil, &UnsupportedCurveError{Curve: fields.Curve}
+	}
+
+	x, err := base64Member("x", fields.X)
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != size {
+		return nil, &InvalidParameterError{Member: "x", Err: fmt.Errorf("must be %d bytes for %s, not %d", size, fields.Curve, len(x))}
+	}
+
+	if fields.D == "" {
+		switch fields.Curve {
+		case "Ed25519":
+			return ed25519.PublicKey(x), nil
+		case "Ed448":
+			return Ed448PublicKey(x), nil
+		}
+		return X448PublicKey(x), nil
+	}
+
+	d, err := base64Member("d", fields.D)
+	if err != nil {
+		return nil, err
+	}
+	if len(d) != size {
+		return nil, &InvalidParameterError{Member: "d", Err: fmt.Errorf("must be %d bytes for %s, not %d", size, fields.Curve, len(d))}
+	}
+	if !o.SkipConsistencyChecks {
+		derived, err := derive(d)
+		if err != nil {
+			return nil, &InvalidParameterError{Member: "d", Err: err}
+		}
+		if !bytes.Equal(derived, x) {
+			return nil, &InvalidParameterError{Member: "d", Err: fmt.Errorf("private key inconsistent: public key x isn't derived from d")}
+		}
+	}
+
+	priv := append(d, x...)
+	switch fields.Curve {
+	case "Ed25519":
+		return ed25519.PrivateKey(priv), nil
+	case "Ed448":
+		return Ed448PrivateKey(priv), nil
+	}
+	return X448PrivateKey(priv), nil
+}
+
+// ed25519PublicKey derives the public key from a seed, like curve448.Ed448PublicKey
+func ed25519PublicKey(seed []byte) ([]byte, error) {
+	return ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey), nil
+}
+
+// base64Member decodes the base64url value of the named, required, member
+func base64Member(member, data string) ([]byte, error) {
+	if data == "" {
+		return nil, missingParameter(member)
+	}
+	bytes, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, &InvalidParameterError{Member: member, Err: err}
+	}
+	return bytes, nil
+}
+
+func base64toBigInt(member, data string) (*big.Int, error) {
+	bytes, err := base64Member(member, data)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bytes), nil
 }
@@ -1,8 +1,20 @@
 package jwks
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -36,18 +48,274 @@ func TestPublicKeyTypes(t *testing.T) {
 	require.NoError(t, err)
 
 	_, err = PEM2JWK([]byte(ed25519PubPEM))
-	require.ErrorContains(t, err, "does not support Ed25519")
+	require.NoError(t, err)
 
 	_, err = PEM2JWK([]byte(x25519PubPEM))
 	require.ErrorContains(t, err, "does not support x25519")
 
 	_, err = PEM2JWK([]byte(ed448PubPEM))
-	require.ErrorContains(t, err, "DER block does not encode a recognised cryptographic object")
+	require.NoError(t, err)
 
 	_, err = PEM2JWK([]byte(x448PubPEM))
+	require.NoError(t, err)
+
+	_, err = PEM2JWK([]byte(brainpoolPubPEM))
 	require.ErrorContains(t, err, "DER block does not encode a recognised cryptographic object")
 }
 
 func TestRsaPublicKeyUnmarshal(t *testing.T) {
 	// have the key as pem-encoded, parse it in, compare to text source
 }
+
+func TestEcdsaFieldPadding(t *testing.T) {
+	// x and y are the full size of the field. They used to be rendered from big.Int.Bytes(), which strips leading zeros, so a coordinate with a leading zero byte (1 key in 256 for P-256, half of them for P-521) came out short, giving the wrong thumbprint.
+	cases := []struct {
+		curve elliptic.Curve
+		size  int
+	}{
+		{elliptic.P256(), 32},
+		{elliptic.P384(), 48},
+		{elliptic.P521(), 66},
+	}
+	for _, cse := range cases {
+		var key *ecdsa.PrivateKey
+		for key == nil || key.X.BitLen() > (cse.size-1)*8 {
+			var err error
+			key, err = ecdsa.GenerateKey(cse.curve, rand.Reader)
+			require.NoError(t, err)
+		}
+
+		j, err := Key2JWK(&key.PublicKey)
+		require.NoError(t, err)
+		fields := map[string]string{}
+		require.NoError(t, json.Unmarshal([]byte(j), &fields))
+		for _, f := range []string{"x", "y"} {
+			bs, err := base64.RawURLEncoding.DecodeString(fields[f])
+			require.NoError(t, err)
+			require.Len(t, bs, cse.size, "%s %s", cse.curve.Params().Name, f)
+		}
+
+		back, err := JWK2Key([]byte(j))
+		require.NoError(t, err)
+		require.True(t, key.PublicKey.Equal(back))
+	}
+}
+
+func TestPrivateKeyConsistency(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	otherRsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherEcKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	randomBytes := func(n int) []byte {
+		b := make([]byte, n)
+		_, err := rand.Read(b)
+		require.NoError(t, err)
+		return b
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, otherEd25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ed448Key, err := NewEd448PrivateKey(randomBytes(Ed448KeySize))
+	require.NoError(t, err)
+	otherEd448Key, err := NewEd448PrivateKey(randomBytes(Ed448KeySize))
+	require.NoError(t, err)
+	x448Key, err := NewX448PrivateKey(randomBytes(X448KeySize))
+	require.NoError(t, err)
+	otherX448Key, err := NewX448PrivateKey(randomBytes(X448KeySize))
+	require.NoError(t, err)
+
+	// render key, with member swapped for the one from other
+	spliced := func(key, other any, member string) []byte {
+		members := func(k any) map[string]any {
+			bs, err := MarshalOptions{AllowPrivate: true}.Marshal(&JWK{Key: k})
+			require.NoError(t, err)
+			m := map[string]any{}
+			require.NoError(t, json.Unmarshal(bs, &m))
+			return m
+		}
+		m := members(key)
+		m[member] = members(other)[member]
+		bs, err := json.Marshal(m)
+		require.NoError(t, err)
+		return bs
+	}
+
+	cases := []struct {
+		key, other any
+		member     string
+	}{
+		{rsaKey, otherRsaKey, "d"},
+		{rsaKey, otherRsaKey, "p"},
+		{rsaKey, otherRsaKey, "dp"},
+		{rsaKey, otherRsaKey, "dq"},
+		{rsaKey, otherRsaKey, "qi"},
+		{ecKey, otherEcKey, "d"},
+		{ecKey, otherEcKey, "x"},
+		{ed25519Key, otherEd25519Key, "d"},
+		{ed448Key, otherEd448Key, "d"},
+		{x448Key, otherX448Key, "x"},
+	}
+	for _, cse := range cases {
+		bs := spliced(cse.key, cse.other, cse.member)
+
+		_, err := JWK2Key(bs)
+		require.ErrorIs(t, err, ErrInvalidParameter, "key with mismatched %s should be rejected", cse.member)
+
+		k, err := UnmarshalOptions{SkipConsistencyChecks: true}.UnmarshalKey(bs)
+		require.NoError(t, err, "consistency checks should be skippable")
+		require.NotNil(t, k.Key)
+	}
+
+	// Sanity: unmodified keys are fine
+	for _, key := range []any{rsaKey, ecKey, ed25519Key, ed448Key, x448Key} {
+		bs := spliced(key, key, "d")
+		_, err := JWK2Key(bs)
+		require.NoError(t, err)
+	}
+}
+
+func TestMultiPrimeRsa(t *testing.T) {
+	mp := privates[len(privates)-1]
+	key, err := JWK2Key([]byte(mp.jwks[len(`{"keys":[`) : len(mp.jwks)-len(`]}`)]))
+	require.NoError(t, err)
+	require.Len(t, key.(*rsa.PrivateKey).Primes, 3)
+
+	m := map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(mp.jwks), &m))
+	jwk := m["keys"].([]any)[0].(map[string]any)
+	oth := jwk["oth"].([]any)[0].(map[string]any)
+	oth["t"] = oth["d"]
+	bs, err := json.Marshal(jwk)
+	require.NoError(t, err)
+	_, err = JWK2Key(bs)
+	require.ErrorIs(t, err, ErrInvalidParameter)
+	require.ErrorContains(t, err, "oth[0].t")
+}
+
+func TestMinimalRsa(t *testing.T) {
+	full := privates[0]
+	set, err := PEM2JWKSMarshaler(full.pem)
+	require.NoError(t, err)
+
+	minimal, err := MarshalOptions{AllowPrivate: true, MinimalRSA: true}.Marshal(set)
+	require.NoError(t, err)
+	require.NotContains(t, string(minimal), `"p":`)
+	require.NotContains(t, string(minimal), `"dp":`)
+	require.Contains(t, string(minimal), `"d":`)
+
+	// Recovering the primes from n, e, d should get us back to the full key
+	back := &JWKS{}
+	require.NoError(t, json.Unmarshal(minimal, back))
+	rendered, err := MarshalOptions{AllowPrivate: true}.Marshal(back)
+	require.NoError(t, err)
+	require.Equal(t, full.jwks, string(rendered))
+
+	// A d that doesn't belong to n can't be used to factor it
+	m := map[string]any{}
+	require.NoError(t, json.Unmarshal(minimal, &m))
+	jwk := m["keys"].([]any)[0].(map[string]any)
+	jwk["d"] = jwk["n"]
+	bs, err := json.Marshal(jwk)
+	require.NoError(t, err)
+	_, err = JWK2Key(bs)
+	require.ErrorIs(t, err, ErrInvalidParameter)
+
+	// An enormous d is refused before any sums are done with it
+	jwk["d"] = base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte{0xff}, 25000)) // 200,000 bits
+	bs, err = json.Marshal(jwk)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = JWK2Key(bs)
+	require.ErrorIs(t, err, ErrInvalidParameter)
+	require.Less(t, time.Since(start), time.Second)
+
+	// As is a wrong one, after one go
+	jwk["d"] = base64.RawURLEncoding.EncodeToString(big.NewInt(65537).Bytes())
+	bs, err = json.Marshal(jwk)
+	require.NoError(t, err)
+	_, err = JWK2Key(bs)
+	require.ErrorIs(t, err, ErrInvalidParameter)
+
+	// Without the consistency checks, or when it's going to be refused anyway, the key isn't factored at all
+	k, err := UnmarshalOptions{SkipConsistencyChecks: true}.UnmarshalKey(bs)
+	require.NoError(t, err)
+	require.Empty(t, k.Key.(*rsa.PrivateKey).Primes)
+	_, err = UnmarshalOptions{RejectPrivate: true}.UnmarshalKey(bs)
+	require.ErrorIs(t, err, ErrPrivateKey)
+
+	// Multi-prime keys can't be factored from d, so are rendered in full regardless
+	mp, err := rsa.GenerateMultiPrimeKey(rand.Reader, 3, 1024)
+	require.NoError(t, err)
+	rendered, err = MarshalOptions{AllowPrivate: true, MinimalRSA: true}.Marshal(&JWKS{Keys: []*JWK{{Key: mp}}})
+	require.NoError(t, err)
+	require.Contains(t, string(rendered), `"oth":`)
+	back = &JWKS{}
+	require.NoError(t, json.Unmarshal(rendered, back))
+	require.True(t, mp.Equal(back.Keys[0].Key))
+}
+
+// caCert makes a self-signed CA certificate for key
+func caCert(t *testing.T, key *ecdsa.PrivateKey) *x509.Certificate {
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestX5C(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	cert := caCert(t, key)
+
+	j := &JWK{KeyID: "ca", Key: &key.PublicKey, Certificates: []*x509.Certificate{cert}}
+	bs, err := json.Marshal(j)
+	require.NoError(t, err)
+	require.Contains(t, string(bs), `"x5c":["`+base64.StdEncoding.EncodeToString(cert.Raw)+`"]`)
+
+	got := &JWK{}
+	require.NoError(t, json.Unmarshal(bs, got))
+	require.Len(t, got.Certificates, 1)
+	require.True(t, cert.Equal(got.Certificates[0]))
+
+	got, err = UnmarshalOptions{ECDH: true}.UnmarshalKey(bs)
+	require.NoError(t, err)
+	require.Len(t, got.Certificates, 1)
+
+	// The leaf must be for the key
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	bs, err = json.Marshal(&JWK{Key: &other.PublicKey, Certificates: []*x509.Certificate{cert}})
+	require.NoError(t, err)
+	err = json.Unmarshal(bs, &JWK{})
+	require.ErrorIs(t, err, ErrInvalidParameter)
+	require.ErrorContains(t, err, "isn't for this key")
+
+	// x5c is standard base64, not base64url
+	err = json.Unmarshal([]byte(`{"kty":"EC","crv":"P-256","x":"sQQ9AIYMbDafWOjCZnQghRQ_ZoY7g5T5JELrQ3C92Fs","y":"Bi_dWOfEF8QMnxcrQCU41tKU9dK8RbatSwNTGflCpQ4","x5c":["a_b-"]}`), &JWK{})
+	require.ErrorIs(t, err, ErrInvalidParameter)
+}
+
+func TestJWKRefusesPrivate(t *testing.T) {
+	j, err := PEM2JWKMarshaler(privates[0].pem)
+	require.NoError(t, err)
+	_, err = json.Marshal(j)
+	require.ErrorIs(t, err, ErrPrivateKey)
+	bs, err := MarshalOptions{AllowPrivate: true}.Marshal(j)
+	require.NoError(t, err)
+	require.Contains(t, string(bs), `"d"`)
+}
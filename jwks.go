@@ -46,20 +46,91 @@ func JWKS2PEM(j []byte) ([]byte, error) {
 // crypto.Key -> JSON / Marshaler
 // ===
 
-/* JWK implements [Un]MarshalJSON, it'd be nice if this type did too
+/* JWKS implements [Un]MarshalJSON, like JWK does
 * - for symmetry
 * - to allow people to store these structs in json.[Un]Marshaler interface objects
-*   - Note that although json.[Un]Marshal() will take one of these fine, as it takes an arg of type `any` and reflects over the members, it doesn't actually fullfil the json.[Un]Marshaler iface
-* However, I can't figure out a way to do it without either infinite recursion, or another intermediate "rendering" type
+* - to validate the set as a whole, eg that "keys" is present and kids don't clash
+* The recursion is avoided with an intermediate "rendering" type that has the same fields but none of the methods
  */
 
+type jwksFields struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// MarshalJSON renders the set, failing if any kids clash (see JWKS.Add). An empty set is rendered as `{"keys":[]}`.
+// Since sets are usually for publishing, this refuses to render private keys, returning a PrivateKeyError listing them. Use MarshalOptions to allow them, or PublicOnly to strip them.
+// Value receiver, so that a JWKS embedded by value in another struct is still rendered through here.
+func (s JWKS) MarshalJSON() ([]byte, error) {
+	return s.marshal(MarshalOptions{})
+}
+
+// marshal renders the set per the key-related options in o; indentation etc is left to the caller
+func (s JWKS) marshal(o MarshalOptions) ([]byte, error) {
+	rendered, err := s.renderKeys(o)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Keys []json.RawMessage `json:"keys"`
+	}{rendered})
+}
+
+// renderKeys renders each of the set's keys, for documents that have a keys array among other members
+func (s JWKS) renderKeys(o MarshalOptions) ([]json.RawMessage, error) {
+	keys := []*JWK{}
+	rendered := []json.RawMessage{}
+	var privIDs []string
+	for i, k := range s.Keys {
+		if k == nil {
+			return nil, &KeyError{Index: i, Err: fmt.Errorf("nil key")}
+		}
+		for _, prev := range keys {
+			if keysClash(prev, k) {
+				return nil, &KeyError{Index: i, KeyID: k.KeyID, Err: &DuplicateKeyIDError{KeyID: k.KeyID}}
+			}
+		}
+		if !o.AllowPrivate {
+			priv, err := KeyIsPrivateErr(k.Key)
+			if err != nil {
+				return nil, &KeyError{Index: i, KeyID: k.KeyID, Err: err}
+			}
+			if priv {
+				privIDs = append(privIDs, keyLabel(k, i))
+			}
+		}
+		bs, err := k.render(o)
+		if err != nil {
+			return nil, &KeyError{Index: i, KeyID: k.KeyID, Err: err}
+		}
+		keys = append(keys, k)
+		rendered = append(rendered, bs)
+	}
+	if len(privIDs) != 0 {
+		return nil, &PrivateKeyError{KeyIDs: privIDs}
+	}
+	return rendered, nil
+}
+
+// PublicOnly returns a copy of the set with every key replaced by its public part. Metadata is kept.
+func (s *JWKS) PublicOnly() (*JWKS, error) {
+	out := &JWKS{Keys: make([]*JWK, 0, len(s.Keys))}
+	for i, k := range s.Keys {
+		pub, err := k.PublicOnly()
+		if err != nil {
+			return nil, &KeyError{Index: i, KeyID: k.KeyID, Err: err}
+		}
+		out.Keys = append(out.Keys, pub)
+	}
+	return out, nil
+}
+
 func Keys2JWKSMarshaler(ks []any) (*JWKS, error) {
 	js := new(JWKS)
 
 	for i, k := range ks {
 		printable, err := Key2JWKMarshaler(k)
 		if err != nil {
-			return nil, fmt.Errorf("error in key %d: %w", i, err)
+			return nil, &KeyError{Index: i, Err: err}
 		}
 
 		js.Keys = append(js.Keys, printable)
@@ -76,7 +147,30 @@ func Keys2JWKS(ks []any) (string, error) {
 // JSON -> crypto.Key / Unmarshaler
 // ===
 
-// Unmarshaler is implicit
+// UnmarshalJSON parses a set, with the default UnmarshalOptions: "keys" must be present and an array, all keys must be valid, and kids mustn't clash.
+func (s *JWKS) UnmarshalJSON(data []byte) error {
+	js, _, err := UnmarshalOptions{}.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	*s = *js
+	return nil
+}
+
+// PublicJWKS is a JWKS that refuses to unmarshal private keys.
+// It's intended for embedding in config structs and the like, where only public keys should ever appear.
+type PublicJWKS struct {
+	JWKS
+}
+
+func (s *PublicJWKS) UnmarshalJSON(data []byte) error {
+	js, _, err := UnmarshalOptions{RejectPrivate: true}.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	s.JWKS = *js
+	return nil
+}
 
 func JWKS2Keys(j []byte) ([]any, error) {
 	ks := &JWKS{}
@@ -99,10 +193,41 @@ func JWKS2KeysMap(j []byte) (map[string]any, error) {
 		return nil, err
 	}
 
+	return keysMap(ks.Keys), nil
+}
+
+// JWKS2KeysLenient is like JWKS2Keys, but skips any keys that can't be parsed (eg unsupported types), rather than failing the whole set.
+// Each skipped key is described by a diagnostic.
+func JWKS2KeysLenient(j []byte) ([]any, []*KeyError, error) {
+	ks, diags, err := UnmarshalOptions{SkipInvalid: true}.Unmarshal(j)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := []any{}
+	for _, k := range ks.Keys {
+		out = append(out, k.Key)
+	}
+
+	return out, diags, nil
+}
+
+// JWKS2KeysMapLenient is like JWKS2KeysMap, but skips any keys that can't be parsed (eg unsupported types), rather than failing the whole set.
+// Each skipped key is described by a diagnostic.
+func JWKS2KeysMapLenient(j []byte) (map[string]any, []*KeyError, error) {
+	ks, diags, err := UnmarshalOptions{SkipInvalid: true}.Unmarshal(j)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return keysMap(ks.Keys), diags, nil
+}
+
+func keysMap(ks []*JWK) map[string]any {
 	// kid is optional, so generate one as necessary to avoid clashing map keys
 	autoKid := 0
 	ksm := map[string]any{}
-	for _, k := range ks.Keys {
+	for _, k := range ks {
 		kid := k.KeyID
 		if kid == "" {
 			kid = strconv.Itoa(autoKid)
@@ -111,7 +236,7 @@ func JWKS2KeysMap(j []byte) (map[string]any, error) {
 		ksm[kid] = k.Key
 	}
 
-	return ksm, nil
+	return ksm
 }
 
 // ===
@@ -121,7 +246,7 @@ func JWKS2KeysMap(j []byte) (map[string]any, error) {
 func PEM2Keys(p []byte) ([]any, error) {
 	ders, err := parsePEM(p)
 	if err != nil {
-		return nil, fmt.Errorf("can't decode input as PEM: %w", err)
+		return nil, err
 	}
 
 	keys := []any{}
@@ -129,7 +254,7 @@ func PEM2Keys(p []byte) ([]any, error) {
 	for i, der := range ders {
 		key, err := parseDER(der)
 		if err != nil {
-			return nil, fmt.Errorf("error in PEM block %d: %w", i, err)
+			return nil, &PEMError{Block: i, Err: err}
 		}
 
 		keys = append(keys, key)
@@ -148,11 +273,15 @@ func Keys2PEM(ks []any) ([]byte, error) {
 	for i, k := range ks {
 		der, err := renderDER(k)
 		if err != nil {
-			return nil, fmt.Errorf("error in key %d: %w", i, err)
+			return nil, &KeyError{Index: i, Err: err}
 		}
 
+		priv, err := KeyIsPrivateErr(k)
+		if err != nil {
+			return nil, &KeyError{Index: i, Err: err}
+		}
 		blockTitle := "PUBLIC KEY"
-		if KeyIsPrivate(k) {
+		if priv {
 			// Because we encode all priv keys as pkcs8 (even ecdsa, for which this isn't the openssl default), this string is always correct. If we used openssl's default SEC1 for ecdsa, this would need to be "EC PRIVATE KEY"
 			blockTitle = "PRIVATE KEY"
 		}
@@ -0,0 +1,95 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing JWSs whose header carries the signer's own public key, as DPoP proofs and ACME requests do.
+
+type jwsSigner struct {
+	key    any // what the jwt.SigningMethod wants
+	method jwt.SigningMethod
+	jwk    *JWK // public, with its alg set to method's
+}
+
+// newJWSSigner works out how to sign with key, a private key or a *JWK holding one.
+// The alg is the JWK's, if it has one; else ESxxx for EC keys' curves, EdDSA for Ed25519 keys, and for RSA keys, their PSS restrictions, else RS256.
+// format names the user of the signatures, for errors.
+func newJWSSigner(key any, format string) (*jwsSigner, error) {
+	j, ok := key.(*JWK)
+	if !ok {
+		var err error
+		j, err = Key2JWKMarshaler(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if priv, err := KeyIsPrivateErr(j.Key); err != nil {
+		return nil, err
+	} else if !priv {
+		return nil, fmt.Errorf("%s signatures need a private key, not %T", format, j.Key)
+	}
+
+	alg := j.Algorithm
+	signingKey := j.Key
+	switch typedKey := j.Key.(type) {
+	case *ecdsa.PrivateKey:
+		if alg == "" {
+			alg, ok = ecdsaCurveAlgorithm(typedKey.Curve)
+			if !ok {
+				return nil, &UnsupportedCurveError{Curve: typedKey.Curve.Params().Name, Format: format}
+			}
+		}
+	case ed25519.PrivateKey:
+		if alg == "" {
+			alg = "EdDSA"
+		}
+	case *rsa.PrivateKey:
+		if alg == "" {
+			alg = "RS256"
+		}
+	case *RSAPSSPrivateKey:
+		if alg == "" {
+			alg, ok = typedKey.Params.JWSAlgorithm()
+			if !ok {
+				return nil, &UnsupportedKeyTypeError{KeyType: "RSA-PSS with restrictions no JWS alg satisfies", Format: format}
+			}
+		}
+		signingKey = typedKey.PrivateKey
+	default:
+		return nil, &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("%T", j.Key), Format: format}
+	}
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, &InvalidParameterError{Member: "alg", Err: fmt.Errorf("unknown algorithm %s", alg)}
+	}
+
+	// Just the key, and the alg we sign with. The kid, use, etc are none of the verifier's business.
+	pub, err := KeyPublicPartErr(j.Key)
+	if err != nil {
+		return nil, err
+	}
+	header, err := Key2JWKMarshaler(pub)
+	if err != nil {
+		return nil, err
+	}
+	header.Algorithm = alg
+
+	return &jwsSigner{key: signingKey, method: method, jwk: header}, nil
+}
+
+// thumbprint is the base64url SHA-256 thumbprint of the signer's public key
+func (s *jwsSigner) thumbprint() (string, error) {
+	tp, err := s.jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(tp), nil
+}
@@ -49,3 +49,11 @@ MEIwBQYDK2VvAzkA52YLupe7atbM+D1vK/HzjC+cXncm8BSNtnEA8cABbujanGXh
 +wmafc7Epg1rW9dHr9gIk8MfQNU=
 -----END PUBLIC KEY-----
 `
+
+// Neither the stdlib nor we know the Brainpool curves
+var brainpoolPubPEM = `
+-----BEGIN PUBLIC KEY-----
+MFowFAYHKoZIzj0CAQYJKyQDAwIIAQEHA0IABJwMx/gNh52PMkWReWMKNG9dGQro
+BbEvYFjunHS9Se3sivcMXyv7k/snTBGyCK9hHjmb28WT61cRELHCePVWKBI=
+-----END PUBLIC KEY-----
+`
@@ -0,0 +1,142 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Kubernetes service account issuer discovery (KEP-1393): kube-apiserver publishes the public parts of its service account signing keys as a JWKS at /openid/v1/jwks, and an OIDC discovery document pointing at it.
+// These reproduce both exactly, eg to host them somewhere public for a cluster whose API server isn't.
+
+const (
+	KubernetesJWKSPath      = "/openid/v1/jwks"
+	KubernetesDiscoveryPath = "/.well-known/openid-configuration"
+)
+
+// KubernetesKeyID is the kid Kubernetes gives a service account key: the base64url SHA-256 of its PKIX DER public key.
+// Private keys get the kid of their public part.
+func KubernetesKeyID(key any) (string, error) {
+	pub, err := KeyPublicPartErr(key)
+	if err != nil {
+		return "", err
+	}
+	if _, err := kubernetesAlgorithm(pub); err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// kubernetesAlgorithm is the alg Kubernetes publishes a key with; it only supports RSA, and ECDSA on the NIST curves
+func kubernetesAlgorithm(pub any) (string, error) {
+	switch typedKey := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		alg, ok := ecdsaCurveAlgorithm(typedKey.Curve)
+		if !ok || alg == "ES256K" {
+			return "", &UnsupportedCurveError{Curve: typedKey.Curve.Params().Name, Format: "Kubernetes"}
+		}
+		return alg, nil
+	default:
+		return "", &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("%T", pub), Format: "Kubernetes"}
+	}
+}
+
+// Keys2KubernetesJWKS builds the JWKS kube-apiserver would serve for the given service account keys: their public parts, with Kubernetes kids, use "sig", and an alg.
+// Keys given more than once (eg as both a private and a public key) appear once.
+func Keys2KubernetesJWKS(ks []any) (*JWKS, error) {
+	set := &JWKS{Keys: []*JWK{}}
+	for i, k := range ks {
+		pub, err := KeyPublicPartErr(k)
+		if err != nil {
+			return nil, &KeyError{Index: i, Err: err}
+		}
+		alg, err := kubernetesAlgorithm(pub)
+		if err != nil {
+			return nil, &KeyError{Index: i, Err: err}
+		}
+		kid, err := KubernetesKeyID(pub)
+		if err != nil {
+			return nil, &KeyError{Index: i, Err: err}
+		}
+		if set.KeyByID(kid) != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, &JWK{KeyID: kid, Key: pub, Use: "sig", Algorithm: alg})
+	}
+	return set, nil
+}
+
+// PEM2KubernetesJWKS is Keys2KubernetesJWKS for the keys in a PEM, eg the concatenation of the files given to --service-account-key-file.
+// Certificates are accepted, as kube-apiserver does.
+func PEM2KubernetesJWKS(p []byte) (*JWKS, error) {
+	ks, err := PEM2Keys(p)
+	if err != nil {
+		return nil, err
+	}
+	return Keys2KubernetesJWKS(ks)
+}
+
+// KubernetesDiscovery is the OIDC discovery document kube-apiserver serves
+type KubernetesDiscovery struct {
+	Issuer            string   `json:"issuer"`
+	JWKSURI           string   `json:"jwks_uri"`
+	ResponseTypes     []string `json:"response_types_supported"`
+	SubjectTypes      []string `json:"subject_types_supported"`
+	SigningAlgorithms []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// NewKubernetesDiscovery builds the discovery document for the given --service-account-issuer, and the set from Keys2KubernetesJWKS.
+// jwksURI is --service-account-jwks-uri; if it's empty, the set is taken to be hosted alongside the discovery document, at the issuer plus KubernetesJWKSPath.
+// Both URLs must be https, as Kubernetes requires.
+func NewKubernetesDiscovery(issuer, jwksURI string, set *JWKS) (*KubernetesDiscovery, error) {
+	if err := requireHTTPS(issuer); err != nil {
+		return nil, fmt.Errorf("issuer: %w", err)
+	}
+	if jwksURI == "" {
+		jwksURI = strings.TrimSuffix(issuer, "/") + KubernetesJWKSPath
+	}
+	if err := requireHTTPS(jwksURI); err != nil {
+		return nil, fmt.Errorf("JWKS URI: %w", err)
+	}
+
+	// kube-apiserver lists each alg once, sorted
+	algs := []string{}
+	for _, k := range set.Keys {
+		if k.Algorithm != "" && !slices.Contains(algs, k.Algorithm) {
+			algs = append(algs, k.Algorithm)
+		}
+	}
+	slices.Sort(algs)
+
+	return &KubernetesDiscovery{
+		Issuer:            issuer,
+		JWKSURI:           jwksURI,
+		ResponseTypes:     []string{"id_token"},
+		SubjectTypes:      []string{"public"},
+		SigningAlgorithms: algs,
+	}, nil
+}
+
+func requireHTTPS(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("%q isn't an https URL", u)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mt-inside/go-jwks/internal/secp256k1"
+)
+
+func TestKubernetesKeyID(t *testing.T) {
+	// Expected values from `openssl pkey -pubin -outform DER | openssl dgst -sha256 -binary | basenc --base64url`
+	cases := []struct {
+		pem string
+		kid string
+	}{
+		{rsaPubPEM, "hzznF49-sTs6cwN8GAekcrc5f4755-eCgqIkj31vTLc"},
+		{ecdsaPubPEM, "u5Ul0cT75B4Qa4tmLpi7gRkcvyjmmq4kE4S5GFKgh8Y"},
+	}
+	for _, cse := range cases {
+		keys, err := PEM2Keys([]byte(cse.pem))
+		require.NoError(t, err)
+		kid, err := KubernetesKeyID(keys[0])
+		require.NoError(t, err)
+		require.Equal(t, cse.kid, kid)
+	}
+
+	// A private key has the kid of its public part
+	priv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	kidPriv, err := KubernetesKeyID(priv)
+	require.NoError(t, err)
+	kidPub, err := KubernetesKeyID(&priv.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, kidPub, kidPriv)
+
+	// Kubernetes only does RSA and NIST ECDSA
+	keys, err := PEM2Keys([]byte(ed25519PubPEM))
+	require.NoError(t, err)
+	_, err = KubernetesKeyID(keys[0])
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+	k256, err := ecdsa.GenerateKey(secp256k1.S256(), rand.Reader)
+	require.NoError(t, err)
+	_, err = KubernetesKeyID(k256)
+	require.ErrorIs(t, err, ErrUnsupportedCurve)
+}
+
+func TestKubernetesJWKS(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	privPEM, err := Keys2PEM([]any{priv})
+	require.NoError(t, err)
+	pubPEM, err := Keys2PEM([]any{&priv.PublicKey})
+	require.NoError(t, err)
+
+	// The signing key and its public part are the same key
+	set, err := PEM2KubernetesJWKS([]byte(rsaPubPEM + string(privPEM) + string(pubPEM)))
+	require.NoError(t, err)
+	require.Len(t, set.Keys, 2)
+	require.Equal(t, "hzznF49-sTs6cwN8GAekcrc5f4755-eCgqIkj31vTLc", set.Keys[0].KeyID)
+	require.Equal(t, "RS256", set.Keys[0].Algorithm)
+	require.Equal(t, "sig", set.Keys[0].Use)
+	require.Equal(t, "ES256", set.Keys[1].Algorithm)
+
+	bs, err := json.Marshal(set)
+	require.NoError(t, err)
+	require.NotContains(t, string(bs), `"d"`)
+
+	disco, err := NewKubernetesDiscovery("https://oidc.example.com/cluster-1/", "", set)
+	require.NoError(t, err)
+	bs, err = json.Marshal(disco)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"issuer": "https://oidc.example.com/cluster-1/",
+		"jwks_uri": "https://oidc.example.com/cluster-1/openid/v1/jwks",
+		"response_types_supported": ["id_token"],
+		"subject_types_supported": ["public"],
+		"id_token_signing_alg_values_supported": ["ES256", "RS256"]
+	}`, string(bs))
+
+	disco, err = NewKubernetesDiscovery("https://kubernetes.default.svc", "https://storage.example.com/jwks.json", set)
+	require.NoError(t, err)
+	require.Equal(t, "https://storage.example.com/jwks.json", disco.JWKSURI)
+
+	_, err = NewKubernetesDiscovery("http://oidc.example.com", "", set)
+	require.ErrorContains(t, err, "isn't an https URL")
+	_, err = NewKubernetesDiscovery("https://oidc.example.com", "http://oidc.example.com/jwks", set)
+	require.ErrorContains(t, err, "JWKS URI")
+}
@@ -0,0 +1,235 @@
+package jwks
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config for proxies that verify JWTs: Istio RequestAuthentication manifests, and Envoy jwt_authn filter config.
+
+// JWTProvider is an issuer of JWTs that a proxy should verify
+type JWTProvider struct {
+	// Name identifies the provider in Envoy config. Defaults to the Issuer.
+	Name   string
+	Issuer string
+	// Audiences, if set, are the audiences the token must be for (any one of)
+	Audiences []string
+	// Keys are put inline in the config. They must be public. Exactly one of Keys and KeysURI must be set.
+	Keys *JWKS
+	// KeysURI is where the proxy should fetch the keys from
+	KeysURI string
+	// ForwardOriginalToken keeps the token in the request sent upstream; by default it's removed
+	ForwardOriginalToken bool
+	// OutputPayloadToHeader, if set, is a header to send the token's (base64url) payload upstream in, so it can read the claims without parsing the token
+	OutputPayloadToHeader string
+	// EnvoyCluster is the Envoy cluster that reaches KeysURI's host. Envoy needs it to fetch the keys; Istio works it out itself.
+	EnvoyCluster string
+}
+
+// inlineKeys renders the provider's keys for embedding in config, checking that exactly one of Keys and KeysURI is set
+func (p *JWTProvider) inlineKeys() (string, error) {
+	if p.Issuer == "" {
+		return "", fmt.Errorf("provider has no issuer")
+	}
+	if (p.Keys == nil) == (p.KeysURI == "") {
+		return "", fmt.Errorf("provider %s needs exactly one of keys and a keys URI", p.Issuer)
+	}
+	if p.Keys == nil {
+		return "", nil
+	}
+	// indented, so it renders as a readable YAML block
+	bs, err := MarshalOptions{Indent: "  "}.Marshal(p.Keys)
+	if err != nil {
+		return "", err
+	}
+	return string(bs) + "\n", nil
+}
+
+func renderYAML(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ===
+// Istio
+// ===
+
+type istioRequestAuthentication struct {
+	APIVersion string      `yaml:"apiVersion"`
+	Kind       string      `yaml:"kind"`
+	Metadata   istioMeta   `yaml:"metadata"`
+	Spec       istioRASpec `yaml:"spec"`
+}
+
+type istioMeta struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type istioRASpec struct {
+	Selector *istioSelector `yaml:"selector,omitempty"`
+	JWTRules []istioJWTRule `yaml:"jwtRules"`
+}
+
+type istioSelector struct {
+	MatchLabels map[string]string `yaml:"matchLabels"`
+}
+
+type istioJWTRule struct {
+	Issuer                string   `yaml:"issuer"`
+	Audiences             []string `yaml:"audiences,omitempty"`
+	JWKSURI               string   `yaml:"jwksUri,omitempty"`
+	JWKS                  string   `yaml:"jwks,omitempty"`
+	ForwardOriginalToken  bool     `yaml:"forwardOriginalToken,omitempty"`
+	OutputPayloadToHeader string   `yaml:"outputPayloadToHeader,omitempty"`
+}
+
+// IstioRequestAuthentication renders a RequestAuthentication manifest that has Istio verify JWTs from the given providers.
+// matchLabels selects the workloads it applies to; if it's empty, it applies to the whole namespace (or mesh, in the root namespace).
+// namespace may be empty, to leave it to kubectl.
+// Note that RequestAuthentication only refuses invalid tokens; requiring a token needs an AuthorizationPolicy too.
+func IstioRequestAuthentication(name, namespace string, matchLabels map[string]string, providers ...*JWTProvider) ([]byte, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no providers")
+	}
+	ra := istioRequestAuthentication{
+		APIVersion: "security.istio.io/v1",
+		Kind:       "RequestAuthentication",
+		Metadata:   istioMeta{Name: name, Namespace: namespace},
+	}
+	if len(matchLabels) != 0 {
+		ra.Spec.Selector = &istioSelector{MatchLabels: matchLabels}
+	}
+	for _, p := range providers {
+		keys, err := p.inlineKeys()
+		if err != nil {
+			return nil, err
+		}
+		ra.Spec.JWTRules = append(ra.Spec.JWTRules, istioJWTRule{
+			Issuer:                p.Issuer,
+			Audiences:             p.Audiences,
+			JWKSURI:               p.KeysURI,
+			JWKS:                  keys,
+			ForwardOriginalToken:  p.ForwardOriginalToken,
+			OutputPayloadToHeader: p.OutputPayloadToHeader,
+		})
+	}
+	return renderYAML(ra)
+}
+
+// ===
+// Envoy
+// ===
+
+type envoyHTTPFilter struct {
+	Name        string             `yaml:"name"`
+	TypedConfig envoyJWTAuthConfig `yaml:"typed_config"`
+}
+
+type envoyJWTAuthConfig struct {
+	Type      string                   `yaml:"@type"`
+	Providers map[string]envoyProvider `yaml:"providers"`
+	Rules     []envoyRule              `yaml:"rules"`
+}
+
+type envoyProvider struct {
+	Issuer               string           `yaml:"issuer"`
+	Audiences            []string         `yaml:"audiences,omitempty"`
+	Forward              bool             `yaml:"forward,omitempty"`
+	ForwardPayloadHeader string           `yaml:"forward_payload_header,omitempty"`
+	LocalJWKS            *envoyLocalJWKS  `yaml:"local_jwks,omitempty"`
+	RemoteJWKS           *envoyRemoteJWKS `yaml:"remote_jwks,omitempty"`
+}
+
+type envoyLocalJWKS struct {
+	InlineString string `yaml:"inline_string"`
+}
+
+type envoyRemoteJWKS struct {
+	HTTPURI envoyHTTPURI `yaml:"http_uri"`
+}
+
+type envoyHTTPURI struct {
+	URI     string `yaml:"uri"`
+	Cluster string `yaml:"cluster"`
+	Timeout string `yaml:"timeout"`
+}
+
+type envoyRule struct {
+	Match    envoyMatch       `yaml:"match"`
+	Requires envoyRequirement `yaml:"requires"`
+}
+
+type envoyMatch struct {
+	Prefix string `yaml:"prefix"`
+}
+
+type envoyRequirement struct {
+	ProviderName string                `yaml:"provider_name,omitempty"`
+	RequiresAny  *envoyRequirementList `yaml:"requires_any,omitempty"`
+}
+
+type envoyRequirementList struct {
+	Requirements []envoyRequirement `yaml:"requirements"`
+}
+
+// EnvoyJWTAuthn renders an HTTP filter entry for Envoy's jwt_authn filter (envoy.extensions.filters.http.jwt_authn.v3.JwtAuthentication), for an http_filters list.
+// Every request must carry a valid token from one of the providers.
+func EnvoyJWTAuthn(providers ...*JWTProvider) ([]byte, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no providers")
+	}
+	cfg := envoyJWTAuthConfig{
+		Type:      "type.googleapis.com/envoy.extensions.filters.http.jwt_authn.v3.JwtAuthentication",
+		Providers: map[string]envoyProvider{},
+	}
+	var reqs []envoyRequirement
+	for _, p := range providers {
+		keys, err := p.inlineKeys()
+		if err != nil {
+			return nil, err
+		}
+		name := p.Name
+		if name == "" {
+			name = p.Issuer
+		}
+		if _, ok := cfg.Providers[name]; ok {
+			return nil, fmt.Errorf("duplicate provider name %s", name)
+		}
+
+		ep := envoyProvider{
+			Issuer:               p.Issuer,
+			Audiences:            p.Audiences,
+			Forward:              p.ForwardOriginalToken,
+			ForwardPayloadHeader: p.OutputPayloadToHeader,
+		}
+		if keys != "" {
+			ep.LocalJWKS = &envoyLocalJWKS{InlineString: keys}
+		} else {
+			if p.EnvoyCluster == "" {
+				return nil, fmt.Errorf("provider %s needs an Envoy cluster to fetch its keys through", name)
+			}
+			ep.RemoteJWKS = &envoyRemoteJWKS{HTTPURI: envoyHTTPURI{URI: p.KeysURI, Cluster: p.EnvoyCluster, Timeout: "5s"}}
+		}
+		cfg.Providers[name] = ep
+		reqs = append(reqs, envoyRequirement{ProviderName: name})
+	}
+
+	req := reqs[0]
+	if len(reqs) > 1 {
+		req = envoyRequirement{RequiresAny: &envoyRequirementList{Requirements: reqs}}
+	}
+	cfg.Rules = []envoyRule{{Match: envoyMatch{Prefix: "/"}, Requires: req}}
+
+	return renderYAML(envoyHTTPFilter{Name: "envoy.filters.http.jwt_authn", TypedConfig: cfg})
+}
@@ -0,0 +1,125 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestIstioRequestAuthentication(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	set := &JWKS{Keys: []*JWK{{KeyID: "k1", Key: &key.PublicKey}}}
+
+	bs, err := IstioRequestAuthentication("jwt-example", "default", map[string]string{"app": "http-log"},
+		&JWTProvider{Issuer: "example.local", Audiences: []string{"api"}, Keys: set, ForwardOriginalToken: true, OutputPayloadToHeader: "x-end-user"},
+		&JWTProvider{Issuer: "https://accounts.example.com", KeysURI: "https://accounts.example.com/jwks.json"},
+	)
+	require.NoError(t, err)
+
+	ra := struct {
+		APIVersion string `yaml:"apiVersion"`
+		Kind       string `yaml:"kind"`
+		Metadata   struct{ Name, Namespace string }
+		Spec       struct {
+			Selector struct {
+				MatchLabels map[string]string `yaml:"matchLabels"`
+			}
+			JWTRules []map[string]any `yaml:"jwtRules"`
+		}
+	}{}
+	require.NoError(t, yaml.Unmarshal(bs, &ra))
+	require.Equal(t, "RequestAuthentication", ra.Kind)
+	require.Equal(t, "jwt-example", ra.Metadata.Name)
+	require.Equal(t, "default", ra.Metadata.Namespace)
+	require.Equal(t, map[string]string{"app": "http-log"}, ra.Spec.Selector.MatchLabels)
+	require.Len(t, ra.Spec.JWTRules, 2)
+
+	inline := ra.Spec.JWTRules[0]
+	require.Equal(t, "example.local", inline["issuer"])
+	require.Equal(t, []any{"api"}, inline["audiences"])
+	require.Equal(t, true, inline["forwardOriginalToken"])
+	require.Equal(t, "x-end-user", inline["outputPayloadToHeader"])
+	got := &JWKS{}
+	require.NoError(t, json.Unmarshal([]byte(inline["jwks"].(string)), got))
+	require.Equal(t, "k1", got.Keys[0].KeyID)
+	require.True(t, key.PublicKey.Equal(got.Keys[0].Key))
+	require.Contains(t, string(bs), "jwks: |") // readable, rather than one long quoted line
+
+	require.Equal(t, map[string]any{"issuer": "https://accounts.example.com", "jwksUri": "https://accounts.example.com/jwks.json"}, ra.Spec.JWTRules[1])
+
+	// No selector means the whole namespace
+	bs, err = IstioRequestAuthentication("all", "", nil, &JWTProvider{Issuer: "i", KeysURI: "https://i/jwks"})
+	require.NoError(t, err)
+	require.NotContains(t, string(bs), "selector")
+	require.NotContains(t, string(bs), "namespace")
+
+	_, err = IstioRequestAuthentication("x", "", nil, &JWTProvider{Issuer: "i"})
+	require.ErrorContains(t, err, "exactly one of")
+	_, err = IstioRequestAuthentication("x", "", nil, &JWTProvider{Issuer: "i", Keys: set, KeysURI: "https://i/jwks"})
+	require.ErrorContains(t, err, "exactly one of")
+	_, err = IstioRequestAuthentication("x", "", nil, &JWTProvider{Issuer: "i", Keys: &JWKS{Keys: []*JWK{{Key: key}}}})
+	require.ErrorIs(t, err, ErrPrivateKey)
+}
+
+func TestEnvoyJWTAuthn(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	set := &JWKS{Keys: []*JWK{{KeyID: "k1", Key: &key.PublicKey}}}
+
+	type config struct {
+		Name        string
+		TypedConfig struct {
+			Type      string                    `yaml:"@type"`
+			Providers map[string]map[string]any `yaml:"providers"`
+			Rules     []map[string]any          `yaml:"rules"`
+		} `yaml:"typed_config"`
+	}
+
+	bs, err := EnvoyJWTAuthn(&JWTProvider{Issuer: "example.local", Keys: set, ForwardOriginalToken: true, OutputPayloadToHeader: "x-jwt-payload"})
+	require.NoError(t, err)
+	cfg := config{}
+	require.NoError(t, yaml.Unmarshal(bs, &cfg))
+	require.Equal(t, "envoy.filters.http.jwt_authn", cfg.Name)
+	require.Equal(t, "type.googleapis.com/envoy.extensions.filters.http.jwt_authn.v3.JwtAuthentication", cfg.TypedConfig.Type)
+	p := cfg.TypedConfig.Providers["example.local"]
+	require.Equal(t, "example.local", p["issuer"])
+	require.Equal(t, true, p["forward"])
+	require.Equal(t, "x-jwt-payload", p["forward_payload_header"])
+	got := &JWKS{}
+	require.NoError(t, json.Unmarshal([]byte(p["local_jwks"].(map[string]any)["inline_string"].(string)), got))
+	require.True(t, key.PublicKey.Equal(got.Keys[0].Key))
+	require.Equal(t, []map[string]any{{"match": map[string]any{"prefix": "/"}, "requires": map[string]any{"provider_name": "example.local"}}}, cfg.TypedConfig.Rules)
+
+	// Several providers, any of which will do
+	bs, err = EnvoyJWTAuthn(
+		&JWTProvider{Name: "local", Issuer: "example.local", Keys: set},
+		&JWTProvider{Name: "accounts", Issuer: "https://accounts.example.com", Audiences: []string{"api"}, KeysURI: "https://accounts.example.com/jwks.json", EnvoyCluster: "accounts"},
+	)
+	require.NoError(t, err)
+	cfg = config{}
+	require.NoError(t, yaml.Unmarshal(bs, &cfg))
+	require.Equal(t, map[string]any{
+		"issuer":    "https://accounts.example.com",
+		"audiences": []any{"api"},
+		"remote_jwks": map[string]any{"http_uri": map[string]any{
+			"uri":     "https://accounts.example.com/jwks.json",
+			"cluster": "accounts",
+			"timeout": "5s",
+		}},
+	}, cfg.TypedConfig.Providers["accounts"])
+	require.Equal(t, map[string]any{"requires_any": map[string]any{"requirements": []any{
+		map[string]any{"provider_name": "local"},
+		map[string]any{"provider_name": "accounts"},
+	}}}, cfg.TypedConfig.Rules[0]["requires"])
+
+	_, err = EnvoyJWTAuthn(&JWTProvider{Issuer: "i", KeysURI: "https://i/jwks"})
+	require.ErrorContains(t, err, "Envoy cluster")
+	_, err = EnvoyJWTAuthn(&JWTProvider{Issuer: "i", Keys: set}, &JWTProvider{Issuer: "i", Keys: set})
+	require.ErrorContains(t, err, "duplicate provider name i")
+}
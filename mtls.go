@@ -0,0 +1,112 @@
+package jwks
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Certificate-bound access tokens (RFC 8705 §3).
+// The token's cnf claim carries the "x5t#S256" thumbprint of the client certificate it was issued to, and it's only good over a mutual TLS connection made with that certificate.
+
+// CertificateThumbprint is the x5t#S256 of cert: the base64url SHA-256 of its DER
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// PEM2CertificateThumbprint is the x5t#S256 of the first block of p, which must be a certificate
+func PEM2CertificateThumbprint(p []byte) (string, error) {
+	ders, err := parsePEM(p)
+	if err != nil {
+		return "", err
+	}
+	if len(ders) == 0 {
+		return "", &PEMError{Block: -1, Err: fmt.Errorf("no PEM blocks")}
+	}
+	cert, err := x509.ParseCertificate(ders[0])
+	if err != nil {
+		return "", &PEMError{Block: 0, Err: err}
+	}
+	return CertificateThumbprint(cert), nil
+}
+
+// VerifyCertificateBinding checks that the cnf claim of claims binds the token to the client certificate of cs.
+// Failures wrap ErrCertificateBinding.
+func VerifyCertificateBinding(claims jwt.MapClaims, cs *tls.ConnectionState) error {
+	cnf, ok := claims["cnf"].(map[string]any)
+	if !ok {
+		return &wrappedError{sentinel: ErrCertificateBinding, Err: fmt.Errorf("token has no cnf claim")}
+	}
+	want, ok := cnf["x5t#S256"].(string)
+	if !ok || want == "" {
+		return &wrappedError{sentinel: ErrCertificateBinding, Err: fmt.Errorf("cnf has no x5t#S256")}
+	}
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		return &wrappedError{sentinel: ErrCertificateBinding, Err: fmt.Errorf("connection has no client certificate")}
+	}
+	got := CertificateThumbprint(cs.PeerCertificates[0])
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return &wrappedError{sentinel: ErrCertificateBinding, Err: fmt.Errorf("client certificate's thumbprint is %s, not %s", got, want)}
+	}
+	return nil
+}
+
+type claimsContextKey struct{}
+
+// ClaimsFromContext returns the claims of the token RequireCertificateBinding verified for this request
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(jwt.MapClaims)
+	return claims, ok
+}
+
+// RequireCertificateBinding is HTTP middleware for certificate-bound access tokens.
+// Requests must carry a bearer token that verifies against keys, and is bound to the client certificate they came over; others are refused with a 401.
+// The token's claims are available to next through ClaimsFromContext.
+// opts are passed to the jwt parser, eg to check the audience.
+// Invalid tokens get a bare invalid_token error, as the reason could tell the caller things they shouldn't know, like the thumbprint a token's bound to.
+// To find out the reason, eg to log it, use RequireCertificateBindingFunc.
+func RequireCertificateBinding(keys *JWKS, next http.Handler, opts ...jwt.ParserOption) http.Handler {
+	return RequireCertificateBindingFunc(keys, next, nil, opts...)
+}
+
+// RequireCertificateBindingFunc is RequireCertificateBinding, calling onRefuse (if it's not nil) with the reason each invalid token was refused
+func RequireCertificateBindingFunc(keys *JWKS, next http.Handler, onRefuse func(*http.Request, error), opts ...jwt.ParserOption) http.Handler {
+	parser := jwt.NewParser(opts...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refuse := func(err error) {
+			if onRefuse != nil {
+				onRefuse(r, err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid_token", http.StatusUnauthorized)
+		}
+
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "bearer token required", http.StatusUnauthorized)
+			return
+		}
+
+		claims := jwt.MapClaims{}
+		if _, err := parser.ParseWithClaims(token, claims, keys.Keyfunc); err != nil {
+			refuse(err)
+			return
+		}
+		if err := VerifyCertificateBinding(claims, r.TLS); err != nil {
+			refuse(err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, claims)))
+	})
+}
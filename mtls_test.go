@@ -0,0 +1,136 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func selfSignedCert(t *testing.T, cn string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestCertificateThumbprint(t *testing.T) {
+	cert := selfSignedCert(t, "client")
+	sum := sha256.Sum256(cert.Raw)
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:]), CertificateThumbprint(cert))
+
+	tp, err := PEM2CertificateThumbprint(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	require.NoError(t, err)
+	require.Equal(t, CertificateThumbprint(cert), tp)
+
+	_, err = PEM2CertificateThumbprint([]byte(ecdsaPubPEM))
+	require.ErrorIs(t, err, ErrMalformedPEM)
+}
+
+func TestVerifyCertificateBinding(t *testing.T) {
+	cert := selfSignedCert(t, "client")
+	other := selfSignedCert(t, "other")
+	bound := jwt.MapClaims{"cnf": map[string]any{"x5t#S256": CertificateThumbprint(cert)}}
+
+	require.NoError(t, VerifyCertificateBinding(bound, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}))
+
+	cases := []struct {
+		name   string
+		claims jwt.MapClaims
+		cs     *tls.ConnectionState
+	}{
+		{"other cert", bound, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{other}}},
+		{"no cert", bound, &tls.ConnectionState{}},
+		{"no tls", bound, nil},
+		{"no cnf", jwt.MapClaims{}, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		{"jkt cnf", jwt.MapClaims{"cnf": map[string]any{"jkt": "x"}}, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	}
+	for _, cse := range cases {
+		require.ErrorIs(t, VerifyCertificateBinding(cse.claims, cse.cs), ErrCertificateBinding, cse.name)
+	}
+}
+
+func TestRequireCertificateBinding(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keys := &JWKS{Keys: []*JWK{{KeyID: "issuer", Key: &key.PublicKey}}}
+	cert := selfSignedCert(t, "client")
+	other := selfSignedCert(t, "other")
+
+	var refusal error
+	h := RequireCertificateBindingFunc(keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		require.True(t, ok)
+		w.Write([]byte(claims["sub"].(string)))
+	}), func(_ *http.Request, err error) { refusal = err })
+
+	token := func(signer any, thumbprint string) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"sub": "alice", "cnf": map[string]any{"x5t#S256": thumbprint}})
+		tok.Header["kid"] = "issuer"
+		s, err := tok.SignedString(signer)
+		require.NoError(t, err)
+		return s
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name   string
+		auth   string
+		cert   *x509.Certificate
+		status int
+		reason string
+	}{
+		{"bound", "Bearer " + token(key, CertificateThumbprint(cert)), cert, http.StatusOK, ""},
+		{"other cert", "Bearer " + token(key, CertificateThumbprint(cert)), other, http.StatusUnauthorized, CertificateThumbprint(cert)},
+		{"no cert", "Bearer " + token(key, CertificateThumbprint(cert)), nil, http.StatusUnauthorized, "certificate"},
+		{"bad signature", "Bearer " + token(otherKey, CertificateThumbprint(cert)), cert, http.StatusUnauthorized, "signature"},
+		{"no token", "", cert, http.StatusUnauthorized, ""},
+	}
+	for _, cse := range cases {
+		refusal = nil
+		r := httptest.NewRequest("GET", "https://api.example.com/", nil)
+		if cse.auth != "" {
+			r.Header.Set("Authorization", cse.auth)
+		}
+		if cse.cert != nil {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cse.cert}}
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, cse.status, w.Code, cse.name)
+		if cse.status == http.StatusOK {
+			require.Equal(t, "alice", w.Body.String())
+		} else {
+			require.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+		}
+		if cse.reason != "" {
+			// The caller only gets told it's invalid; the detail goes to onRefuse
+			require.Equal(t, "invalid_token\n", w.Body.String(), cse.name)
+			require.ErrorContains(t, refusal, cse.reason, cse.name)
+		}
+	}
+}
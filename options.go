@@ -0,0 +1,256 @@
+package jwks
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/mt-inside/go-jwks/internal/jcs"
+)
+
+// KeyOrder says how the keys of a JWKS should be ordered on output
+type KeyOrder int
+
+const (
+	// KeyOrderNone leaves keys in the order they're held in
+	KeyOrderNone KeyOrder = iota
+	// KeyOrderKeyID sorts keys by kid. The order of keys with the same (or no) kid is preserved.
+	KeyOrderKeyID
+	// KeyOrderThumbprint sorts keys by their RFC 7638 SHA-256 thumbprint, which is stable even for keys without kids
+	KeyOrderThumbprint
+)
+
+// MarshalOptions controls the rendering of JWK[S] to JSON.
+// The zero value gives the same compact output as json.Marshal, and refuses to render private keys.
+//
+// Eg, for output that can be reproducibly hashed or signed:
+//
+//	MarshalOptions{Canonical: true, SortBy: KeyOrderThumbprint}.Marshal(set)
+type MarshalOptions struct {
+	// PublicOnly renders just the public part of every key, so private keys can be safely passed in
+	PublicOnly bool
+	// AllowPrivate allows private key parameters (d, p, etc) in the output. Without it, rendering a private key fails with a PrivateKeyError listing the offending keys.
+	AllowPrivate bool
+	// MinimalRSA renders private RSA keys with just n, e and d, leaving out p, q and the CRT values (RFC 7518 §6.3.2 allows this).
+	// Smaller, but slower for the consumer, which has to recover the primes. By default all parameters are rendered.
+	// It's ignored for multi-prime keys, whose primes can't be recovered from d.
+	MinimalRSA bool
+
+	// Indent, if set, pretty-prints the output, indenting each level by this string (eg "  ")
+	Indent string
+	// Canonical renders RFC 8785 JSON Canonicalization Scheme, ie members sorted and whitespace stripped. This can't be combined with Indent.
+	Canonical bool
+	// SortBy orders the keys of a set. It has no effect on other values.
+	SortBy KeyOrder
+}
+
+// Marshal renders v, which is usually a *JWK or *JWKS, though can be anything encoding/json can handle.
+// The private key options only apply to a top-level JWK[S]; any JWKS nested inside something else will refuse private keys.
+func (o MarshalOptions) Marshal(v any) ([]byte, error) {
+	if o.Canonical && o.Indent != "" {
+		return nil, fmt.Errorf("canonical output can't be indented")
+	}
+
+	var bs []byte
+	var err error
+	switch typed := v.(type) {
+	case *JWKS:
+		bs, err = o.marshalSet(typed)
+	case JWKS:
+		bs, err = o.marshalSet(&typed)
+	case *JWK:
+		bs, err = o.marshalKey(typed)
+	default:
+		bs, err = json.Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case o.Canonical:
+		return jcs.Transform(bs)
+	case o.Indent != "":
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, bs, "", o.Indent); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return bs, nil
+	}
+}
+
+func (o MarshalOptions) marshalSet(js *JWKS) ([]byte, error) {
+	var err error
+	if o.PublicOnly {
+		js, err = js.PublicOnly()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o.SortBy != KeyOrderNone {
+		js, err = sortKeys(js, o.SortBy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return js.marshal(o)
+}
+
+func (o MarshalOptions) marshalKey(k *JWK) ([]byte, error) {
+	k, err := o.publishableKey(k)
+	if err != nil {
+		return nil, err
+	}
+	return k.render(o)
+}
+
+// publishableKey applies PublicOnly and AllowPrivate to k
+func (o MarshalOptions) publishableKey(k *JWK) (*JWK, error) {
+	var err error
+	if o.PublicOnly {
+		k, err = k.PublicOnly()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !o.AllowPrivate {
+		priv, err := KeyIsPrivateErr(k.Key)
+		if err != nil {
+			return nil, err
+		}
+		if priv {
+			return nil, &PrivateKeyError{KeyIDs: []string{keyLabel(k, 0)}}
+		}
+	}
+	return k, nil
+}
+
+// sortKeys returns a sorted shallow copy, so the caller's set isn't reordered underneath them
+func sortKeys(js *JWKS, order KeyOrder) (*JWKS, error) {
+	sorted := &JWKS{Keys: append([]*JWK(nil), js.Keys...)}
+
+	switch order {
+	case KeyOrderKeyID:
+		sort.SliceStable(sorted.Keys, func(i, j int) bool { return sorted.Keys[i].KeyID < sorted.Keys[j].KeyID })
+	case KeyOrderThumbprint:
+		tps := make(map[*JWK]string, len(sorted.Keys))
+		for i, k := range sorted.Keys {
+			tp, err := k.Thumbprint(crypto.SHA256)
+			if err != nil {
+				return nil, &KeyError{Index: i, KeyID: k.KeyID, Err: err}
+			}
+			tps[k] = string(tp)
+		}
+		sort.SliceStable(sorted.Keys, func(i, j int) bool { return tps[sorted.Keys[i]] < tps[sorted.Keys[j]] })
+	default:
+		return nil, fmt.Errorf("unknown key order %d", order)
+	}
+
+	return sorted, nil
+}
+
+// UnmarshalOptions controls the parsing of JWKS documents.
+// The zero value is what json.Unmarshal into a JWKS does: "keys" must be present and an array, all keys must be valid, and kids mustn't clash (see JWKS.Add).
+type UnmarshalOptions struct {
+	// SkipInvalid drops keys that can't be parsed (eg an unsupported kty or curve, or bad parameters), clash with an earlier key, or are rejected for being private, rather than failing the whole set.
+	// Each dropped key is reported as a *KeyError diagnostic.
+	SkipInvalid bool
+	// RejectPrivate fails the set (or, with SkipInvalid, drops the key) if any key contains private parameters.
+	// The resulting PrivateKeyError lists all the offending keys.
+	// Minimal RSA private keys aren't factored first, as that's expensive and the key's going to be refused anyway.
+	RejectPrivate bool
+	// SkipConsistencyChecks turns off the checks that private keys' parameters match each other and the public key (eg that RSA p*q = n, or that an EC key's (x, y) = d*G).
+	// These cost a few modular exponentiations or a scalar multiplication per private key, so callers loading lots of trusted keys may want to skip them.
+	// It also stops the primes of a minimal RSA private key (just d) being recovered, so such keys come back with no Primes, which crypto/rsa can't sign with.
+	SkipConsistencyChecks bool
+	// ECDH produces crypto/ecdh keys for EC JWKs, rather than crypto/ecdsa ones, for use in key agreement (eg ECDH-ES).
+	// Keys on curves crypto/ecdh doesn't support (P-224, secp256k1) are an UnsupportedCurveError.
+	ECDH bool
+}
+
+// UnmarshalKey parses a single JWK
+func (o UnmarshalOptions) UnmarshalKey(j []byte) (*JWK, error) {
+	k := &JWK{}
+	if err := k.unmarshal(j, o); err != nil {
+		return nil, err
+	}
+	if o.RejectPrivate {
+		priv, err := KeyIsPrivateErr(k.Key)
+		if err != nil {
+			return nil, err
+		}
+		if priv {
+			return nil, &PrivateKeyError{KeyIDs: []string{keyLabel(k, 0)}}
+		}
+	}
+	return k, nil
+}
+
+// Unmarshal parses a JWKS document.
+// The returned diagnostics describe any keys that were skipped; they're only ever non-empty if SkipInvalid is set.
+func (o UnmarshalOptions) Unmarshal(j []byte) (*JWKS, []*KeyError, error) {
+	dec := NewDecoder(bytes.NewReader(j))
+	dec.ContinueOnError = o.SkipInvalid
+	dec.SkipConsistencyChecks = o.SkipConsistencyChecks
+	dec.ECDH = o.ECDH
+	dec.rejectPrivate = o.RejectPrivate
+
+	js := &JWKS{Keys: []*JWK{}}
+	var diags []*KeyError
+	var privIDs []string
+	for {
+		k, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var kErr *KeyError
+		if errors.As(err, &kErr) && o.SkipInvalid {
+			diags = append(diags, kErr)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		i := len(js.Keys) + len(diags) + len(privIDs)
+
+		if o.RejectPrivate {
+			priv, err := KeyIsPrivateErr(k.Key)
+			if err != nil {
+				return nil, nil, &KeyError{Index: i, KeyID: k.KeyID, Err: err}
+			}
+			if priv {
+				id := keyLabel(k, i)
+				if o.SkipInvalid {
+					diags = append(diags, &KeyError{Index: i, KeyID: k.KeyID, Err: &PrivateKeyError{KeyIDs: []string{id}}})
+				} else {
+					privIDs = append(privIDs, id)
+				}
+				continue
+			}
+		}
+
+		if slices.ContainsFunc(js.Keys, func(prev *JWK) bool { return keysClash(prev, k) }) {
+			kErr := &KeyError{Index: i, KeyID: k.KeyID, Err: &DuplicateKeyIDError{KeyID: k.KeyID}}
+			if !o.SkipInvalid {
+				return nil, nil, kErr
+			}
+			diags = append(diags, kErr)
+			continue
+		}
+
+		js.Keys = append(js.Keys, k)
+	}
+
+	if len(privIDs) != 0 {
+		return nil, nil, &PrivateKeyError{KeyIDs: privIDs}
+	}
+
+	return js, diags, nil
+}
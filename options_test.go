@@ -0,0 +1,163 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestThumbprint(t *testing.T) {
+	// RFC 7638 §3.1
+	j := &JWK{}
+	err := json.Unmarshal([]byte(`{"kty":"RSA","n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw","e":"AQAB","alg":"RS256","kid":"2011-04-29"}`), j)
+	require.NoError(t, err)
+
+	tp, err := j.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", base64.RawURLEncoding.EncodeToString(tp))
+
+	// Private keys have the same thumbprint as their public part
+	priv, err := PEM2JWKMarshaler(privates[1].pem)
+	require.NoError(t, err)
+	pubKey, err := KeyPublicPartErr(priv.Key)
+	require.NoError(t, err)
+	pub := &JWK{Key: pubKey}
+	privTp, err := priv.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	pubTp, err := pub.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, pubTp, privTp)
+}
+
+func TestMarshalOptions(t *testing.T) {
+	set, err := PEM2JWKSMarshaler(publics[3].pem)
+	require.NoError(t, err)
+	set.Keys[0].KeyID = "b"
+	set.Keys[1].KeyID = "a"
+
+	compact, err := MarshalOptions{}.Marshal(set)
+	require.NoError(t, err)
+	plain, err := json.Marshal(set)
+	require.NoError(t, err)
+	require.Equal(t, plain, compact)
+
+	pretty, err := MarshalOptions{Indent: "  "}.Marshal(set.Keys[1])
+	require.NoError(t, err)
+	require.Equal(t, `{
+  "kid": "a",
+  "kty": "EC",
+  "crv": "P-256",
+  "x": "sQQ9AIYMbDafWOjCZnQghRQ_ZoY7g5T5JELrQ3C92Fs",
+  "y": "Bi_dWOfEF8QMnxcrQCU41tKU9dK8RbatSwNTGflCpQ4"
+}`, string(pretty))
+
+	canonical, err := MarshalOptions{Canonical: true, SortBy: KeyOrderKeyID}.Marshal(set)
+	require.NoError(t, err)
+	require.Equal(t, `{"keys":[{"crv":"P-256","kid":"a","kty":"EC","x":"sQQ9AIYMbDafWOjCZnQghRQ_ZoY7g5T5JELrQ3C92Fs","y":"Bi_dWOfEF8QMnxcrQCU41tKU9dK8RbatSwNTGflCpQ4"},{"alg":"RS256","e":"AQAB","kid":"b","kty":"RSA","n":"8iGXpjwlnRJCVSaROlgQpPYGpCK4aMztJOPISheg_DiL1hZ0c0oqXSjeByHop0eCwJI64SIu8l-Q5bp-3ZYHE53JlaVdU6rMZUDKv1zZpKpVcPec8X6RilTz8EuSMOSsOVn5O6vi8FqXAjRvlJW0onOOLPhYDDfzQmz8TX65vAcoRKQ4HsSidL-lw56HRxBFeGWjqmJdxgtBqVWJWvoQ-6UUrdUqm6GLkiRjAEQHjLS7xduWbJH33tQXCBu7ScvPVEFZhqpV8OcP_xEgs1hYiYz_foMc8QveOhEo4k1nSX2mjW6CBViDY8HXy1fPlamGExmYpkTmxb09uJLdnUxjuQ"}]}`, string(canonical))
+	require.Equal(t, "b", set.Keys[0].KeyID, "Sorting shouldn't reorder the caller's set")
+
+	// Thumbprint order is independent of kids and input order
+	set.Keys[0], set.Keys[1] = set.Keys[1], set.Keys[0]
+	byTp1, err := MarshalOptions{SortBy: KeyOrderThumbprint}.Marshal(set)
+	require.NoError(t, err)
+	set.Keys[0], set.Keys[1] = set.Keys[1], set.Keys[0]
+	byTp2, err := MarshalOptions{SortBy: KeyOrderThumbprint}.Marshal(set)
+	require.NoError(t, err)
+	require.Equal(t, byTp1, byTp2)
+
+	_, err = MarshalOptions{Canonical: true, Indent: "\t"}.Marshal(set)
+	require.Error(t, err)
+}
+
+func TestPublicOnly(t *testing.T) {
+	set, err := PEM2JWKSMarshaler(mixeds[0].pem)
+	require.NoError(t, err)
+	for i, k := range set.Keys {
+		if i%2 == 0 {
+			k.KeyID = fmt.Sprintf("k%d", i)
+		}
+	}
+
+	_, err = MarshalOptions{}.Marshal(set)
+	var privErr *PrivateKeyError
+	require.ErrorAs(t, err, &privErr)
+	require.NotEmpty(t, privErr.KeyIDs)
+	_, err = json.Marshal(set)
+	require.ErrorIs(t, err, ErrPrivateKey, "JWKS.MarshalJSON should refuse private keys")
+	last := set.Keys[len(set.Keys)-1]
+	_, err = MarshalOptions{}.Marshal(last)
+	require.ErrorIs(t, err, ErrPrivateKey)
+
+	withPriv, err := MarshalOptions{AllowPrivate: true}.Marshal(set)
+	require.NoError(t, err)
+	require.Contains(t, string(withPriv), `"d":`)
+
+	pubOnly, err := MarshalOptions{PublicOnly: true}.Marshal(set)
+	require.NoError(t, err)
+	require.NotContains(t, string(pubOnly), `"d":`)
+
+	pubSet, err := set.PublicOnly()
+	require.NoError(t, err)
+	require.Len(t, pubSet.Keys, len(set.Keys))
+	for i, k := range pubSet.Keys {
+		priv, err := KeyIsPrivateErr(k.Key)
+		require.NoError(t, err)
+		require.False(t, priv)
+		require.Equal(t, set.Keys[i].KeyID, k.KeyID, "metadata should be kept")
+	}
+	bs, err := json.Marshal(pubSet)
+	require.NoError(t, err)
+	require.JSONEq(t, string(pubOnly), string(bs))
+}
+
+func TestECDH(t *testing.T) {
+	ecPriv := strings.TrimSuffix(strings.TrimPrefix(privates[1].jwks, `{"keys":[`), `]}`)
+
+	k, err := UnmarshalOptions{ECDH: true}.UnmarshalKey([]byte(ecPriv))
+	require.NoError(t, err)
+	priv, ok := k.Key.(*ecdh.PrivateKey)
+	require.True(t, ok, "expected *ecdh.PrivateKey, got %T", k.Key)
+	require.Equal(t, ecdh.P256(), priv.Curve())
+	require.Equal(t, "EC", k.KeyType())
+	require.Equal(t, "P-256", k.Curve())
+
+	// Rendered as the same EC JWK, marked for encryption
+	bs, err := MarshalOptions{AllowPrivate: true}.Marshal(k)
+	require.NoError(t, err)
+	require.Equal(t, strings.Replace(ecPriv, `"kty":"EC",`, `"kty":"EC","use":"enc",`, 1), string(bs))
+
+	pub, err := Key2JWK(priv.PublicKey())
+	require.NoError(t, err)
+	ecPub := strings.TrimSuffix(strings.TrimPrefix(publics[2].jwks, `{"keys":[`), `]}`)
+	require.Equal(t, strings.Replace(ecPub, `"kty":"EC",`, `"kty":"EC","use":"enc",`, 1), pub)
+
+	// Same thumbprint as the ecdsa key; use isn't a required member
+	ecdsaKey, err := JWK2Key([]byte(ecPriv))
+	require.NoError(t, err)
+	tp1, err := k.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	tp2, err := (&JWK{Key: ecdsaKey}).Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	require.Equal(t, tp2, tp1)
+
+	set, _, err := UnmarshalOptions{ECDH: true}.Unmarshal([]byte(publics[2].jwks))
+	require.NoError(t, err)
+	require.IsType(t, &ecdh.PublicKey{}, set.Keys[0].Key)
+
+	// crypto/ecdh doesn't do secp256k1
+	_, err = UnmarshalOptions{ECDH: true}.UnmarshalKey([]byte(`{"kty":"EC","crv":"secp256k1","x":"g6iY-fnWcoIO5sX5M6FEpzm4leUPNyff2Vh0yg2SNWU","y":"yf2U2nLShy01gpOxJ4y_tJulgIj66lSvRtcyYcH8ZTI"}`))
+	require.ErrorIs(t, err, ErrUnsupportedCurve)
+
+	// X25519 is still out
+	x, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = Key2JWK(x.PublicKey())
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+}
@@ -14,7 +14,7 @@ func parsePEM(in []byte) ([][]byte, error) {
 	for len(in) != 0 {
 		block, rest := pem.Decode(in)
 		if block == nil {
-			return nil, fmt.Errorf("input doesn't decode as PEM")
+			return nil, &PEMError{Block: len(blocks), Err: fmt.Errorf("input doesn't decode as PEM")}
 		}
 		blocks = append(blocks, block.Bytes)
 		in = rest
@@ -0,0 +1,286 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// RSA-PSS (RFC 4055) support.
+// These are RSA keys whose ASN.1 says id-RSASSA-PSS rather than rsaEncryption, ie they're only for PSS signatures, optionally with restrictions on the parameters of those signatures.
+// The stdlib's x509 package refuses them, so we do that ASN.1 ourselves, and wrap the stdlib's RSA types to carry the restrictions.
+//
+// A JWK can only express the restrictions through its alg, so they're rendered as RSA JWKs with alg PS256/384/512, and parsed back as plain *rsa.[Public,Private]Key.
+
+// RSAPSSParameters are the restrictions an RSA-PSS key places on its signatures (RFC 4055 §3.1)
+type RSAPSSParameters struct {
+	Hash          crypto.Hash // Message digest
+	MGF1Hash      crypto.Hash // Digest used by the mask generation function, MGF1
+	MinSaltLength int
+}
+
+// JWSAlgorithm returns the JWA alg (RFC 7518 §3.5) that satisfies these restrictions, if there is one.
+// PSxxx means SHA-xxx for both digests, and a salt the length of the digest.
+// A nil *RSAPSSParameters is no restrictions at all, for which we pick PS256.
+func (p *RSAPSSParameters) JWSAlgorithm() (string, bool) {
+	if p == nil {
+		return "PS256", true
+	}
+	if p.Hash != p.MGF1Hash || p.MinSaltLength > p.Hash.Size() {
+		return "", false
+	}
+	switch p.Hash {
+	case crypto.SHA256:
+		return "PS256", true
+	case crypto.SHA384:
+		return "PS384", true
+	case crypto.SHA512:
+		return "PS512", true
+	default:
+		return "", false
+	}
+}
+
+func (p *RSAPSSParameters) equal(x *RSAPSSParameters) bool {
+	if p == nil || x == nil {
+		return p == x
+	}
+	return *p == *x
+}
+
+// RSAPSSPublicKey is an RSA public key restricted to PSS signatures
+type RSAPSSPublicKey struct {
+	*rsa.PublicKey
+	Params *RSAPSSParameters // nil if the key has no restrictions beyond PSS
+}
+
+// RSAPSSPrivateKey is an RSA private key restricted to PSS signatures
+type RSAPSSPrivateKey struct {
+	*rsa.PrivateKey
+	Params *RSAPSSParameters // nil if the key has no restrictions beyond PSS
+}
+
+func (k *RSAPSSPublicKey) Equal(x crypto.PublicKey) bool {
+	xx, ok := x.(*RSAPSSPublicKey)
+	return ok && k.PublicKey.Equal(xx.PublicKey) && k.Params.equal(xx.Params)
+}
+
+func (k *RSAPSSPrivateKey) Public() crypto.PublicKey {
+	return &RSAPSSPublicKey{PublicKey: &k.PrivateKey.PublicKey, Params: k.Params}
+}
+func (k *RSAPSSPrivateKey) Equal(x crypto.PrivateKey) bool {
+	xx, ok := x.(*RSAPSSPrivateKey)
+	return ok && k.PrivateKey.Equal(xx.PrivateKey) && k.Params.equal(xx.Params)
+}
+
+// rsaPSSCommonFields sets the JWK alg from the key's restrictions, unless the user's set one
+func rsaPSSCommonFields(p *RSAPSSParameters, common commonFields) (commonFields, error) {
+	if common.Algorithm != "" {
+		return common, nil
+	}
+	alg, ok := p.JWSAlgorithm()
+	if !ok {
+		return common, &UnsupportedKeyTypeError{
+			KeyType: fmt.Sprintf("RSA-PSS with %v, MGF1 with %v, and salt length %d", p.Hash, p.MGF1Hash, p.MinSaltLength),
+			Format:  "JWK",
+		}
+	}
+	common.Algorithm = alg
+	return common, nil
+}
+
+// ===
+// ASN.1
+// ===
+
+var (
+	oidPublicKeyRSAPSS = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidMGF1            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+)
+
+var pssHashOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   {1, 3, 14, 3, 2, 26},
+	crypto.SHA224: {2, 16, 840, 1, 101, 3, 4, 2, 4},
+	crypto.SHA256: {2, 16, 840, 1, 101, 3, 4, 2, 1},
+	crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+	crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+}
+
+// RFC 4055 RSASSA-PSS-params. Absent members are the SHA-1 defaults.
+type pssParameters struct {
+	Hash         pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MGF          pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	SaltLength   int                      `asn1:"optional,explicit,tag:2,default:20"`
+	TrailerField int                      `asn1:"optional,explicit,tag:3,default:1"`
+}
+
+func pssHash(algo pkix.AlgorithmIdentifier) (crypto.Hash, error) {
+	if algo.Algorithm == nil {
+		return crypto.SHA1, nil
+	}
+	for h, oid := range pssHashOIDs {
+		if algo.Algorithm.Equal(oid) {
+			return h, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash algorithm %v", algo.Algorithm)
+}
+
+func pssHashAlgorithm(h crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	if h == crypto.SHA1 {
+		return pkix.AlgorithmIdentifier{}, nil // DEFAULT, so omitted
+	}
+	oid, ok := pssHashOIDs[h]
+	if !ok {
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("unsupported hash algorithm %v", h)
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}, nil
+}
+
+func parseRSAPSSParameters(algo pkix.AlgorithmIdentifier) (*RSAPSSParameters, error) {
+	if len(algo.Parameters.FullBytes) == 0 {
+		return nil, nil
+	}
+	var params pssParameters
+	if rest, err := asn1.Unmarshal(algo.Parameters.FullBytes, &params); err != nil {
+		return nil, &InvalidParameterError{Member: "RSASSA-PSS-params", Err: err}
+	} else if len(rest) != 0 {
+		return nil, &InvalidParameterError{Member: "RSASSA-PSS-params", Err: fmt.Errorf("trailing data")}
+	}
+
+	hash, err := pssHash(params.Hash)
+	if err != nil {
+		return nil, &InvalidParameterError{Member: "hashAlgorithm", Err: err}
+	}
+	mgfHash := crypto.SHA1
+	if params.MGF.Algorithm != nil {
+		if !params.MGF.Algorithm.Equal(oidMGF1) {
+			return nil, &InvalidParameterError{Member: "maskGenAlgorithm", Err: fmt.Errorf("unknown mask generation function %v", params.MGF.Algorithm)}
+		}
+		var mgfHashAlgo pkix.AlgorithmIdentifier
+		if _, err := asn1.Unmarshal(params.MGF.Parameters.FullBytes, &mgfHashAlgo); err != nil {
+			return nil, &InvalidParameterError{Member: "maskGenAlgorithm", Err: err}
+		}
+		mgfHash, err = pssHash(mgfHashAlgo)
+		if err != nil {
+			return nil, &InvalidParameterError{Member: "maskGenAlgorithm", Err: err}
+		}
+	}
+	if params.SaltLength < 0 {
+		return nil, &InvalidParameterError{Member: "saltLength", Err: fmt.Errorf("negative")}
+	}
+	if params.TrailerField != 1 {
+		return nil, &InvalidParameterError{Member: "trailerField", Err: fmt.Errorf("must be 1, not %d", params.TrailerField)}
+	}
+
+	return &RSAPSSParameters{Hash: hash, MGF1Hash: mgfHash, MinSaltLength: params.SaltLength}, nil
+}
+
+func rsaPSSAlgorithmIdentifier(p *RSAPSSParameters) (pkix.AlgorithmIdentifier, error) {
+	algo := pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyRSAPSS}
+	if p == nil {
+		return algo, nil
+	}
+
+	hash, err := pssHashAlgorithm(p.Hash)
+	if err != nil {
+		return algo, err
+	}
+	var mgf pkix.AlgorithmIdentifier
+	if p.MGF1Hash != crypto.SHA1 {
+		mgfHash, err := pssHashAlgorithm(p.MGF1Hash)
+		if err != nil {
+			return algo, err
+		}
+		mgfParams, err := asn1.Marshal(mgfHash)
+		if err != nil {
+			return algo, err
+		}
+		mgf = pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}}
+	}
+
+	params, err := asn1.Marshal(pssParameters{Hash: hash, MGF: mgf, SaltLength: p.MinSaltLength, TrailerField: 1})
+	if err != nil {
+		return algo, err
+	}
+	algo.Parameters = asn1.RawValue{FullBytes: params}
+	return algo, nil
+}
+
+func parseRSAPSSPKIXPublicKey(der []byte) (*RSAPSSPublicKey, error) {
+	var spki pkixPublicKey
+	if rest, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after SubjectPublicKeyInfo")
+	}
+	if !spki.Algorithm.Algorithm.Equal(oidPublicKeyRSAPSS) {
+		return nil, fmt.Errorf("not an RSA-PSS public key")
+	}
+	params, err := parseRSAPSSParameters(spki.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKCS1PublicKey(spki.PublicKey.RightAlign())
+	if err != nil {
+		return nil, &InvalidParameterError{Member: "subjectPublicKey", Err: err}
+	}
+	return &RSAPSSPublicKey{PublicKey: pub, Params: params}, nil
+}
+
+func parseRSAPSSPKCS8PrivateKey(der []byte) (*RSAPSSPrivateKey, error) {
+	var p8 pkcs8PrivateKey
+	if rest, err := asn1.Unmarshal(der, &p8); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after PrivateKeyInfo")
+	}
+	if !p8.Algorithm.Algorithm.Equal(oidPublicKeyRSAPSS) {
+		return nil, fmt.Errorf("not an RSA-PSS private key")
+	}
+	params, err := parseRSAPSSParameters(p8.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(p8.PrivateKey)
+	if err != nil {
+		return nil, &InvalidParameterError{Member: "privateKey", Err: err}
+	}
+	return &RSAPSSPrivateKey{PrivateKey: priv, Params: params}, nil
+}
+
+func marshalRSAPSSPKIXPublicKey(k *RSAPSSPublicKey) ([]byte, error) {
+	algo, err := rsaPSSAlgorithmIdentifier(k.Params)
+	if err != nil {
+		return nil, err
+	}
+	pub := x509.MarshalPKCS1PublicKey(k.PublicKey)
+	return asn1.Marshal(pkixPublicKey{
+		Algorithm: algo,
+		PublicKey: asn1.BitString{Bytes: pub, BitLength: 8 * len(pub)},
+	})
+}
+
+func marshalRSAPSSPKCS8PrivateKey(k *RSAPSSPrivateKey) ([]byte, error) {
+	algo, err := rsaPSSAlgorithmIdentifier(k.Params)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs8PrivateKey{
+		Algorithm:  algo,
+		PrivateKey: x509.MarshalPKCS1PrivateKey(k.PrivateKey),
+	})
+}
+
+// parseRSAPSSDER tries the encodings openssl uses for RSA-PSS keys
+func parseRSAPSSDER(der []byte) (any, bool) {
+	if k, err := parseRSAPSSPKIXPublicKey(der); err == nil {
+		return k, true
+	} else if k, err := parseRSAPSSPKCS8PrivateKey(der); err == nil {
+		return k, true
+	}
+	return nil, false
+}
@@ -0,0 +1,219 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/mt-inside/go-jwks/internal/secp256k1"
+)
+
+// secp256k1 (RFC 8812) support.
+// Keys are plain *ecdsa.[Public,Private]Key, with Curve secp256k1.S256(), so they work with crypto/ecdsa, and everything in this package.
+// The stdlib's x509 package doesn't know the curve though, so we do its ASN.1 ourselves.
+
+// IsSecp256k1 reports whether key is an ECDSA key (public or private) on the secp256k1 curve
+func IsSecp256k1(key any) bool {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return k.Curve == secp256k1.S256()
+	case *ecdsa.PrivateKey:
+		return k.Curve == secp256k1.S256()
+	}
+	return false
+}
+
+// ===
+// ASN.1
+// ===
+
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidNamedCurveK256 = asn1.ObjectIdentifier(secp256k1.OID)
+)
+
+// RFC 5280 SubjectPublicKeyInfo
+type pkixPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// RFC 5208 PrivateKeyInfo, and the optional public key of its RFC 5958 successor
+type pkcs8PrivateKey struct {
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+	Attributes asn1.RawValue  `asn1:"optional,tag:0"`
+	PublicKey  asn1.BitString `asn1:"optional,tag:1"`
+}
+
+// SEC 1 / RFC 5915 ECPrivateKey
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+func k256AlgorithmIdentifier() pkix.AlgorithmIdentifier {
+	params, _ := asn1.Marshal(oidNamedCurveK256) // can't fail
+	return pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}}
+}
+
+// isK256Algorithm checks an AlgorithmIdentifier is id-ecPublicKey with namedCurve secp256k1
+func isK256Algorithm(algo pkix.AlgorithmIdentifier) bool {
+	if !algo.Algorithm.Equal(oidPublicKeyECDSA) {
+		return false
+	}
+	var curve asn1.ObjectIdentifier
+	rest, err := asn1.Unmarshal(algo.Parameters.FullBytes, &curve)
+	return err == nil && len(rest) == 0 && curve.Equal(oidNamedCurveK256)
+}
+
+func parseK256PKIXPublicKey(der []byte) (*ecdsa.PublicKey, error) {
+	var spki pkixPublicKey
+	if rest, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after SubjectPublicKeyInfo")
+	}
+	if !isK256Algorithm(spki.Algorithm) {
+		return nil, fmt.Errorf("not a secp256k1 public key")
+	}
+	x, y := secp256k1.Unmarshal(spki.PublicKey.RightAlign())
+	if x == nil {
+		return nil, &InvalidParameterError{Member: "subjectPublicKey", Err: fmt.Errorf("not a point on secp256k1")}
+	}
+	return &ecdsa.PublicKey{Curve: secp256k1.S256(), X: x, Y: y}, nil
+}
+
+func parseK256PKCS8PrivateKey(der []byte) (*ecdsa.PrivateKey, error) {
+	var p8 pkcs8PrivateKey
+	if rest, err := asn1.Unmarshal(der, &p8); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after PrivateKeyInfo")
+	}
+	if !isK256Algorithm(p8.Algorithm) {
+		return nil, fmt.Errorf("not a secp256k1 private key")
+	}
+	return parseK256ECPrivateKey(p8.PrivateKey, false)
+}
+
+// parseK256ECPrivateKey parses SEC 1 ECPrivateKey. Standalone, it must name its curve; inside PKCS#8 the curve's named by the outer structure.
+func parseK256ECPrivateKey(der []byte, standalone bool) (*ecdsa.PrivateKey, error) {
+	var ec ecPrivateKey
+	if rest, err := asn1.Unmarshal(der, &ec); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after ECPrivateKey")
+	}
+	if ec.Version != 1 {
+		return nil, fmt.Errorf("unknown ECPrivateKey version %d", ec.Version)
+	}
+	if ec.NamedCurveOID != nil && !ec.NamedCurveOID.Equal(oidNamedCurveK256) || standalone && ec.NamedCurveOID == nil {
+		return nil, fmt.Errorf("not a secp256k1 private key")
+	}
+
+	c := secp256k1.S256()
+	d := new(big.Int).SetBytes(ec.PrivateKey)
+	if d.Sign() == 0 || d.Cmp(c.Params().N) >= 0 {
+		return nil, &InvalidParameterError{Member: "privateKey", Err: fmt.Errorf("out of range for secp256k1")}
+	}
+	priv := &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: c}, D: d}
+	priv.X, priv.Y = c.ScalarBaseMult(ec.PrivateKey)
+	// Like the stdlib, the public key is derived from d; any embedded copy is ignored (but must match)
+	if len(ec.PublicKey.Bytes) != 0 {
+		x, y := secp256k1.Unmarshal(ec.PublicKey.RightAlign())
+		if x == nil || x.Cmp(priv.X) != 0 || y.Cmp(priv.Y) != 0 {
+			return nil, &InvalidParameterError{Member: "publicKey", Err: fmt.Errorf("doesn't match private key")}
+		}
+	}
+	return priv, nil
+}
+
+func marshalK256PKIXPublicKey(k *ecdsa.PublicKey) ([]byte, error) {
+	point := secp256k1.Marshal(k.X, k.Y)
+	return asn1.Marshal(pkixPublicKey{
+		Algorithm: k256AlgorithmIdentifier(),
+		PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+}
+
+// marshalK256PKCS8PrivateKey renders the same structure as openssl and x509.MarshalPKCS8PrivateKey: ECPrivateKey with the public key but without the curve, as that's in the PKCS#8 AlgorithmIdentifier
+func marshalK256PKCS8PrivateKey(k *ecdsa.PrivateKey) ([]byte, error) {
+	point := secp256k1.Marshal(k.X, k.Y)
+	ec, err := asn1.Marshal(ecPrivateKey{
+		Version:    1,
+		PrivateKey: k.D.FillBytes(make([]byte, 32)),
+		PublicKey:  asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs8PrivateKey{
+		Algorithm:  k256AlgorithmIdentifier(),
+		PrivateKey: ec,
+	})
+}
+
+// parseK256DER tries all the encodings of secp256k1 keys that parseDER tries for the stdlib's curves
+func parseK256DER(der []byte) (any, bool) {
+	if k, err := parseK256PKIXPublicKey(der); err == nil {
+		return k, true
+	} else if k, err := parseK256PKCS8PrivateKey(der); err == nil {
+		return k, true
+	} else if k, err := parseK256ECPrivateKey(der, true); err == nil {
+		return k, true
+	}
+	return nil, false
+}
+
+// ===
+// JWT
+// ===
+
+// SigningMethodES256K is ECDSA using secp256k1 and SHA-256 (RFC 8812 §3.2).
+// It's registered with golang-jwt under "ES256K" when this package is imported.
+// Unlike golang-jwt's ECDSA methods, it refuses keys on any other curve.
+// It signs with the constant-time arithmetic in internal/secp256k1, rather than crypto/ecdsa, whose generic code for curves it doesn't know isn't constant-time.
+var SigningMethodES256K jwt.SigningMethod = &signingMethodES256K{
+	&jwt.SigningMethodECDSA{Name: "ES256K", Hash: crypto.SHA256, KeySize: 32, CurveBits: 256},
+}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodES256K.Alg(), func() jwt.SigningMethod { return SigningMethodES256K })
+}
+
+type signingMethodES256K struct {
+	*jwt.SigningMethodECDSA
+}
+
+func (m *signingMethodES256K) Verify(signingString string, sig []byte, key any) error {
+	if !IsSecp256k1(key) {
+		return fmt.Errorf("ES256K needs a secp256k1 key: %w", jwt.ErrInvalidKeyType)
+	}
+	return m.SigningMethodECDSA.Verify(signingString, sig, key)
+}
+
+func (m *signingMethodES256K) Sign(signingString string, key any) ([]byte, error) {
+	priv, ok := key.(*ecdsa.PrivateKey)
+	if !ok || !IsSecp256k1(priv) {
+		return nil, fmt.Errorf("ES256K needs a secp256k1 private key: %w", jwt.ErrInvalidKeyType)
+	}
+	var d [32]byte
+	priv.D.FillBytes(d[:])
+	digest := sha256.Sum256([]byte(signingString))
+	r, s, err := secp256k1.Sign(rand.Reader, &d, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return append(r[:], s[:]...), nil
+}
@@ -0,0 +1,53 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mt-inside/go-jwks/internal/secp256k1"
+)
+
+func TestES256K(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(secp256k1.S256(), rand.Reader)
+	require.NoError(t, err)
+
+	// Through JWK and back, as a verifier would get it
+	set, err := Keys2JWKSMarshaler([]any{priv.Public()})
+	require.NoError(t, err)
+	set.Keys[0].KeyID = "k1"
+	rendered, err := json.Marshal(set)
+	require.NoError(t, err)
+	require.Contains(t, string(rendered), `"crv":"secp256k1"`)
+	back, err := JWKS2KeysMap(rendered)
+	require.NoError(t, err)
+
+	tok := jwt.NewWithClaims(SigningMethodES256K, jwt.MapClaims{"sub": "satoshi"})
+	tok.Header["kid"] = "k1"
+	signed, err := tok.SignedString(priv)
+	require.NoError(t, err)
+
+	parsed, err := jwt.Parse(signed, func(tok *jwt.Token) (any, error) {
+		return back[tok.Header["kid"].(string)], nil
+	}, jwt.WithValidMethods([]string{"ES256K"}))
+	require.NoError(t, err)
+	require.True(t, parsed.Valid)
+
+	// Keys on other curves are refused, both ways
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, err = tok.SignedString(p256)
+	require.ErrorIs(t, err, jwt.ErrInvalidKeyType)
+	_, err = jwt.Parse(signed, func(*jwt.Token) (any, error) { return &p256.PublicKey, nil })
+	require.ErrorIs(t, err, jwt.ErrInvalidKeyType)
+
+	// COSE infers the algorithm from the curve
+	cose, err := JWK2COSEKey(&JWK{Key: &priv.PublicKey})
+	require.NoError(t, err)
+	require.Equal(t, int64(-47), cose.Algorithm)
+}
@@ -0,0 +1,232 @@
+package jwks
+
+import (
+	"crypto"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Querying and manipulating key sets.
+// Everything here deals in *JWK, so that kids and other metadata are kept, unlike the JWKS2Keys family.
+// Filtered sets share their *JWKs with the original.
+
+// On duplicate kids: RFC 7517 §4.5 says keys in a set SHOULD have distinct kids, but gives the example of the same kid being used for equivalent keys of different kty.
+// So we consider two keys to clash if they have the same kid, kty, and use. Keys without a kid never clash, as there's no way to ask for them by kid anyway.
+func keysClash(a, b *JWK) bool {
+	return a.KeyID != "" && a.KeyID == b.KeyID && a.KeyType() == b.KeyType() && a.Use == b.Use
+}
+
+// KeyFilter is a predicate over keys, for use with JWKS.Filter
+type KeyFilter func(*JWK) bool
+
+// WithKeyType selects keys of the given kty, eg "RSA"
+func WithKeyType(kty string) KeyFilter {
+	return func(k *JWK) bool { return k.KeyType() == kty }
+}
+
+// WithUse selects keys with the given use, eg "sig"
+func WithUse(use string) KeyFilter {
+	return func(k *JWK) bool { return k.Use == use }
+}
+
+// WithAlgorithm selects keys with the given alg, eg "ES256". Keys without an explicit alg don't match.
+func WithAlgorithm(alg string) KeyFilter {
+	return func(k *JWK) bool { return k.Algorithm == alg }
+}
+
+// WithKeyOp selects keys whose key_ops include the given operation, eg "verify"
+func WithKeyOp(op string) KeyFilter {
+	return func(k *JWK) bool { return slices.Contains(k.KeyOps, op) }
+}
+
+// WithCurve selects elliptic curve keys on the given crv, eg "P-256"
+func WithCurve(crv string) KeyFilter {
+	return func(k *JWK) bool { return k.Curve() == crv }
+}
+
+// KeyByID returns the first key with the given kid, or nil if there isn't one
+func (s *JWKS) KeyByID(kid string) *JWK {
+	for _, k := range s.Keys {
+		if k.KeyID == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+// Filter returns a new set of the keys that match all the given filters
+func (s *JWKS) Filter(filters ...KeyFilter) *JWKS {
+	out := &JWKS{Keys: []*JWK{}}
+outer:
+	for _, k := range s.Keys {
+		for _, f := range filters {
+			if !f(k) {
+				continue outer
+			}
+		}
+		out.Keys = append(out.Keys, k)
+	}
+	return out
+}
+
+// Add appends a key to the set, failing with a DuplicateKeyIDError if it clashes with one already present
+func (s *JWKS) Add(k *JWK) error {
+	for _, existing := range s.Keys {
+		if keysClash(existing, k) {
+			return &DuplicateKeyIDError{KeyID: k.KeyID}
+		}
+	}
+	s.Keys = append(s.Keys, k)
+	return nil
+}
+
+// Replace puts a key into the set in place of any it clashes with, or appends it if there are none.
+// It reports whether a key was replaced.
+func (s *JWKS) Replace(k *JWK) bool {
+	for i, existing := range s.Keys {
+		if keysClash(existing, k) {
+			s.Keys[i] = k
+			return true
+		}
+	}
+	s.Keys = append(s.Keys, k)
+	return false
+}
+
+// Remove deletes all keys with the given kid, returning how many there were
+func (s *JWKS) Remove(kid string) int {
+	before := len(s.Keys)
+	s.Keys = slices.DeleteFunc(s.Keys, func(k *JWK) bool { return k.KeyID == kid })
+	return before - len(s.Keys)
+}
+
+// MergePolicy says what Merge should do when a key in the other set clashes with one in this set
+type MergePolicy int
+
+const (
+	// MergeError fails the merge. Clashing keys that are actually the same key (by thumbprint) are not an error; the existing one is kept.
+	MergeError MergePolicy = iota
+	// MergeKeepExisting keeps this set's key
+	MergeKeepExisting
+	// MergeReplace takes the other set's key
+	MergeReplace
+)
+
+// Merge adds all of the other set's keys into this one, resolving clashes according to the policy.
+// On error, this set is unchanged.
+func (s *JWKS) Merge(other *JWKS, policy MergePolicy) error {
+	merged := &JWKS{Keys: slices.Clone(s.Keys)}
+
+	for i, k := range other.Keys {
+		idx := slices.IndexFunc(merged.Keys, func(existing *JWK) bool { return keysClash(existing, k) })
+		if idx == -1 {
+			merged.Keys = append(merged.Keys, k)
+			continue
+		}
+
+		switch policy {
+		case MergeError:
+			same, err := sameKey(merged.Keys[idx], k)
+			if err != nil {
+				return &KeyError{Index: i, KeyID: k.KeyID, Err: err}
+			}
+			if !same {
+				return &KeyError{Index: i, KeyID: k.KeyID, Err: &DuplicateKeyIDError{KeyID: k.KeyID}}
+			}
+		case MergeKeepExisting:
+		case MergeReplace:
+			merged.Keys[idx] = k
+		default:
+			return fmt.Errorf("unknown merge policy %d", policy)
+		}
+	}
+
+	s.Keys = merged.Keys
+	return nil
+}
+
+// Dedupe removes keys which are the same as an earlier key in the set, as judged by their RFC 7638 thumbprint.
+// This means a public key is a duplicate of its private key, and that kids are ignored.
+// It returns how many keys were removed.
+func (s *JWKS) Dedupe() (int, error) {
+	seen := map[string]bool{}
+	out := make([]*JWK, 0, len(s.Keys))
+	for i, k := range s.Keys {
+		tp, err := k.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return 0, &KeyError{Index: i, KeyID: k.KeyID, Err: err}
+		}
+		if seen[string(tp)] {
+			continue
+		}
+		seen[string(tp)] = true
+		out = append(out, k)
+	}
+
+	removed := len(s.Keys) - len(out)
+	s.Keys = out
+	return removed, nil
+}
+
+func sameKey(a, b *JWK) (bool, error) {
+	tpA, err := a.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return false, err
+	}
+	tpB, err := b.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return false, err
+	}
+	return string(tpA) == string(tpB), nil
+}
+
+// Keyfunc is a jwt.Keyfunc that finds the token's verification key in the set: the one with its kid, or if it has none, the set's only signing key.
+// Only keys of a type that can verify the token's alg are considered, so eg an RSA and an EC key can share a kid.
+// Keys with an alg only verify tokens with that alg, and keys with a use other than "sig" aren't used at all.
+func (s *JWKS) Keyfunc(tok *jwt.Token) (any, error) {
+	alg := tok.Method.Alg()
+	usable := func(k *JWK) bool { return (k.Use == "" || k.Use == "sig") && keyFitsAlgorithm(k, alg) }
+
+	var k *JWK
+	if kid, ok := tok.Header["kid"].(string); ok && kid != "" {
+		for _, cand := range s.Keys {
+			if cand.KeyID == kid && usable(cand) {
+				k = cand
+				break
+			}
+		}
+		if k == nil {
+			return nil, fmt.Errorf("no signing key with kid %q for %s", kid, alg)
+		}
+	} else {
+		sigs := s.Filter(usable)
+		if len(sigs.Keys) != 1 {
+			return nil, fmt.Errorf("token has no kid, and there are %d signing keys for %s to choose from", len(sigs.Keys), alg)
+		}
+		k = sigs.Keys[0]
+	}
+
+	if k.Algorithm != "" && k.Algorithm != alg {
+		return nil, fmt.Errorf("key is for %s, not %s", k.Algorithm, alg)
+	}
+	return KeyPublicPartErr(k.Key)
+}
+
+// keyFitsAlgorithm reports whether k is the right type of key for the JWS alg, eg RSA for RS256 and PS384, or P-384 for ES384.
+// Algs we don't know aren't ruled out; the signing method will check the key itself.
+func keyFitsAlgorithm(k *JWK, alg string) bool {
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		return k.KeyType() == "RSA"
+	case strings.HasPrefix(alg, "ES"):
+		curveAlgs := map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512", "secp256k1": "ES256K"}
+		return k.KeyType() == "EC" && curveAlgs[k.Curve()] == alg
+	case alg == "EdDSA":
+		return k.Curve() == "Ed25519" || k.Curve() == "Ed448"
+	default:
+		return true
+	}
+}
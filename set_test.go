@@ -0,0 +1,185 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func testSet(t *testing.T) *JWKS {
+	set, err := PEM2JWKSMarshaler(mixeds[0].pem)
+	require.NoError(t, err)
+	set.Keys[0].KeyID = "rsa"
+	set.Keys[0].Use = "sig"
+	set.Keys[0].KeyOps = []string{"verify"}
+	set.Keys[1].KeyID = "ec"
+	set.Keys[1].Use = "enc"
+	set.Keys[1].Algorithm = "ECDH-ES"
+	set.Keys[2].KeyID = "ec-priv"
+	set.Keys[2].Use = "sig"
+	set.Keys[2].Algorithm = "ES256"
+	return set
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	set := testSet(t)
+	bs, err := MarshalOptions{AllowPrivate: true}.Marshal(set)
+	require.NoError(t, err)
+
+	back := &JWKS{}
+	require.NoError(t, json.Unmarshal(bs, back))
+	for i := range set.Keys {
+		require.Equal(t, set.Keys[i].KeyID, back.Keys[i].KeyID)
+		require.Equal(t, set.Keys[i].Use, back.Keys[i].Use)
+		require.Equal(t, set.Keys[i].KeyOps, back.Keys[i].KeyOps)
+	}
+	require.Equal(t, "RS256", back.Keys[0].Algorithm, "RSA keys should be rendered with a default alg")
+	require.Equal(t, "ECDH-ES", back.Keys[1].Algorithm)
+}
+
+func TestQuery(t *testing.T) {
+	set := testSet(t)
+
+	require.Same(t, set.Keys[1], set.KeyByID("ec"))
+	require.Nil(t, set.KeyByID("nope"))
+
+	require.Len(t, set.Filter(WithKeyType("EC")).Keys, 2)
+	require.Len(t, set.Filter(WithKeyType("EC"), WithUse("sig")).Keys, 1)
+	require.Len(t, set.Filter(WithCurve("P-256")).Keys, 2)
+	require.Len(t, set.Filter(WithCurve("P-384")).Keys, 0)
+	require.Len(t, set.Filter(WithAlgorithm("ES256")).Keys, 1)
+	require.Len(t, set.Filter(WithKeyOp("verify")).Keys, 1)
+	require.Len(t, set.Filter().Keys, 3)
+	require.Same(t, set.Keys[2], set.Filter(WithUse("sig"), WithKeyType("EC")).Keys[0], "Filtered sets should share keys")
+}
+
+func TestManipulate(t *testing.T) {
+	set := testSet(t)
+	ec := set.Keys[1]
+
+	// Same kid, kty and use clashes
+	err := set.Add(&JWK{KeyID: "ec", Use: "enc", Key: set.Keys[2].Key})
+	require.ErrorIs(t, err, ErrDuplicateKeyID)
+	// Different use doesn't
+	require.NoError(t, set.Add(&JWK{KeyID: "ec", Use: "sig", Key: ec.Key}))
+	// Different kty doesn't
+	require.NoError(t, set.Add(&JWK{KeyID: "ec", Use: "enc", Key: set.Keys[0].Key}))
+	// No kid never clashes
+	require.NoError(t, set.Add(&JWK{Key: ec.Key}))
+	require.NoError(t, set.Add(&JWK{Key: ec.Key}))
+	require.Len(t, set.Keys, 7)
+
+	replacement := &JWK{KeyID: "ec", Use: "enc", Key: set.Keys[2].Key}
+	require.True(t, set.Replace(replacement))
+	require.Same(t, replacement, set.Keys[1])
+	require.False(t, set.Replace(&JWK{KeyID: "new", Key: ec.Key}))
+	require.Len(t, set.Keys, 8)
+
+	require.Equal(t, 3, set.Remove("ec"))
+	require.Equal(t, 0, set.Remove("ec"))
+	require.Len(t, set.Keys, 5)
+}
+
+func TestMerge(t *testing.T) {
+	set := testSet(t)
+	ecPriv := set.Keys[2]
+
+	// Identical keys aren't a conflict
+	require.NoError(t, set.Merge(testSet(t), MergeError))
+	require.Len(t, set.Keys, 3)
+
+	otherEC, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	other := &JWKS{Keys: []*JWK{
+		{KeyID: "new", Key: ecPriv.Key},
+		{KeyID: "ec-priv", Use: "sig", Key: otherEC.Public()},
+	}}
+	err = set.Merge(other, MergeError)
+	require.ErrorIs(t, err, ErrDuplicateKeyID)
+	require.Len(t, set.Keys, 3, "Failed merge shouldn't change the set")
+
+	require.NoError(t, set.Merge(other, MergeKeepExisting))
+	require.Len(t, set.Keys, 4)
+	require.Same(t, ecPriv, set.KeyByID("ec-priv"))
+
+	require.NoError(t, set.Merge(other, MergeReplace))
+	require.Len(t, set.Keys, 4)
+	require.Same(t, other.Keys[1], set.KeyByID("ec-priv"))
+}
+
+func TestDedupe(t *testing.T) {
+	set := testSet(t)
+	// The EC public key and the EC private key are the same key pair
+	removed, err := set.Dedupe()
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	require.Len(t, set.Keys, 2)
+	require.Equal(t, "ec", set.Keys[1].KeyID, "Dedupe should keep the first occurrence")
+}
+
+func TestKeyfunc(t *testing.T) {
+	sigKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	encKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	set := &JWKS{Keys: []*JWK{
+		{KeyID: "a", Key: &sigKey.PublicKey, Use: "sig"},
+		{KeyID: "b", Key: &encKey.PublicKey, Use: "enc"},
+	}}
+
+	sign := func(kid, alg string) string {
+		tok := jwt.NewWithClaims(jwt.GetSigningMethod(alg), jwt.MapClaims{"sub": "x"})
+		if kid != "" {
+			tok.Header["kid"] = kid
+		}
+		s, err := tok.SignedString(sigKey)
+		require.NoError(t, err)
+		return s
+	}
+
+	_, err = jwt.Parse(sign("a", "ES256"), set.Keyfunc)
+	require.NoError(t, err)
+	// The only signing key
+	_, err = jwt.Parse(sign("", "ES256"), set.Keyfunc)
+	require.NoError(t, err)
+	// Encryption keys aren't for verifying
+	_, err = jwt.Parse(sign("b", "ES256"), set.Keyfunc)
+	require.ErrorContains(t, err, `no signing key with kid "b"`)
+	_, err = jwt.Parse(sign("c", "ES256"), set.Keyfunc)
+	require.ErrorContains(t, err, `no signing key with kid "c"`)
+
+	set.Keys[0].Algorithm = "ES384"
+	_, err = jwt.Parse(sign("a", "ES256"), set.Keyfunc)
+	require.ErrorContains(t, err, "key is for ES384, not ES256")
+	set.Keys[0].Algorithm = ""
+
+	// A key of the wrong type for the alg is passed over, even if it comes first with that kid
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 3072)
+	require.NoError(t, err)
+	set.Keys = append([]*JWK{{KeyID: "a", Key: &rsaKey.PublicKey}}, set.Keys...)
+	_, err = jwt.Parse(sign("a", "ES256"), set.Keyfunc)
+	require.NoError(t, err)
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "x"})
+	tok.Header["kid"] = "a"
+	signed, err := tok.SignedString(rsaKey)
+	require.NoError(t, err)
+	_, err = jwt.Parse(signed, set.Keyfunc)
+	require.NoError(t, err)
+	_, err = jwt.Parse(signed, (&JWKS{Keys: set.Keys[1:]}).Keyfunc)
+	require.ErrorContains(t, err, `no signing key with kid "a" for RS256`)
+
+	// An RSA key that's not 2048 bits, through a JWKS document and back, verifies RS256 tokens
+	rendered, err := json.Marshal(&JWKS{Keys: []*JWK{{KeyID: "a", Key: &rsaKey.PublicKey}}})
+	require.NoError(t, err)
+	back := &JWKS{}
+	require.NoError(t, json.Unmarshal(rendered, back))
+	require.Equal(t, "RS256", back.Keys[0].Algorithm)
+	_, err = jwt.Parse(signed, back.Keyfunc)
+	require.NoError(t, err)
+}
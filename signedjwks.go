@@ -0,0 +1,130 @@
+package jwks
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signed JWK Sets: a JWT whose claims are a JWKS, plus who published it and when, as served from OpenID Federation's signed_jwks_uri (OpenID Federation 1.0 §5.2.1).
+// The consumer checks the signature against keys it already trusts (eg the issuer's Entity Configuration, or a trust anchor), then uses the inner set.
+
+// SignedJWKSType is the typ header of a signed JWKS
+const SignedJWKSType = "jwk-set+jwt"
+
+// SignedJWKS is the content of a signed JWK Set
+type SignedJWKS struct {
+	Keys *JWKS
+	// Issuer is the entity that signed the set
+	Issuer string
+	// Subject is the entity whose keys these are; usually the same as the Issuer
+	Subject string
+	// IssuedAt is when the set was signed. Sign sets it to the current time if it's zero.
+	IssuedAt time.Time
+	// Expiry is optional, the zero value meaning the set doesn't expire
+	Expiry time.Time
+}
+
+// The set's keys array is a member of the claims, rather than the whole JWKS object
+type signedJWKSClaims struct {
+	jwt.RegisteredClaims
+	Keys []json.RawMessage `json:"keys"`
+}
+
+// Sign renders s as a JWT, signed with key, a private key or a *JWK holding one.
+// If key is a *JWK, its kid is put in the header. The alg is chosen as for NewDPoPSigner.
+// The keys in the set must all be public.
+func (s *SignedJWKS) Sign(key any) (string, error) {
+	if s.Keys == nil {
+		return "", missingParameter("keys")
+	}
+	if s.Issuer == "" {
+		return "", missingParameter("iss")
+	}
+	if s.Subject == "" {
+		return "", missingParameter("sub")
+	}
+	signer, err := newJWSSigner(key, "signed JWKS")
+	if err != nil {
+		return "", err
+	}
+	keys, err := s.Keys.renderKeys(MarshalOptions{}) // refuses private keys
+	if err != nil {
+		return "", err
+	}
+
+	iat := s.IssuedAt
+	if iat.IsZero() {
+		iat = time.Now()
+	}
+	claims := &signedJWKSClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   s.Issuer,
+			Subject:  s.Subject,
+			IssuedAt: jwt.NewNumericDate(iat),
+		},
+		Keys: keys,
+	}
+	if !s.Expiry.IsZero() {
+		claims.ExpiresAt = jwt.NewNumericDate(s.Expiry)
+	}
+
+	tok := jwt.NewWithClaims(signer.method, claims)
+	tok.Header["typ"] = SignedJWKSType
+	if j, ok := key.(*JWK); ok && j.KeyID != "" {
+		tok.Header["kid"] = j.KeyID
+	}
+	return tok.SignedString(signer.key)
+}
+
+// ParseSignedJWKS verifies a signed JWKS against the trusted keys, and unpacks it.
+// The set must be of public keys, have an iss and sub, and not have expired.
+// opts are passed to the jwt parser, eg jwt.WithIssuer to check who signed it, or jwt.WithTimeFunc.
+// Failures wrap ErrInvalidSignedJWKS.
+func ParseSignedJWKS(token string, trusted *JWKS, opts ...jwt.ParserOption) (*SignedJWKS, error) {
+	claims := &signedJWKSClaims{}
+	tok, err := jwt.NewParser(opts...).ParseWithClaims(token, claims, trusted.Keyfunc)
+	if err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidSignedJWKS, Err: err}
+	}
+	// RFC 8725 §3.11 allows the media type's application/ prefix to be left off, so we allow it to be present
+	typ, _ := tok.Header["typ"].(string)
+	if !strings.EqualFold(strings.TrimPrefix(strings.ToLower(typ), "application/"), SignedJWKSType) {
+		return nil, &wrappedError{sentinel: ErrInvalidSignedJWKS, Err: fmt.Errorf("typ must be %s, not %q", SignedJWKSType, typ)}
+	}
+	if claims.Keys == nil {
+		return nil, &wrappedError{sentinel: ErrInvalidSignedJWKS, Err: missingParameter("keys")}
+	}
+	doc, err := json.Marshal(struct {
+		Keys []json.RawMessage `json:"keys"`
+	}{claims.Keys})
+	if err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidSignedJWKS, Err: err}
+	}
+	set, _, err := UnmarshalOptions{RejectPrivate: true}.Unmarshal(doc)
+	if err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidSignedJWKS, Err: err}
+	}
+	if claims.Issuer == "" {
+		return nil, &wrappedError{sentinel: ErrInvalidSignedJWKS, Err: missingParameter("iss")}
+	}
+	if claims.Subject == "" {
+		return nil, &wrappedError{sentinel: ErrInvalidSignedJWKS, Err: missingParameter("sub")}
+	}
+
+	out := &SignedJWKS{
+		Keys:    set,
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+	}
+	if claims.IssuedAt != nil {
+		out.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		out.Expiry = claims.ExpiresAt.Time
+	}
+	return out, nil
+}
@@ -0,0 +1,141 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedJWKS(t *testing.T) {
+	fedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	fed := &JWK{KeyID: "fed-1", Key: fedKey}
+	fedPub, err := fed.PublicOnly()
+	require.NoError(t, err)
+	trusted := &JWKS{Keys: []*JWK{fedPub}}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	set := &JWKS{Keys: []*JWK{{KeyID: "rp-1", Use: "sig", Key: &rsaKey.PublicKey}}}
+
+	iat := time.Unix(time.Now().Unix(), 0)
+	s := &SignedJWKS{
+		Keys:     set,
+		Issuer:   "https://rp.example.com",
+		Subject:  "https://rp.example.com",
+		IssuedAt: iat,
+		Expiry:   iat.Add(time.Hour),
+	}
+	tok, err := s.Sign(fed)
+	require.NoError(t, err)
+
+	// keys is the set's array, not a nested set
+	raw := jwt.MapClaims{}
+	parsed, _, err := jwt.NewParser().ParseUnverified(tok, raw)
+	require.NoError(t, err)
+	require.Equal(t, "jwk-set+jwt", parsed.Header["typ"])
+	require.Equal(t, "fed-1", parsed.Header["kid"])
+	require.Len(t, raw["keys"], 1)
+
+	got, err := ParseSignedJWKS(tok, trusted, jwt.WithIssuer("https://rp.example.com"))
+	require.NoError(t, err)
+	require.Equal(t, s.Issuer, got.Issuer)
+	require.Equal(t, s.Subject, got.Subject)
+	require.Equal(t, iat, got.IssuedAt)
+	require.Equal(t, iat.Add(time.Hour), got.Expiry)
+	require.Len(t, got.Keys.Keys, 1)
+	require.Equal(t, "rp-1", got.Keys.Keys[0].KeyID)
+	require.True(t, rsaKey.PublicKey.Equal(got.Keys.Keys[0].Key))
+
+	// Not signed by a trusted key
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tok, err = s.Sign(&JWK{KeyID: "fed-1", Key: otherKey})
+	require.NoError(t, err)
+	_, err = ParseSignedJWKS(tok, trusted)
+	require.ErrorIs(t, err, ErrInvalidSignedJWKS)
+	tok, err = s.Sign(&JWK{KeyID: "fed-2", Key: otherKey})
+	require.NoError(t, err)
+	_, err = ParseSignedJWKS(tok, trusted)
+	require.ErrorContains(t, err, `no signing key with kid "fed-2"`)
+
+	// Expired
+	tok, err = (&SignedJWKS{Keys: set, Issuer: "a", Subject: "a", IssuedAt: iat.Add(-2 * time.Hour), Expiry: iat.Add(-time.Hour)}).Sign(fed)
+	require.NoError(t, err)
+	_, err = ParseSignedJWKS(tok, trusted)
+	require.ErrorIs(t, err, ErrInvalidSignedJWKS)
+	require.ErrorIs(t, err, jwt.ErrTokenExpired)
+
+	// Wrong issuer
+	tok, err = (&SignedJWKS{Keys: set, Issuer: "a", Subject: "a"}).Sign(fed)
+	require.NoError(t, err)
+	_, err = ParseSignedJWKS(tok, trusted, jwt.WithIssuer("b"))
+	require.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+
+	// Signing checks
+	_, err = (&SignedJWKS{Keys: set, Subject: "a"}).Sign(fed)
+	require.ErrorIs(t, err, ErrInvalidParameter)
+	_, err = (&SignedJWKS{Keys: set, Issuer: "a", Subject: "a"}).Sign(fedPub)
+	require.ErrorContains(t, err, "need a private key")
+	_, err = (&SignedJWKS{Keys: &JWKS{Keys: []*JWK{{Key: rsaKey}}}, Issuer: "a", Subject: "a"}).Sign(fed)
+	require.ErrorIs(t, err, ErrPrivateKey)
+}
+
+func TestParseSignedJWKSChecks(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	trusted := &JWKS{Keys: []*JWK{{KeyID: "k", Key: &key.PublicKey}}}
+	sign := func(typ string, claims jwt.MapClaims) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		tok.Header["kid"] = "k"
+		if typ != "" {
+			tok.Header["typ"] = typ
+		}
+		s, err := tok.SignedString(key)
+		require.NoError(t, err)
+		return s
+	}
+	keys := map[string]any{"keys": []any{map[string]any{"kty": "EC", "crv": "P-256", "x": "MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4", "y": "4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM"}}}
+	claims := func(extra map[string]any) jwt.MapClaims {
+		c := jwt.MapClaims{"iss": "a", "sub": "a", "keys": keys["keys"]}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	_, err = ParseSignedJWKS(sign("jwk-set+jwt", claims(nil)), trusted)
+	require.NoError(t, err)
+	_, err = ParseSignedJWKS(sign("application/JWK-SET+JWT", claims(nil)), trusted)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name   string
+		tok    string
+		expect string
+	}{
+		{"no typ", sign("", claims(nil)), "typ must be"},
+		{"wrong typ", sign("JWT", claims(nil)), "typ must be"},
+		{"no keys", sign("jwk-set+jwt", claims(map[string]any{"keys": nil})), "keys"},
+		{"no iss", sign("jwk-set+jwt", claims(map[string]any{"iss": nil})), "iss"},
+		{"no sub", sign("jwk-set+jwt", claims(map[string]any{"sub": nil})), "sub"},
+		{"private key", sign("jwk-set+jwt", claims(map[string]any{"keys": []any{map[string]any{"kty": "EC", "crv": "P-256", "x": "MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4", "y": "4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM", "d": "870MB6gfuTJ4HtUnUvYMyJpr5eUZNP4Bk43bVdj3eAE"}}})), "private key"},
+		{"garbage", "not.a.jwt", ""},
+	}
+	for _, cse := range cases {
+		_, err := ParseSignedJWKS(cse.tok, trusted)
+		require.ErrorIs(t, err, ErrInvalidSignedJWKS, cse.name)
+		require.True(t, strings.Contains(err.Error(), cse.expect), "%s: %v", cse.name, err)
+	}
+}
@@ -0,0 +1,240 @@
+package jwks
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SPIFFE trust bundles (SPIFFE Trust Domain and Bundle §4): a JWKS holding a trust domain's X.509 CA certificates and JWT-SVID signing keys, told apart by their use.
+// And verifying JWT-SVIDs (SPIFFE JWT-SVID §5) against them.
+
+const (
+	SPIFFEUseX509SVID = "x509-svid" // Key of a CA certificate, carried in x5c, which X.509-SVIDs chain to
+	SPIFFEUseJWTSVID  = "jwt-svid"  // Key that JWT-SVIDs are signed with
+)
+
+// SPIFFEBundle is a trust domain's bundle.
+// Keys with a use other than SPIFFEUseX509SVID or SPIFFEUseJWTSVID are ignored when parsing, as the spec says.
+type SPIFFEBundle struct {
+	JWKS
+	// TrustDomain isn't in the document, but is needed to check the SPIFFE IDs of JWT-SVIDs, eg "example.org"
+	TrustDomain string
+	// Sequence is spiffe_sequence, which increases with each new version of the bundle. 0 means it's absent.
+	Sequence uint64
+	// RefreshHint is spiffe_refresh_hint, how often consumers should check for a new bundle. Whole seconds; 0 means it's absent.
+	RefreshHint time.Duration
+}
+
+type spiffeBundleFields struct {
+	Keys        []json.RawMessage `json:"keys"`
+	RefreshHint int64             `json:"spiffe_refresh_hint,omitempty"`
+	Sequence    uint64            `json:"spiffe_sequence,omitempty"`
+}
+
+// ParseSPIFFEBundle parses trustDomain's bundle.
+// Keys that are invalid (including x509-svid keys without exactly one certificate, and jwt-svid keys without a kid) are skipped, and described by the returned diagnostics.
+func ParseSPIFFEBundle(trustDomain string, data []byte) (*SPIFFEBundle, []*KeyError, error) {
+	b, diags, err := unmarshalSPIFFEBundle(data, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	b.TrustDomain = trustDomain
+	return b, diags, nil
+}
+
+// MarshalJSON renders the bundle, failing if any key is private, or isn't valid for its use
+func (b SPIFFEBundle) MarshalJSON() ([]byte, error) {
+	for i, k := range b.Keys {
+		if err := checkSPIFFEKey(k, i); err != nil {
+			return nil, &KeyError{Index: i, KeyID: k.KeyID, Err: err}
+		}
+	}
+	keys, err := b.JWKS.renderKeys(MarshalOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(spiffeBundleFields{
+		Keys:        keys,
+		RefreshHint: int64(b.RefreshHint / time.Second),
+		Sequence:    b.Sequence,
+	})
+}
+
+// UnmarshalJSON parses a bundle, failing if any key is invalid. TrustDomain is left alone.
+func (b *SPIFFEBundle) UnmarshalJSON(data []byte) error {
+	parsed, _, err := unmarshalSPIFFEBundle(data, false)
+	if err != nil {
+		return err
+	}
+	parsed.TrustDomain = b.TrustDomain
+	*b = *parsed
+	return nil
+}
+
+func unmarshalSPIFFEBundle(data []byte, lenient bool) (*SPIFFEBundle, []*KeyError, error) {
+	fields := struct {
+		RefreshHint int64  `json:"spiffe_refresh_hint"`
+		Sequence    uint64 `json:"spiffe_sequence"`
+	}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, nil, err
+	}
+	b := &SPIFFEBundle{
+		JWKS:        JWKS{Keys: []*JWK{}},
+		Sequence:    fields.Sequence,
+		RefreshHint: time.Duration(fields.RefreshHint) * time.Second,
+	}
+
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.ContinueOnError = lenient
+	var diags []*KeyError
+	for i := 0; ; i++ {
+		k, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var kErr *KeyError
+		if errors.As(err, &kErr) && lenient {
+			diags = append(diags, kErr)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if k.Use != SPIFFEUseX509SVID && k.Use != SPIFFEUseJWTSVID {
+			continue
+		}
+		err = checkSPIFFEKey(k, i)
+		if err == nil {
+			err = b.Add(k)
+		}
+		if err != nil {
+			kErr := &KeyError{Index: i, KeyID: k.KeyID, Err: err}
+			if !lenient {
+				return nil, nil, kErr
+			}
+			diags = append(diags, kErr)
+		}
+	}
+	return b, diags, nil
+}
+
+// checkSPIFFEKey checks k against the requirements for its use (SPIFFE Trust Domain and Bundle §4.2)
+func checkSPIFFEKey(k *JWK, index int) error {
+	priv, err := KeyIsPrivateErr(k.Key)
+	if err != nil {
+		return err
+	}
+	if priv {
+		return &PrivateKeyError{KeyIDs: []string{keyLabel(k, index)}}
+	}
+	switch k.Use {
+	case SPIFFEUseX509SVID:
+		if len(k.Certificates) != 1 {
+			return &InvalidParameterError{Member: "x5c", Err: fmt.Errorf("must be the one CA certificate, not %d", len(k.Certificates))}
+		}
+	case SPIFFEUseJWTSVID:
+		if k.KeyID == "" {
+			return missingParameter("kid")
+		}
+	default:
+		return &InvalidParameterError{Member: "use", Err: fmt.Errorf("must be %s or %s, not %q", SPIFFEUseX509SVID, SPIFFEUseJWTSVID, k.Use)}
+	}
+	return nil
+}
+
+// X509Authorities are the CA certificates that X.509-SVIDs in the trust domain chain to, eg for an x509.CertPool
+func (b *SPIFFEBundle) X509Authorities() []*x509.Certificate {
+	var out []*x509.Certificate
+	for _, k := range b.Filter(WithUse(SPIFFEUseX509SVID)).Keys {
+		out = append(out, k.Certificates[0])
+	}
+	return out
+}
+
+// JWTAuthorities are the keys JWT-SVIDs in the trust domain are signed with
+func (b *SPIFFEBundle) JWTAuthorities() *JWKS {
+	return b.Filter(WithUse(SPIFFEUseJWTSVID))
+}
+
+// ===
+// JWT-SVIDs
+// ===
+
+// JWTSVIDAlgorithms are the algs JWT-SVIDs may be signed with (SPIFFE JWT-SVID §3)
+var JWTSVIDAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512"}
+
+// JWTSVID is a verified JWT-SVID
+type JWTSVID struct {
+	ID       string // The SPIFFE ID, eg "spiffe://example.org/ns/default/sa/web"
+	Audience []string
+	Expiry   time.Time
+	Claims   jwt.MapClaims
+}
+
+// VerifyJWTSVID checks a JWT-SVID was issued in the bundle's trust domain, signed by one of its JWT authorities, and is for audience.
+// opts are passed to the jwt parser, eg jwt.WithTimeFunc.
+// Failures wrap ErrInvalidSVID.
+func (b *SPIFFEBundle) VerifyJWTSVID(token, audience string, opts ...jwt.ParserOption) (*JWTSVID, error) {
+	if b.TrustDomain == "" {
+		return nil, &wrappedError{sentinel: ErrInvalidSVID, Err: fmt.Errorf("bundle has no trust domain")}
+	}
+	if audience == "" {
+		return nil, &wrappedError{sentinel: ErrInvalidSVID, Err: fmt.Errorf("an audience is required")}
+	}
+
+	// JWKS.Keyfunc only uses signing keys, which these are, by another name
+	keys := &JWKS{}
+	for _, k := range b.JWTAuthorities().Keys {
+		sig := *k
+		sig.Use = "sig"
+		keys.Keys = append(keys.Keys, &sig)
+	}
+
+	claims := jwt.MapClaims{}
+	opts = append([]jwt.ParserOption{jwt.WithValidMethods(JWTSVIDAlgorithms), jwt.WithExpirationRequired(), jwt.WithAudience(audience)}, opts...)
+	tok, err := jwt.NewParser(opts...).ParseWithClaims(token, claims, keys.Keyfunc)
+	if err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidSVID, Err: err}
+	}
+	if typ, ok := tok.Header["typ"].(string); ok && typ != "JWT" && typ != "JOSE" {
+		return nil, &wrappedError{sentinel: ErrInvalidSVID, Err: fmt.Errorf("typ must be JWT or JOSE, not %q", typ)}
+	}
+
+	sub, err := claims.GetSubject()
+	if err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidSVID, Err: err}
+	}
+	td, err := spiffeTrustDomain(sub)
+	if err != nil {
+		return nil, &wrappedError{sentinel: ErrInvalidSVID, Err: err}
+	}
+	if td != b.TrustDomain {
+		return nil, &wrappedError{sentinel: ErrInvalidSVID, Err: fmt.Errorf("%s isn't in trust domain %s", sub, b.TrustDomain)}
+	}
+
+	aud, _ := claims.GetAudience()
+	exp, _ := claims.GetExpirationTime()
+	return &JWTSVID{ID: sub, Audience: aud, Expiry: exp.Time, Claims: claims}, nil
+}
+
+// spiffeTrustDomain returns the trust domain of a SPIFFE ID (SPIFFE ID §2)
+func spiffeTrustDomain(id string) (string, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "spiffe" || u.Host == "" || u.User != nil || u.Port() != "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%q isn't a SPIFFE ID", id)
+	}
+	return u.Host, nil
+}
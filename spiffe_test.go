@@ -0,0 +1,172 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSPIFFEBundle(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ca := caCert(t, caKey)
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	b := &SPIFFEBundle{
+		JWKS: JWKS{Keys: []*JWK{
+			{Use: SPIFFEUseX509SVID, Key: &caKey.PublicKey, Certificates: []*x509.Certificate{ca}},
+			{Use: SPIFFEUseJWTSVID, KeyID: "jwt-1", Key: &jwtKey.PublicKey},
+		}},
+		TrustDomain: "example.org",
+		Sequence:    42,
+		RefreshHint: 5 * time.Minute,
+	}
+	bs, err := json.Marshal(b)
+	require.NoError(t, err)
+	fields := map[string]any{}
+	require.NoError(t, json.Unmarshal(bs, &fields))
+	require.Equal(t, 42.0, fields["spiffe_sequence"])
+	require.Equal(t, 300.0, fields["spiffe_refresh_hint"])
+
+	got, diags, err := ParseSPIFFEBundle("example.org", bs)
+	require.NoError(t, err)
+	require.Empty(t, diags)
+	require.Equal(t, "example.org", got.TrustDomain)
+	require.Equal(t, uint64(42), got.Sequence)
+	require.Equal(t, 5*time.Minute, got.RefreshHint)
+	require.Len(t, got.X509Authorities(), 1)
+	require.True(t, ca.Equal(got.X509Authorities()[0]))
+	require.Len(t, got.JWTAuthorities().Keys, 1)
+	require.Equal(t, "jwt-1", got.JWTAuthorities().Keys[0].KeyID)
+
+	// Unmarshalling keeps the trust domain we set
+	got = &SPIFFEBundle{TrustDomain: "example.org"}
+	require.NoError(t, json.Unmarshal(bs, got))
+	require.Equal(t, "example.org", got.TrustDomain)
+	require.Len(t, got.Keys, 2)
+
+	// Keys must suit their use
+	_, err = json.Marshal(&SPIFFEBundle{JWKS: JWKS{Keys: []*JWK{{Use: SPIFFEUseX509SVID, Key: &caKey.PublicKey}}}})
+	require.ErrorContains(t, err, "x5c")
+	_, err = json.Marshal(&SPIFFEBundle{JWKS: JWKS{Keys: []*JWK{{Use: SPIFFEUseJWTSVID, Key: &jwtKey.PublicKey}}}})
+	require.ErrorContains(t, err, "kid")
+	_, err = json.Marshal(&SPIFFEBundle{JWKS: JWKS{Keys: []*JWK{{Use: SPIFFEUseJWTSVID, KeyID: "k", Key: jwtKey}}}})
+	require.ErrorIs(t, err, ErrPrivateKey)
+}
+
+func TestSPIFFEBundleParsing(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	x5c := base64.StdEncoding.EncodeToString(caCert(t, caKey).Raw)
+	j, err := Key2JWKMarshaler(&caKey.PublicKey)
+	require.NoError(t, err)
+	crv := `"kty":"EC","crv":"P-256","x":"` + mustMember(t, j, "x") + `","y":"` + mustMember(t, j, "y") + `"`
+
+	// As SPIRE exports them, with a key for some other use thrown in, and some broken ones
+	doc := `{
+		"keys": [
+			{"use":"x509-svid",` + crv + `,"x5c":["` + x5c + `"]},
+			{"use":"jwt-svid","kid":"a",` + crv + `},
+			{"use":"sig","kid":"b",` + crv + `},
+			{"use":"jwt-svid",` + crv + `},
+			{"use":"x509-svid",` + crv + `},
+			{"use":"jwt-svid","kid":"c","kty":"oct","k":"AAAA"}
+		],
+		"spiffe_sequence": 7,
+		"spiffe_refresh_hint": 60
+	}`
+
+	b, diags, err := ParseSPIFFEBundle("example.org", []byte(doc))
+	require.NoError(t, err)
+	require.Len(t, b.Keys, 2)
+	require.Len(t, diags, 3)
+	require.Equal(t, 3, diags[0].Index)
+	require.ErrorContains(t, diags[0], "kid")
+	require.Equal(t, 4, diags[1].Index)
+	require.ErrorContains(t, diags[1], "x5c")
+	require.Equal(t, 5, diags[2].Index)
+	require.ErrorIs(t, diags[2], ErrUnsupportedKeyType)
+	require.Equal(t, uint64(7), b.Sequence)
+	require.Equal(t, time.Minute, b.RefreshHint)
+
+	// Strictly, that's not ok
+	err = json.Unmarshal([]byte(doc), &SPIFFEBundle{})
+	require.ErrorContains(t, err, "kid")
+}
+
+func mustMember(t *testing.T, j *JWK, member string) string {
+	bs, err := json.Marshal(j)
+	require.NoError(t, err)
+	m := map[string]any{}
+	require.NoError(t, json.Unmarshal(bs, &m))
+	return m[member].(string)
+}
+
+func TestVerifyJWTSVID(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	b := &SPIFFEBundle{
+		JWKS: JWKS{Keys: []*JWK{
+			{Use: SPIFFEUseX509SVID, KeyID: "ca", Key: &caKey.PublicKey, Certificates: []*x509.Certificate{caCert(t, caKey)}},
+			{Use: SPIFFEUseJWTSVID, KeyID: "jwt-1", Key: &jwtKey.PublicKey},
+		}},
+		TrustDomain: "example.org",
+	}
+
+	svid := func(key any, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+		c := jwt.MapClaims{"sub": "spiffe://example.org/ns/default/sa/web", "aud": []string{"api"}, "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range claims {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		tok := jwt.NewWithClaims(method, c)
+		tok.Header["kid"] = kid
+		s, err := tok.SignedString(key)
+		require.NoError(t, err)
+		return s
+	}
+
+	got, err := b.VerifyJWTSVID(svid(jwtKey, jwt.SigningMethodES256, "jwt-1", nil), "api")
+	require.NoError(t, err)
+	require.Equal(t, "spiffe://example.org/ns/default/sa/web", got.ID)
+	require.Equal(t, []string{"api"}, got.Audience)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	cases := []struct {
+		name   string
+		tok    string
+		aud    string
+		expect string
+	}{
+		{"wrong audience", svid(jwtKey, jwt.SigningMethodES256, "jwt-1", nil), "other", "audience"},
+		{"other trust domain", svid(jwtKey, jwt.SigningMethodES256, "jwt-1", jwt.MapClaims{"sub": "spiffe://example.com/web"}), "api", "isn't in trust domain example.org"},
+		{"not a SPIFFE ID", svid(jwtKey, jwt.SigningMethodES256, "jwt-1", jwt.MapClaims{"sub": "https://example.org/web"}), "api", "isn't a SPIFFE ID"},
+		{"expired", svid(jwtKey, jwt.SigningMethodES256, "jwt-1", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}), "api", "expired"},
+		{"no exp", svid(jwtKey, jwt.SigningMethodES256, "jwt-1", jwt.MapClaims{"exp": nil}), "api", "exp"},
+		{"x509 authority", svid(caKey, jwt.SigningMethodES256, "ca", nil), "api", `no signing key with kid "ca"`},
+		{"unknown key", svid(rsaKey, jwt.SigningMethodRS256, "jwt-2", nil), "api", `no signing key with kid "jwt-2"`},
+		{"HMAC", svid([]byte("secret"), jwt.SigningMethodHS256, "jwt-1", nil), "api", "signing method HS256 is invalid"},
+		{"no audience asked for", svid(jwtKey, jwt.SigningMethodES256, "jwt-1", nil), "", "audience is required"},
+	}
+	for _, cse := range cases {
+		_, err := b.VerifyJWTSVID(cse.tok, cse.aud)
+		require.ErrorIs(t, err, ErrInvalidSVID, cse.name)
+		require.ErrorContains(t, err, cse.expect, cse.name)
+	}
+}
@@ -0,0 +1,235 @@
+package jwks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Streaming APIs, for key sets too large to comfortably hold as one []byte (eg OpenID Federation trust anchors with thousands of keys).
+// The Decoder pulls one JWK at a time out of a JWKS document, and the Encoder writes them out one at a time.
+
+// ===
+// Decoder
+// ===
+
+type decoderState int
+
+const (
+	decoderStart decoderState = iota
+	decoderInKeys
+	decoderAfterKeys
+	decoderDone
+)
+
+type Decoder struct {
+	// ContinueOnError, if set, lets decoding carry on past a key that can't be parsed (eg unknown kty).
+	// Next still returns a *KeyError for that key, but the following call will move on to the next key.
+	// Otherwise, the first such error is returned from every subsequent call.
+	// Malformed JSON always stops decoding, as there's no way to resynchronise.
+	ContinueOnError bool
+	// SkipConsistencyChecks is passed through to each key; see UnmarshalOptions
+	SkipConsistencyChecks bool
+	// ECDH is passed through to each key; see UnmarshalOptions
+	ECDH bool
+
+	rejectPrivate bool // see UnmarshalOptions; set by Unmarshal, which does the rejecting
+
+	dec   *json.Decoder
+	state decoderState
+	index int
+	err   error // sticky
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next key in the set. It returns io.EOF once the set has been fully read.
+// A key which can't be parsed gives a *KeyError; see ContinueOnError.
+func (d *Decoder) Next() (*JWK, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	for {
+		switch d.state {
+		case decoderStart:
+			if err := d.expectDelim('{'); err != nil {
+				return nil, d.fail(err)
+			}
+			if err := d.seekKeys(); err != nil {
+				return nil, d.fail(err)
+			}
+			d.state = decoderInKeys
+
+		case decoderInKeys:
+			if !d.dec.More() {
+				if err := d.expectDelim(']'); err != nil {
+					return nil, d.fail(err)
+				}
+				d.state = decoderAfterKeys
+				continue
+			}
+
+			raw := json.RawMessage{}
+			if err := d.dec.Decode(&raw); err != nil {
+				return nil, d.fail(err)
+			}
+			k := &JWK{}
+			err := k.unmarshal(raw, UnmarshalOptions{SkipConsistencyChecks: d.SkipConsistencyChecks, ECDH: d.ECDH, RejectPrivate: d.rejectPrivate})
+			i := d.index
+			d.index++
+			if err != nil {
+				kErr := &KeyError{Index: i, KeyID: k.KeyID, Err: err}
+				if !d.ContinueOnError {
+					d.err = kErr
+				}
+				return nil, kErr
+			}
+			return k, nil
+
+		case decoderAfterKeys:
+			// Consume, and ignore, any members after "keys", so that we find out if the document is well-formed
+			for d.dec.More() {
+				name, err := d.memberName()
+				if err != nil {
+					return nil, d.fail(err)
+				}
+				if name == "keys" {
+					return nil, d.fail(fmt.Errorf("duplicate \"keys\" member"))
+				}
+				if err := d.skipValue(); err != nil {
+					return nil, d.fail(err)
+				}
+			}
+			if err := d.expectDelim('}'); err != nil {
+				return nil, d.fail(err)
+			}
+			// As with json.Unmarshal, only whitespace may follow the document
+			if tok, err := d.dec.Token(); !errors.Is(err, io.EOF) {
+				if err == nil {
+					err = fmt.Errorf("unexpected %v after document", tok)
+				}
+				return nil, d.fail(err)
+			}
+			d.state = decoderDone
+
+		case decoderDone:
+			return nil, io.EOF
+		}
+	}
+}
+
+// seekKeys skips object members until it's consumed the opening of the "keys" array
+func (d *Decoder) seekKeys() error {
+	for d.dec.More() {
+		name, err := d.memberName()
+		if err != nil {
+			return err
+		}
+		if name != "keys" {
+			if err := d.skipValue(); err != nil {
+				return err
+			}
+			continue
+		}
+		return d.expectDelim('[')
+	}
+	return fmt.Errorf("JWKS has no \"keys\" member")
+}
+
+func (d *Decoder) memberName() (string, error) {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return "", err
+	}
+	name, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("expected object member name, got %v", tok)
+	}
+	return name, nil
+}
+
+func (d *Decoder) skipValue() error {
+	return d.dec.Decode(&json.RawMessage{})
+}
+
+func (d *Decoder) expectDelim(delim json.Delim) error {
+	tok, err := d.dec.Token()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v, got %v", delim, tok)
+	}
+	return nil
+}
+
+func (d *Decoder) fail(err error) error {
+	d.err = fmt.Errorf("malformed JWKS: %w", err)
+	return d.err
+}
+
+// ===
+// Encoder
+// ===
+
+// Encoder writes a JWKS one key at a time. The output is the same as json.Marshal of a JWKS of the same keys, including `{"keys":[]}` for none.
+// The exception is that clashing kids aren't refused, as spotting them would mean remembering every key.
+// Close must be called to finish the document.
+type Encoder struct {
+	// AllowPrivate allows private keys to be encoded. Like JWKS.MarshalJSON, by default they're refused with a PrivateKeyError.
+	AllowPrivate bool
+
+	w      io.Writer
+	count  int
+	closed bool
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (e *Encoder) Encode(k *JWK) error {
+	if e.closed {
+		return fmt.Errorf("encoder is closed")
+	}
+
+	bs, err := MarshalOptions{AllowPrivate: e.AllowPrivate}.marshalKey(k)
+	if err != nil {
+		return &KeyError{Index: e.count, KeyID: k.KeyID, Err: err}
+	}
+
+	prefix := ","
+	if e.count == 0 {
+		prefix = `{"keys":[`
+	}
+	if _, err := io.WriteString(e.w, prefix); err != nil {
+		return err
+	}
+	if _, err := e.w.Write(bs); err != nil {
+		return err
+	}
+	e.count++
+	return nil
+}
+
+// Close terminates the JWKS document. It doesn't close the underlying writer.
+func (e *Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	suffix := "]}"
+	if e.count == 0 {
+		suffix = `{"keys":[]}`
+	}
+	_, err := io.WriteString(e.w, suffix)
+	return err
+}
@@ -0,0 +1,121 @@
+package jwks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamIdentity(t *testing.T) {
+	for _, cse := range append(allPublics, allPrivates...) {
+		dec := NewDecoder(strings.NewReader(cse.jwks))
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.AllowPrivate = true
+		for {
+			k, err := dec.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			require.NoError(t, enc.Encode(k))
+		}
+		require.NoError(t, enc.Close())
+
+		require.Equal(t, cse.jwks, buf.String(), "JWKS->Decoder->Encoder->JWKS is not identity")
+	}
+}
+
+func TestEncoderEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	require.NoError(t, enc.Close())
+	require.Equal(t, `{"keys":[]}`, buf.String())
+	bs, err := json.Marshal(&JWKS{})
+	require.NoError(t, err)
+	require.Equal(t, string(bs), buf.String())
+	require.Error(t, enc.Encode(&JWK{}))
+}
+
+const streamBadKey = `{"kid":"bad","kty":"OCT","k":"AAAA"}`
+
+func TestDecoderErrors(t *testing.T) {
+	ec := strings.TrimSuffix(strings.TrimPrefix(publics[2].jwks, `{"keys":[`), `]}`)
+	doc := `{"other":{"keys":[]},"keys":[` + ec + `,` + streamBadKey + `,` + ec + `],"trailing":[1,2]}`
+
+	// Stop at first bad key
+	dec := NewDecoder(strings.NewReader(doc))
+	_, err := dec.Next()
+	require.NoError(t, err)
+	_, err = dec.Next()
+	kErr := &KeyError{}
+	require.ErrorAs(t, err, &kErr)
+	require.Equal(t, 1, kErr.Index)
+	require.Equal(t, "bad", kErr.KeyID)
+	_, err = dec.Next()
+	require.ErrorAs(t, err, &kErr)
+
+	// Carry on past it
+	dec = NewDecoder(strings.NewReader(doc))
+	dec.ContinueOnError = true
+	var good, bad int
+	for {
+		_, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			require.ErrorAs(t, err, &kErr)
+			bad++
+		} else {
+			good++
+		}
+	}
+	require.Equal(t, 2, good)
+	require.Equal(t, 1, bad)
+
+	// Malformed documents
+	for _, doc := range []string{
+		`[]`,
+		`{"foo":1}`,
+		`{"keys":[` + ec,
+		`{"keys":[],"keys":[]}`,
+		`{"keys":null}`,
+		`{"keys":[]} garbage`,
+		`{"keys":[]}{}`,
+		`{"keys":[]}]`,
+	} {
+		dec = NewDecoder(strings.NewReader(doc))
+		dec.ContinueOnError = true
+		var err error
+		for err == nil {
+			_, err = dec.Next()
+		}
+		require.NotErrorIs(t, err, io.EOF, "document: %s", doc)
+		require.ErrorContains(t, err, "malformed JWKS", "document: %s", doc)
+	}
+}
+
+func TestEncoderRefusesPrivate(t *testing.T) {
+	set, err := PEM2JWKSMarshaler(privates[0].pem)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	err = enc.Encode(set.Keys[0])
+	require.ErrorIs(t, err, ErrPrivateKey)
+	require.Empty(t, buf.String(), "nothing should be written for a refused key")
+}
+
+func TestUnmarshalTrailingData(t *testing.T) {
+	_, _, err := UnmarshalOptions{}.Unmarshal([]byte(publics[0].jwks + " \n\t"))
+	require.NoError(t, err)
+	_, _, err = UnmarshalOptions{}.Unmarshal([]byte(publics[0].jwks + `{"keys":[]}`))
+	require.ErrorContains(t, err, "malformed JWKS")
+	require.Error(t, json.Unmarshal([]byte(publics[0].jwks+"x"), &JWKS{}))
+}
@@ -3,12 +3,20 @@
 openssl genrsa -out rsa.pem 4096
 openssl rsa -in rsa.pem -pubout -out rsa.pub.pem
 
-# Has to be this curve rather than the more common secp256k1 or Go will spit it out
+# RSA restricted to PSS signatures (id-RSASSA-PSS), with parameters matching JWA's PS384
+openssl genpkey -algorithm RSA-PSS -pkeyopt rsa_keygen_bits:4096 -pkeyopt rsa_pss_keygen_md:sha384 -pkeyopt rsa_pss_keygen_mgf1_md:sha384 -pkeyopt rsa_pss_keygen_saltlen:48 -out rsa-pss.pem
+openssl pkey -in rsa-pss.pem -pubout -out rsa-pss.pub.pem
+
+# Use the NIST curve for the main EC key, as it's what the stdlib understands
 # * See list with openssl ecparam -list_curves
 openssl ecparam -name prime256v1 -genkey -noout -out ecdsa.pem
 openssl pkcs8 -topk8 -inform PEM -outform PEM -in ecdsa.pem -nocrypt | sponge ecdsa.pem
 openssl ec -in ecdsa.pem -pubout -out ecdsa.pub.pem
 
+# secp256k1 (ES256K) isn't known to Go's x509 package, but we handle it ourselves
+openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:secp256k1 -out secp256k1.pem
+openssl pkey -in secp256k1.pem -pubout -out secp256k1.pub.pem
+
 openssl genpkey -algorithm ed25519 -out ed25519.pem
 openssl pkey -in ed25519.pem -pubout -out ed25519.pub.pem
 
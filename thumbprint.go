@@ -0,0 +1,58 @@
+package jwks
+
+import (
+	"crypto"
+	_ "crypto/sha256" // Register SHA256, which Thumbprint callers almost always want
+	"encoding/json"
+	"fmt"
+)
+
+// RFC 7638 JWK Thumbprints: a hash over just the required public members of a key, so that the same key always has the same thumbprint regardless of kid, alg, privateness, etc.
+
+// Required members for each kty, already in lexicographic order (RFC 7638 §3.2)
+var thumbprintMembers = map[string][]string{
+	"RSA": {"e", "kty", "n"},
+	"EC":  {"crv", "kty", "x", "y"},
+	"OKP": {"crv", "kty", "x"},
+}
+
+// Thumbprint computes the RFC 7638 thumbprint of the key, using the given hash (usually crypto.SHA256).
+// For private keys, this is the thumbprint of the public part.
+func (k *JWK) Thumbprint(h crypto.Hash) ([]byte, error) {
+	if !h.Available() {
+		return nil, fmt.Errorf("hash function %v is not available", h)
+	}
+
+	pubKey, err := KeyPublicPartErr(k.Key)
+	if err != nil {
+		return nil, err
+	}
+	pub := &JWK{Key: pubKey}
+	rendered, err := pub.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]any{}
+	if err := json.Unmarshal(rendered, &fields); err != nil {
+		return nil, err
+	}
+
+	kty, _ := fields["kty"].(string)
+	members, ok := thumbprintMembers[kty]
+	if !ok {
+		return nil, &UnsupportedKeyTypeError{KeyType: kty, Format: "RFC 7638 thumbprint"}
+	}
+	required := map[string]any{}
+	for _, m := range members {
+		required[m] = fields[m]
+	}
+	// encoding/json sorts map keys and emits no whitespace, and none of these values need escaping, so this is the RFC 7638 form
+	canonical, err := json.Marshal(required)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := h.New()
+	hasher.Write(canonical)
+	return hasher.Sum(nil), nil
+}
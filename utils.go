@@ -1,14 +1,14 @@
 package jwks
 
-import "encoding/json"
-
+// The string-returning convenience functions all go through here, and thus refuse to render private keys.
+// To render them, take the two steps: get the Marshaler, and render it with MarshalOptions{AllowPrivate: true}.
 func marshaler2JSON[T any, U any](data T, fn func(T) (U, error)) (string, error) {
 	m, err := fn(data)
 	if err != nil {
 		return "", err
 	}
 
-	str, err := json.Marshal(m)
+	str, err := MarshalOptions{}.Marshal(m)
 	if err != nil {
 		return "", err
 	}
@@ -2,7 +2,10 @@ package jwks
 
 import (
 	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
 	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 )
 
@@ -17,21 +20,52 @@ type actualPrivate interface {
 	Equal(x crypto.PrivateKey) bool
 }
 
+// KeyIsPrivate reports whether key is one of the stdlib's (or this package's) private key types.
+// It panics if key is neither a crypto.PublicKey nor crypto.PrivateKey.
+//
+// Deprecated: use KeyIsPrivateErr, which returns an error instead.
 func KeyIsPrivate(key any) bool {
+	priv, err := KeyIsPrivateErr(key)
+	if err != nil {
+		panic(err)
+	}
+	return priv
+}
+
+// KeyIsPrivateErr reports whether key is one of the stdlib's (or this package's) private key types.
+// Anything that's neither a crypto.PublicKey nor crypto.PrivateKey is an UnsupportedKeyTypeError.
+func KeyIsPrivateErr(key any) (bool, error) {
 	if _, ok := key.(actualPublic); ok {
-		return false
+		return false, nil
 	} else if _, ok := key.(actualPrivate); ok {
-		return true
+		return true, nil
 	} else {
-		panic(fmt.Errorf("%T is neither crypto.[Public,Private]Key", key))
+		return false, &UnsupportedKeyTypeError{KeyType: fmt.Sprintf("%T (neither crypto.[Public,Private]Key)", key)}
 	}
 }
 
+// KeyPublicPart returns the public part of a private key, or a public key as-is.
+// It panics if key is neither a crypto.PublicKey nor crypto.PrivateKey.
+//
+// Deprecated: use KeyPublicPartErr, which returns an error instead.
 func KeyPublicPart(key any) crypto.PublicKey {
-	if KeyIsPrivate(key) {
-		return key.(actualPrivate).Public()
+	pub, err := KeyPublicPartErr(key)
+	if err != nil {
+		panic(err)
+	}
+	return pub
+}
+
+// KeyPublicPartErr returns the public part of a private key, or a public key as-is.
+func KeyPublicPartErr(key any) (crypto.PublicKey, error) {
+	priv, err := KeyIsPrivateErr(key)
+	if err != nil {
+		return nil, err
+	}
+	if priv {
+		return key.(actualPrivate).Public(), nil
 	} else {
-		return key
+		return key, nil
 	}
 }
 
@@ -43,6 +77,7 @@ func KeyPublicPart(key any) crypto.PublicKey {
 // * a PKCS#1 (ie ASN.1 encoding) containing a private key, RSA-only
 // * a PKCS#8 (ie ASN.1 encoding) containing a private key
 // * a SEC 1 (ie ASN.1 encoding) containing an EC private key
+// Including for key types the stdlib's x509 doesn't know: secp256k1, Ed448, X448, and RSA-PSS.
 func parseDER(der []byte) (any, error) {
 
 	if pubKey, err := x509.ParsePKIXPublicKey(der); err == nil { // returns type "any" (will be *rsa.PublicKey, *dsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, *ecdh.PublicKey). All stdlib PubKey types do conform to an unnamed iface: https://pkg.go.dev/crypto#PublicKey
@@ -57,13 +92,38 @@ func parseDER(der []byte) (any, error) {
 		return privKey, nil
 	} else if privKey, err := x509.ParseECPrivateKey(der); err == nil { // ECDSA only; type *ecdsa.PrivateKey
 		return privKey, nil
+	} else if key, ok := parseK256DER(der); ok { // x509 doesn't know secp256k1; type *ecdsa.[Public,Private]Key
+		return key, nil
+	} else if key, ok := parseCurve448DER(der); ok { // x509 doesn't know Ed448 or X448; types are this package's
+		return key, nil
+	} else if key, ok := parseRSAPSSDER(der); ok { // x509 refuses id-RSASSA-PSS keys; type *RSAPSS[Public,Private]Key
+		return key, nil
 	} else {
-		return nil, fmt.Errorf("DER block does not encode a recognised cryptographic object")
+		return nil, fmt.Errorf("DER block does not encode a recognised cryptographic object: %w", ErrUnsupportedKeyType)
 	}
 }
 
 func renderDER(key any) ([]byte, error) {
-	if !KeyIsPrivate(key) {
+	priv, err := KeyIsPrivateErr(key)
+	if err != nil {
+		return nil, err
+	}
+	if IsSecp256k1(key) {
+		if priv {
+			return marshalK256PKCS8PrivateKey(key.(*ecdsa.PrivateKey))
+		}
+		return marshalK256PKIXPublicKey(key.(*ecdsa.PublicKey))
+	}
+	if der, ok, err := renderCurve448DER(key); ok {
+		return der, err
+	}
+	switch k := key.(type) {
+	case *RSAPSSPublicKey:
+		return marshalRSAPSSPKIXPublicKey(k)
+	case *RSAPSSPrivateKey:
+		return marshalRSAPSSPKCS8PrivateKey(k)
+	}
+	if !priv {
 		// We chose to represent all public keys as PKIX ASN.1 DER. This is openssl 3.1.2's default for all of them anyway.
 		return x509.MarshalPKIXPublicKey(key)
 	} else {
@@ -71,3 +131,52 @@ func renderDER(key any) ([]byte, error) {
 		return x509.MarshalPKCS8PrivateKey(key)
 	}
 }
+
+// x5c members are standard (not URL) base64 DER certificates, leaf first (RFC 7517 §4.7)
+func certificates2X5C(certs []*x509.Certificate) []string {
+	if len(certs) == 0 {
+		return nil
+	}
+	out := make([]string, len(certs))
+	for i, cert := range certs {
+		out[i] = base64.StdEncoding.EncodeToString(cert.Raw)
+	}
+	return out
+}
+
+// x5c2Certificates parses an x5c member, checking that the leaf certificate is for key.
+// The chain isn't verified; that needs a trust anchor, which is for the caller to supply.
+func x5c2Certificates(x5c []string, key any) ([]*x509.Certificate, error) {
+	if len(x5c) == 0 {
+		return nil, nil
+	}
+	certs := make([]*x509.Certificate, len(x5c))
+	for i, b64 := range x5c {
+		der, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return nil, &InvalidParameterError{Member: "x5c", Err: fmt.Errorf("certificate %d: %w", i, err)}
+		}
+		certs[i], err = x509.ParseCertificate(der)
+		if err != nil {
+			return nil, &InvalidParameterError{Member: "x5c", Err: fmt.Errorf("certificate %d: %w", i, err)}
+		}
+	}
+
+	pub, err := KeyPublicPartErr(key)
+	if err != nil {
+		return nil, err
+	}
+	certKey := certs[0].PublicKey
+	// with UnmarshalOptions.ECDH, the key will have been converted, but the certificate's won't have been
+	if ecdsaKey, ok := certKey.(*ecdsa.PublicKey); ok {
+		if _, ok := pub.(*ecdh.PublicKey); ok {
+			if certKey, err = ecdsaKey.ECDH(); err != nil {
+				return nil, &InvalidParameterError{Member: "x5c", Err: err}
+			}
+		}
+	}
+	if certPub, ok := certKey.(actualPublic); !ok || !certPub.Equal(pub) {
+		return nil, &InvalidParameterError{Member: "x5c", Err: fmt.Errorf("leaf certificate isn't for this key")}
+	}
+	return certs, nil
+}